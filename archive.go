@@ -0,0 +1,577 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// 压缩包格式与限制
+const (
+	ArchiveZip   = "zip"    // ZIP
+	ArchiveTar   = "tar"    // 未压缩TAR
+	ArchiveTarGz = "tar.gz" // gzip压缩的TAR
+)
+
+var (
+	maxArchiveEntries            = 10000    // 最大条目数
+	maxArchiveExpandedSize int64 = 10 << 30 // 解压后最大总大小（10GB）
+
+	errArchiveTooManyEntries = errors.New("archive exceeds entry count limit")
+	errArchiveTooLarge       = errors.New("archive exceeds expanded size limit")
+	errStopWalk              = errors.New("stop walk")
+)
+
+// ArchiveEntry 压缩包条目
+type ArchiveEntry struct {
+	Name           string    `json:"name"`                      // 条目路径（已规范化）
+	Size           int64     `json:"size"`                      // 解压后大小
+	CompressedSize int64     `json:"compressed_size,omitempty"` // 压缩后大小（仅ZIP）
+	Mode           string    `json:"mode"`                      // 权限
+	ModTime        time.Time `json:"modified_at"`               // 修改时间
+	IsDir          bool      `json:"is_dir"`                    // 是否为目录
+	Regular        bool      `json:"-"`                         // 是否为普通文件
+}
+
+// ExtractRequest 解压请求
+type ExtractRequest struct {
+	Folder string `json:"folder,omitempty"` // 目标文件夹，默认为压缩包同目录下以其名称命名的文件夹
+}
+
+// detectArchiveFormat 通过文件头识别压缩包格式，不支持时返回空字符串
+func detectArchiveFormat(file *FileRecord) (string, error) {
+	f, err := openStoredFile(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return ArchiveZip, nil
+	case isTarHeader(head):
+		return ArchiveTar, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		// gzip 需确认内部为 tar
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", nil
+		}
+		defer gz.Close()
+		inner := make([]byte, 512)
+		n, _ := io.ReadFull(gz, inner)
+		if isTarHeader(inner[:n]) {
+			return ArchiveTarGz, nil
+		}
+	}
+	return "", nil
+}
+
+// isTarHeader 判断是否为 tar 头（ustar 魔数位于偏移257）
+func isTarHeader(head []byte) bool {
+	return len(head) >= 262 && string(head[257:262]) == "ustar"
+}
+
+// sanitizeEntryName 规范化条目路径，拒绝绝对路径与路径穿越（zip-slip）
+func sanitizeEntryName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", fmt.Errorf("absolute path in archive: %s", name)
+	}
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("path traversal in archive: %s", name)
+	}
+	if cleaned == "." {
+		return "", fmt.Errorf("empty entry name")
+	}
+	return cleaned, nil
+}
+
+// limitedCounter 统计读取字节数，超过上限时报错，防御声明大小不实的压缩炸弹
+type limitedCounter struct {
+	r     io.Reader
+	n     *int64
+	limit int64
+}
+
+func (lc limitedCounter) Read(p []byte) (int, error) {
+	n, err := lc.r.Read(p)
+	*lc.n += int64(n)
+	if *lc.n > lc.limit {
+		return n, errArchiveTooLarge
+	}
+	return n, err
+}
+
+// walkArchive 遍历压缩包条目
+// fn 中可调用 open 读取当前条目内容；条目名不安全的条目会被跳过
+func walkArchive(file *FileRecord, format string, fn func(entry *ArchiveEntry, open func() (io.ReadCloser, error)) error) error {
+	count := 0
+	var expanded int64
+	check := func() error {
+		count++
+		if count > maxArchiveEntries {
+			return errArchiveTooManyEntries
+		}
+		return nil
+	}
+
+	// 存储文件可能经过压缩，统一通过 storedFile 随机读取
+	f, err := openStoredFile(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == ArchiveZip {
+		zr, err := zip.NewReader(f, f.Size())
+		if err != nil {
+			return err
+		}
+		for _, zf := range zr.File {
+			if err := check(); err != nil {
+				return err
+			}
+			name, err := sanitizeEntryName(zf.Name)
+			if err != nil {
+				slog.Warn("Skip archive entry", "upload_id", file.UploadID, "err", err)
+				continue
+			}
+			mode := zf.Mode()
+			entry := &ArchiveEntry{
+				Name:           name,
+				Size:           int64(zf.UncompressedSize64),
+				CompressedSize: int64(zf.CompressedSize64),
+				Mode:           mode.String(),
+				ModTime:        zf.Modified,
+				IsDir:          mode.IsDir(),
+				Regular:        mode.IsRegular(),
+			}
+			zf := zf
+			err = fn(entry, func() (io.ReadCloser, error) {
+				rc, err := zf.Open()
+				if err != nil {
+					return nil, err
+				}
+				return struct {
+					io.Reader
+					io.Closer
+				}{limitedCounter{rc, &expanded, maxArchiveExpandedSize}, rc}, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var src io.Reader = f
+	if format == ArchiveTarGz {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		// 解压后的整体流量计入上限（跳过条目时同样会解压）
+		src = limitedCounter{gz, &expanded, maxArchiveExpandedSize}
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := check(); err != nil {
+			return err
+		}
+		name, err := sanitizeEntryName(hdr.Name)
+		if err != nil {
+			slog.Warn("Skip archive entry", "upload_id", file.UploadID, "err", err)
+			continue
+		}
+		info := hdr.FileInfo()
+		entry := &ArchiveEntry{
+			Name:    name,
+			Size:    hdr.Size,
+			Mode:    info.Mode().String(),
+			ModTime: hdr.ModTime,
+			IsDir:   hdr.Typeflag == tar.TypeDir,
+			Regular: hdr.Typeflag == tar.TypeReg,
+		}
+		err = fn(entry, func() (io.ReadCloser, error) {
+			if format == ArchiveTar {
+				return io.NopCloser(limitedCounter{tr, &expanded, maxArchiveExpandedSize}), nil
+			}
+			return io.NopCloser(tr), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// loadArchiveFile 加载文件记录并校验其为当前用户可访问的压缩包
+// 校验失败时已写入错误响应
+func loadArchiveFile(w http.ResponseWriter, r *http.Request) (*FileRecord, string, bool) {
+	uploadID := mux.Vars(r)["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
+	file, err := getFileByUploadID(dbCtx(r), uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "File not found")
+			return nil, "", false
+		}
+		logger.Error("Database query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, "", false
+	}
+	if file.UserID != "" && file.UserID != getUserID(r) && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Not the owner of this file")
+		return nil, "", false
+	}
+	if !checkFileAccessible(w, file) {
+		return nil, "", false
+	}
+	if file.ClientEncryption != nil {
+		writeError(w, http.StatusConflict, "File is client-encrypted and cannot be read on the server")
+		return nil, "", false
+	}
+
+	format, err := detectArchiveFormat(file)
+	if err != nil {
+		logger.Error("Detect archive format error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Server error")
+		return nil, "", false
+	}
+	if format == "" {
+		writeError(w, http.StatusUnsupportedMediaType, "File is not a zip, tar or tar.gz archive")
+		return nil, "", false
+	}
+	return file, format, true
+}
+
+// writeArchiveError 输出遍历压缩包时的错误
+func writeArchiveError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errArchiveTooManyEntries), errors.Is(err, errArchiveTooLarge):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		reqLogger(r).Error("Read archive error", "err", err)
+		writeError(w, http.StatusUnprocessableEntity, "Corrupt or unreadable archive")
+	}
+}
+
+// ListArchiveEntries 列出压缩包条目（不解压）
+// GET /api/v1/files/{upload_id}/archive/entries
+func ListArchiveEntries(w http.ResponseWriter, r *http.Request) {
+	file, format, ok := loadArchiveFile(w, r)
+	if !ok {
+		return
+	}
+
+	entries := []*ArchiveEntry{}
+	var totalSize int64
+	err := walkArchive(file, format, func(e *ArchiveEntry, _ func() (io.ReadCloser, error)) error {
+		entries = append(entries, e)
+		totalSize += e.Size
+		return nil
+	})
+	if err != nil {
+		writeArchiveError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"upload_id":  file.UploadID,
+		"format":     format,
+		"count":      len(entries),
+		"total_size": totalSize,
+		"entries":    entries,
+	})
+}
+
+// DownloadArchiveEntry 流式下载压缩包中的单个条目
+// GET /api/v1/files/{upload_id}/archive/entry?path=dir/file.txt
+func DownloadArchiveEntry(w http.ResponseWriter, r *http.Request) {
+	file, format, ok := loadArchiveFile(w, r)
+	if !ok {
+		return
+	}
+	target, err := sanitizeEntryName(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid entry path")
+		return
+	}
+
+	err = walkArchive(file, format, func(e *ArchiveEntry, open func() (io.ReadCloser, error)) error {
+		if e.Name != target || !e.Regular {
+			return nil
+		}
+		rc, err := open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if ct := mime.TypeByExtension(path.Ext(e.Name)); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": path.Base(e.Name),
+		}))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", e.Size))
+		if _, err := io.CopyN(servedWriter{w}, rc, e.Size); err != nil {
+			reqLogger(r).With("upload_id", file.UploadID).Error("Stream archive entry error", "entry", e.Name, "err", err)
+		}
+		return errStopWalk
+	})
+	switch {
+	case err == errStopWalk:
+	case err == nil:
+		writeError(w, http.StatusNotFound, "Archive entry not found")
+	default:
+		writeArchiveError(w, r, err)
+	}
+}
+
+// ExtractArchive 将压缩包解压到文件夹，每个文件生成一条新的文件记录
+// POST /api/v1/files/{upload_id}/archive/extract
+func ExtractArchive(w http.ResponseWriter, r *http.Request) {
+	file, format, ok := loadArchiveFile(w, r)
+	if !ok {
+		return
+	}
+	userID := getUserID(r)
+	ctx := dbCtx(r)
+
+	var req ExtractRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	if req.Folder == "" {
+		req.Folder = path.Join(file.Folder, archiveBaseName(file.FileName))
+	}
+	folder, err := normalizeFolder(req.Folder)
+	if err != nil || folder == "" {
+		writeError(w, http.StatusBadRequest, "Invalid folder")
+		return
+	}
+
+	// 第一遍：依据条目头校验数量与总大小，尽早拒绝压缩炸弹
+	var declared int64
+	err = walkArchive(file, format, func(e *ArchiveEntry, _ func() (io.ReadCloser, error)) error {
+		declared += e.Size
+		if declared > maxArchiveExpandedSize {
+			return errArchiveTooLarge
+		}
+		return nil
+	})
+	if err != nil {
+		writeArchiveError(w, r, err)
+		return
+	}
+
+	// 第二遍：写入文件与记录，失败时回滚已创建的内容
+	var created []*FileRecord
+	rollback := func() {
+		// 解压失败多因客户端断开或请求超时，回滚不能随请求取消
+		rbctx := context.WithoutCancel(ctx)
+		logger := reqLogger(r).With("upload_id", file.UploadID)
+		for _, c := range created {
+			if err := uploadStore.DeleteUpload(rbctx, c.UploadID); err != nil {
+				logger.Error("Rollback extracted record error", "file_id", c.UploadID, "err", err)
+			}
+			path := finalFilePath(c.UploadID, c.FileName)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				logger.Error("Rollback extracted file error", "path", path, "err", err)
+			}
+		}
+	}
+	err = walkArchive(file, format, func(e *ArchiveEntry, open func() (io.ReadCloser, error)) error {
+		if !e.Regular {
+			return nil
+		}
+		rc, err := open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		record, err := storeExtractedEntry(ctx, rc, e, userID, path.Join(folder, path.Dir(e.Name)))
+		if err != nil {
+			return err
+		}
+		created = append(created, record)
+		return nil
+	})
+	if err != nil {
+		rollback()
+		writeArchiveError(w, r, err)
+		return
+	}
+
+	for _, c := range created {
+		publishUploadEvent(ctx, EventUploadCompleted, c, &CompleteResponse{
+			Status:    StatusCompleted,
+			FinalPath: finalFilePath(c.UploadID, c.FileName),
+			FileSize:  c.FileSize,
+		}, nil)
+		enqueueProcessing(ctx, c.UploadID)
+		enqueueReplication(ctx, c.UploadID)
+	}
+	reqLogger(r).With("upload_id", file.UploadID).Info("Archive extracted", "folder", folder, "files", len(created))
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"upload_id": file.UploadID,
+		"folder":    folder,
+		"count":     len(created),
+		"files":     created,
+	})
+}
+
+// storeExtractedEntry 将条目写入存储目录并创建已完成的文件记录
+func storeExtractedEntry(ctx context.Context, r io.Reader, e *ArchiveEntry, userID, folder string) (*FileRecord, error) {
+	folder, err := normalizeFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	record := &FileRecord{
+		UploadID:  uuid.New().String(),
+		FileName:  path.Base(e.Name),
+		Status:    StatusCompleted,
+		UserID:    userID,
+		Folder:    folder,
+		OwnerNode: clusterNodeID,
+	}
+	// 与完成上传相同：启用病毒扫描时在扫描完成前禁止下载，扫描由调用方加入处理队列后执行
+	if clamdAddress != "" {
+		record.ScanStatus = ScanScanning
+	}
+
+	record.Compression = chooseCompression("", record.FileName)
+	if record.KeyID, record.WrappedKey, err = newDataKey(); err != nil {
+		return nil, err
+	}
+	record.Encrypted = record.KeyID != ""
+	sp, err := fileStoreParams(record)
+	if err != nil {
+		return nil, err
+	}
+
+	finalPath := finalFilePath(record.UploadID, record.FileName)
+	out, err := createStoredFile(finalPath, sp)
+	if err != nil {
+		return nil, err
+	}
+	hasher := md5.New()
+	n, err := io.Copy(io.MultiWriter(out, hasher), r)
+	if err != nil {
+		out.Abort()
+		return nil, err
+	}
+	storedSize, err := out.Commit()
+	if err != nil {
+		return nil, err
+	}
+	record.FileSize = n
+	record.StoredSize = storedSize
+	record.ContentMD5 = hex.EncodeToString(hasher.Sum(nil))
+
+	// 解压生成的文件未经分片上传，分片字段记为0
+	if err := uploadStore.CreateUpload(ctx, record, nil); err != nil {
+		os.Remove(finalPath)
+		return nil, err
+	}
+	return record, nil
+}
+
+// archiveBaseName 去除压缩包扩展名
+func archiveBaseName(fileName string) string {
+	base := filepath.Base(fileName)
+	lower := strings.ToLower(base)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) && len(base) > len(ext) {
+			return base[:len(base)-len(ext)]
+		}
+	}
+	return base + "_extracted"
+}
+
+// archiveProcessor 压缩包索引处理器：记录条目数量与解压后大小
+type archiveProcessor struct{}
+
+func (archiveProcessor) Name() string { return "archive" }
+
+func (archiveProcessor) Applies(job *ProcessJob) bool {
+	format, err := detectArchiveFormat(job.File)
+	return err == nil && format != ""
+}
+
+func (archiveProcessor) Process(ctx context.Context, job *ProcessJob) (interface{}, error) {
+	format, err := detectArchiveFormat(job.File)
+	if err != nil {
+		return nil, err
+	}
+	var files, dirs int
+	var totalSize int64
+	err = walkArchive(job.File, format, func(e *ArchiveEntry, _ func() (io.ReadCloser, error)) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.IsDir {
+			dirs++
+		} else {
+			files++
+		}
+		totalSize += e.Size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"format":        format,
+		"files":         files,
+		"directories":   dirs,
+		"expanded_size": totalSize,
+	}, nil
+}
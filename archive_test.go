@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSanitizeEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // 为空表示拒绝
+	}{
+		{"a.txt", "a.txt"},
+		{"docs/a.txt", "docs/a.txt"},
+		{"docs/./a.txt", "docs/a.txt"},
+		{"docs//a.txt", "docs/a.txt"},
+		{"docs/sub/../a.txt", "docs/a.txt"},
+		{"docs\\a.txt", "docs/a.txt"},
+		{"dir/", "dir"},
+		{"..a", "..a"},
+		{"a..b/c", "a..b/c"},
+		{"..", ""},
+		{"../evil.txt", ""},
+		{"../../etc/passwd", ""},
+		{"docs/../../evil.txt", ""},
+		{"..\\evil.txt", ""},
+		{"docs\\..\\..\\evil.txt", ""},
+		{"/etc/passwd", ""},
+		{"\\evil.txt", ""},
+		{"C:\\Windows\\evil.txt", ""},
+		{"c:evil.txt", ""},
+		{".", ""},
+		{"./", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := sanitizeEntryName(tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("sanitizeEntryName(%q) = %q, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("sanitizeEntryName(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// zipSlipEntries 包含路径穿越与绝对路径条目的压缩包内容，只有 safe 中的条目可被解压
+var (
+	zipSlipEntries = []string{"docs/a.txt", "../evil.txt", "/abs.txt", "C:\\win.txt", "docs/../../escape.txt", "..\\back.txt", "b/./c.txt"}
+	zipSlipSafe    = []string{"b/c.txt", "docs/a.txt"}
+)
+
+func buildZip(t *testing.T, names []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("content of " + name))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTar(t *testing.T, names []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		body := []byte("content of " + name)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(body)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// archiveRouter 压缩包相关路由
+func archiveRouter() http.Handler {
+	r := mux.NewRouter()
+	files := r.PathPrefix("/api/v1/files").Subrouter()
+	files.HandleFunc("/{upload_id}/archive/entries", ListArchiveEntries).Methods("GET")
+	files.HandleFunc("/{upload_id}/archive/entry", DownloadArchiveEntry).Methods("GET")
+	files.HandleFunc("/{upload_id}/archive/extract", ExtractArchive).Methods("POST")
+	return r
+}
+
+func archiveRequest(router http.Handler, method, path string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	r.Header.Set("X-User-ID", "alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestArchiveZipSlip(t *testing.T) {
+	setupTestDB(t)
+	useProcessors(t)
+	router := archiveRouter()
+
+	for _, tc := range []struct {
+		format string
+		data   []byte
+	}{
+		{ArchiveZip, buildZip(t, zipSlipEntries)},
+		{ArchiveTar, buildTar(t, zipSlipEntries)},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			id := "archive-" + tc.format
+			storeTestFile(t, id, tc.data)
+			base := "/api/v1/files/" + id + "/archive"
+
+			// 列表中只出现安全的条目
+			w := archiveRequest(router, "GET", base+"/entries", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("list entries: %d %s", w.Code, w.Body.String())
+			}
+			var list struct {
+				Entries []*ArchiveEntry `json:"entries"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &list)
+			var names []string
+			for _, e := range list.Entries {
+				names = append(names, e.Name)
+			}
+			sort.Strings(names)
+			if len(names) != len(zipSlipSafe) || names[0] != zipSlipSafe[0] || names[1] != zipSlipSafe[1] {
+				t.Fatalf("entries = %v, want %v", names, zipSlipSafe)
+			}
+
+			// 单条目下载拒绝穿越路径
+			for _, p := range []string{"../evil.txt", "/abs.txt", "docs/../../escape.txt"} {
+				if w := archiveRequest(router, "GET", base+"/entry?path="+url.QueryEscape(p), nil); w.Code != http.StatusBadRequest {
+					t.Errorf("download entry %q: status %d", p, w.Code)
+				}
+			}
+
+			// 解压只在目标文件夹内创建安全条目
+			w = archiveRequest(router, "POST", base+"/extract", []byte(`{"folder":"out"}`))
+			if w.Code != http.StatusCreated {
+				t.Fatalf("extract: %d %s", w.Code, w.Body.String())
+			}
+			background.Wait()
+			var res struct {
+				Files []*FileRecord `json:"files"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+			got := map[string]bool{}
+			for _, f := range res.Files {
+				got[f.Folder+"/"+f.FileName] = true
+			}
+			if len(got) != 2 || !got["out/docs/a.txt"] || !got["out/b/c.txt"] {
+				t.Fatalf("extracted files = %v", got)
+			}
+			if _, err := db.Exec("DELETE FROM uploads WHERE folder LIKE 'out%'"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
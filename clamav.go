@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 病毒扫描状态（uploads.scan_status）
+const (
+	ScanScanning = "scanning" // 扫描中，禁止下载
+	ScanClean    = "clean"    // 未发现病毒
+	ScanInfected = "infected" // 发现病毒，已隔离
+	ScanError    = "error"    // 扫描失败，禁止下载
+
+	clamavName     = "clamav"         // 病毒扫描处理器名称
+	clamdChunkSize = 64 << 10         // INSTREAM 每次发送的数据块大小
+	clamdTimeout   = 30 * time.Second // 连接与读写超时（每个数据块重新计算）
+)
+
+// clamdClient clamd INSTREAM 协议客户端
+type clamdClient struct {
+	network string // tcp 或 unix
+	address string // 地址或套接字路径
+}
+
+// newClamdClient 解析 clamd 地址
+// 支持 tcp://host:port、unix:///path/to/clamd.sock 与不带前缀的 host:port
+func newClamdClient(addr string) (*clamdClient, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		return &clamdClient{network: "unix", address: strings.TrimPrefix(addr, "unix://")}, nil
+	case strings.HasPrefix(addr, "tcp://"):
+		return &clamdClient{network: "tcp", address: strings.TrimPrefix(addr, "tcp://")}, nil
+	case strings.HasPrefix(addr, "/"):
+		return &clamdClient{network: "unix", address: addr}, nil
+	case strings.Contains(addr, ":"):
+		return &clamdClient{network: "tcp", address: addr}, nil
+	}
+	return nil, fmt.Errorf("invalid clamd address: %s", addr)
+}
+
+// Scan 通过 INSTREAM 命令扫描数据流
+// 返回发现的病毒签名，未发现时为空
+func (c *clamdClient) Scan(ctx context.Context, r io.Reader) (string, error) {
+	dialer := net.Dialer{Timeout: clamdTimeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("connect clamd: %v", err)
+	}
+	defer conn.Close()
+
+	// 上下文取消时立即中断阻塞的读写
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(clamdTimeout))
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("send INSTREAM: %v", err)
+	}
+
+	// 数据块格式：4字节大端长度 + 数据，以长度0结束
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			conn.SetDeadline(time.Now().Add(clamdTimeout))
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			_, err := conn.Write(size[:])
+			if err == nil {
+				_, err = conn.Write(buf[:n])
+			}
+			if err != nil {
+				return clamdWriteFailed(conn, "send chunk", err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return clamdWriteFailed(conn, "send terminator", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(clamdTimeout))
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return "", fmt.Errorf("read clamd reply: %v", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// clamdWriteFailed 处理写入失败：clamd 超出 StreamMaxLength 时会回复后提前关闭连接，
+// 此时长度头或数据块的写入都可能失败，优先返回其回复
+func clamdWriteFailed(conn net.Conn, op string, err error) (string, error) {
+	if reply, rerr := readClamdReply(conn); rerr == nil {
+		return parseClamdReply(reply)
+	}
+	return "", fmt.Errorf("%s: %v", op, err)
+}
+
+// readClamdReply 读取以 \0 结尾的回复
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(err == io.EOF && reply != "") {
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// parseClamdReply 解析回复：
+// "stream: OK"、"stream: <签名> FOUND" 或 "<原因> ERROR"
+func parseClamdReply(reply string) (string, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// clamavProcessor 病毒扫描处理器，需先于其他处理器执行
+type clamavProcessor struct {
+	client *clamdClient
+}
+
+func (clamavProcessor) Name() string                 { return clamavName }
+func (clamavProcessor) Applies(job *ProcessJob) bool { return true }
+
+func (p clamavProcessor) Process(ctx context.Context, job *ProcessJob) (interface{}, error) {
+	uploadID := job.File.UploadID
+	// 已隔离的文件保留原结论，不再重新扫描
+	if job.File.ScanStatus == ScanInfected {
+		job.Halt = true
+		return map[string]interface{}{"verdict": ScanInfected}, nil
+	}
+	// 扫描超时或失败后仍需写入扫描状态，数据库操作不随处理器上下文取消
+	dbctx := context.WithoutCancel(ctx)
+	if err := setScanStatus(dbctx, uploadID, ScanScanning); err != nil {
+		return nil, err
+	}
+
+	f, err := job.Open()
+	if err != nil {
+		setScanStatus(dbctx, uploadID, ScanError)
+		return nil, err
+	}
+	signature, err := p.client.Scan(ctx, f)
+	f.Close()
+	if err != nil {
+		setScanStatus(dbctx, uploadID, ScanError)
+		return nil, err
+	}
+
+	if signature == "" {
+		if err := setScanStatus(dbctx, uploadID, ScanClean); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"verdict": ScanClean}, nil
+	}
+
+	// 发现病毒：标记状态、隔离文件并通知所有者，后续处理器不再执行
+	logger := slog.With("upload_id", uploadID)
+	logger.Warn("Upload infected, moving to quarantine", "signature", signature)
+	job.Halt = true
+	if err := setScanStatus(dbctx, uploadID, ScanInfected); err != nil {
+		return nil, err
+	}
+	if err := quarantineFile(job.File); err != nil {
+		logger.Error("Quarantine file error", "err", err)
+	}
+	publishUploadEvent(dbctx, EventFileInfected, job.File, nil, fmt.Errorf("virus found: %s", signature))
+
+	return map[string]interface{}{
+		"verdict":   ScanInfected,
+		"signature": signature,
+	}, nil
+}
+
+// setScanStatus 更新扫描状态
+func setScanStatus(ctx context.Context, uploadID, status string) error {
+	_, err := db.ExecContext(ctx, "UPDATE uploads SET scan_status = ?, updated_at = updated_at WHERE upload_id = ?", status, uploadID)
+	return err
+}
+
+// quarantinePath 计算隔离区中的文件路径
+func quarantinePath(uploadID, fileName string) string {
+	return filepath.Join(quarantineDir, fmt.Sprintf("%s_%s", uploadID, filepath.Base(fileName)))
+}
+
+// quarantineFile 将文件移出存储目录，使下载路由无法访问
+func quarantineFile(file *FileRecord) error {
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		return err
+	}
+	dst := quarantinePath(file.UploadID, file.FileName)
+	if err := os.Rename(storedFilePath(file), dst); err != nil {
+		return err
+	}
+	return os.Chmod(dst, 0400)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeClamd 实现 zINSTREAM 协议的 clamd，收到全部数据后回复 reply；
+// maxLen > 0 时模拟 StreamMaxLength，超出后立即回复错误并关闭连接
+type fakeClamd struct {
+	ln       net.Listener
+	reply    string
+	maxLen   int
+	received chan []byte // 每个连接收到的数据
+}
+
+func newFakeClamd(t *testing.T, reply string, maxLen int) *fakeClamd {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeClamd{ln: ln, reply: reply, maxLen: maxLen, received: make(chan []byte, 8)}
+	go fc.serve()
+	t.Cleanup(func() { ln.Close() })
+	return fc
+}
+
+func (fc *fakeClamd) client() *clamdClient {
+	return &clamdClient{network: "tcp", address: fc.ln.Addr().String()}
+}
+
+func (fc *fakeClamd) serve() {
+	for {
+		conn, err := fc.ln.Accept()
+		if err != nil {
+			return
+		}
+		go fc.handle(conn)
+	}
+}
+
+func (fc *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	cmd := make([]byte, len("zINSTREAM\x00"))
+	if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var data bytes.Buffer
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, conn, int64(n)); err != nil {
+			return
+		}
+		if fc.maxLen > 0 && data.Len() > fc.maxLen {
+			// 与 clamd 一致：不再读取剩余数据，回复后直接关闭
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			fc.received <- data.Bytes()
+			return
+		}
+	}
+	fc.received <- data.Bytes()
+	conn.Write([]byte(fc.reply + "\x00"))
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply     string
+		signature string
+		wantErr   string
+	}{
+		{"stream: OK", "", ""},
+		{"stream: Eicar-Test-Signature FOUND", "Eicar-Test-Signature", ""},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND", "Win.Test.EICAR_HDB-1", ""},
+		{"INSTREAM size limit exceeded. ERROR", "", "size limit exceeded"},
+		{"stream: lstat() failed: No such file or directory. ERROR", "", "lstat() failed"},
+		{"UNKNOWN COMMAND", "", "UNKNOWN COMMAND"},
+	}
+	for _, tt := range tests {
+		sig, err := parseClamdReply(tt.reply)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseClamdReply(%q) err = %v, want %q", tt.reply, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || sig != tt.signature {
+			t.Errorf("parseClamdReply(%q) = %q, %v; want %q", tt.reply, sig, err, tt.signature)
+		}
+	}
+}
+
+func TestNewClamdClient(t *testing.T) {
+	tests := []struct {
+		addr, network, address string
+	}{
+		{"tcp://clamd:3310", "tcp", "clamd:3310"},
+		{"clamd:3310", "tcp", "clamd:3310"},
+		{"unix:///run/clamd.sock", "unix", "/run/clamd.sock"},
+		{"/run/clamd.sock", "unix", "/run/clamd.sock"},
+	}
+	for _, tt := range tests {
+		c, err := newClamdClient(tt.addr)
+		if err != nil || c.network != tt.network || c.address != tt.address {
+			t.Errorf("newClamdClient(%q) = %+v, %v", tt.addr, c, err)
+		}
+	}
+	if _, err := newClamdClient("clamd"); err == nil {
+		t.Error("newClamdClient without port should fail")
+	}
+}
+
+func TestClamdScan(t *testing.T) {
+	// 超过单个数据块，确认分块发送后数据完整
+	data := bytes.Repeat([]byte("0123456789abcdef"), clamdChunkSize/8)
+
+	fc := newFakeClamd(t, "stream: OK", 0)
+	sig, err := fc.client().Scan(context.Background(), bytes.NewReader(data))
+	if err != nil || sig != "" {
+		t.Fatalf("Scan clean = %q, %v", sig, err)
+	}
+	if got := <-fc.received; !bytes.Equal(got, data) {
+		t.Fatalf("clamd received %d bytes, want %d", len(got), len(data))
+	}
+
+	fc = newFakeClamd(t, "stream: Eicar-Test-Signature FOUND", 0)
+	sig, err = fc.client().Scan(context.Background(), bytes.NewReader(data))
+	if err != nil || sig != "Eicar-Test-Signature" {
+		t.Fatalf("Scan infected = %q, %v", sig, err)
+	}
+}
+
+func TestClamdScanStreamMaxLength(t *testing.T) {
+	// clamd 提前关闭连接后客户端写入失败，应返回 clamd 的回复而不是写入错误
+	fc := newFakeClamd(t, "stream: OK", clamdChunkSize)
+	data := make([]byte, 64<<20)
+	_, err := fc.client().Scan(context.Background(), bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("Scan over StreamMaxLength err = %v, want size limit error", err)
+	}
+	if got := <-fc.received; len(got) >= len(data) {
+		t.Fatalf("clamd read the whole stream (%d bytes)", len(got))
+	}
+}
+
+func TestClamdScanConnectError(t *testing.T) {
+	fc := newFakeClamd(t, "stream: OK", 0)
+	client := fc.client()
+	fc.ln.Close()
+	if _, err := client.Scan(context.Background(), strings.NewReader("data")); err == nil {
+		t.Fatal("Scan with clamd down should fail")
+	}
+}
+
+// createScanFile 写入一个已完成的上传记录及其存储文件
+func createScanFile(t *testing.T, uploadID string, content []byte) *FileRecord {
+	t.Helper()
+	file := &FileRecord{
+		UploadID:    uploadID,
+		FileName:    "report.pdf",
+		FileSize:    int64(len(content)),
+		ChunkSize:   len(content),
+		TotalChunks: 1,
+		Status:      StatusCompleted,
+		UserID:      "alice",
+	}
+	if err := uploadStore.CreateUpload(context.Background(), file, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(storedFilePath(file), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func scanStatusOf(t *testing.T, uploadID string) string {
+	t.Helper()
+	file, err := uploadStore.GetUpload(context.Background(), uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.ScanStatus
+}
+
+func TestClamavProcessorClean(t *testing.T) {
+	setupTestDB(t)
+	fc := newFakeClamd(t, "stream: OK", 0)
+	file := createScanFile(t, "u-clean", []byte("hello"))
+
+	job := &ProcessJob{File: file, Path: storedFilePath(file)}
+	if _, err := (clamavProcessor{client: fc.client()}).Process(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Halt {
+		t.Fatal("clean file should not halt processing")
+	}
+	if got := scanStatusOf(t, file.UploadID); got != ScanClean {
+		t.Fatalf("scan_status = %q, want %q", got, ScanClean)
+	}
+	if _, err := os.Stat(storedFilePath(file)); err != nil {
+		t.Fatalf("clean file moved: %v", err)
+	}
+}
+
+func TestClamavProcessorQuarantine(t *testing.T) {
+	setupTestDB(t)
+	fc := newFakeClamd(t, "stream: Eicar-Test-Signature FOUND", 0)
+	file := createScanFile(t, "u-infected", []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR"))
+
+	job := &ProcessJob{File: file, Path: storedFilePath(file)}
+	result, err := (clamavProcessor{client: fc.client()}).Process(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.Halt {
+		t.Fatal("infected file should halt processing")
+	}
+	if m := result.(map[string]interface{}); m["signature"] != "Eicar-Test-Signature" {
+		t.Fatalf("result = %v", m)
+	}
+	if got := scanStatusOf(t, file.UploadID); got != ScanInfected {
+		t.Fatalf("scan_status = %q, want %q", got, ScanInfected)
+	}
+
+	if _, err := os.Stat(storedFilePath(file)); !os.IsNotExist(err) {
+		t.Fatalf("infected file still in store: %v", err)
+	}
+	fi, err := os.Stat(quarantinePath(file.UploadID, file.FileName))
+	if err != nil {
+		t.Fatalf("quarantined file missing: %v", err)
+	}
+	if fi.Mode().Perm() != 0400 {
+		t.Fatalf("quarantined file mode = %v, want 0400", fi.Mode().Perm())
+	}
+}
+
+func TestClamavProcessorScanError(t *testing.T) {
+	setupTestDB(t)
+	fc := newFakeClamd(t, "stream: lstat() failed. ERROR", 0)
+	file := createScanFile(t, "u-error", []byte("hello"))
+
+	job := &ProcessJob{File: file, Path: storedFilePath(file)}
+	if _, err := (clamavProcessor{client: fc.client()}).Process(context.Background(), job); err == nil {
+		t.Fatal("clamd error should fail the processor")
+	}
+	if got := scanStatusOf(t, file.UploadID); got != ScanError {
+		t.Fatalf("scan_status = %q, want %q", got, ScanError)
+	}
+}
+
+func TestRerunInfectedFile(t *testing.T) {
+	setupTestDB(t)
+	fc := newFakeClamd(t, "stream: Eicar-Test-Signature FOUND", 0)
+	useProcessors(t, clamavName, "mime")
+	file := createScanFile(t, "u-infected", []byte("eicar"))
+
+	job := &ProcessJob{File: file, Path: storedFilePath(file)}
+	if _, err := (clamavProcessor{client: fc.client()}).Process(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/process", "/process/" + clamavName, "/process/mime"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/files/u-infected"+path, nil)
+		r.Header.Set("X-User-ID", "alice")
+		vars := map[string]string{"upload_id": "u-infected"}
+		if name, ok := strings.CutPrefix(path, "/process/"); ok {
+			vars["processor"] = name
+		}
+		w := httptest.NewRecorder()
+		RerunProcessor(w, mux.SetURLVars(r, vars))
+		if w.Code != http.StatusConflict {
+			t.Errorf("rerun %s on infected file: status %d, want 409", path, w.Code)
+		}
+	}
+
+	// 直接执行（如启动恢复）时保留结论，不访问已隔离的文件
+	infected, err := uploadStore.GetUpload(context.Background(), "u-infected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = &ProcessJob{File: infected, Path: storedFilePath(infected)}
+	if _, err := (clamavProcessor{client: fc.client()}).Process(context.Background(), job); err != nil || !job.Halt {
+		t.Fatalf("rescan infected file: halt=%v err=%v", job.Halt, err)
+	}
+	if got := scanStatusOf(t, "u-infected"); got != ScanInfected {
+		t.Fatalf("scan_status = %q, want %q", got, ScanInfected)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// 集群模式：各节点只使用本地磁盘（shared-nothing），上传任务在创建时记录所属节点（uploads.owner_node），
+// 分片、合并文件与最终文件都只存在于该节点。其他节点收到针对该任务的分片上传、完成、下载、删除等请求时
+// 透明转发给所属节点；节点每隔 heartbeat_interval 在 cluster_nodes 表中更新心跳，
+// 超过 node_timeout 未心跳视为下线。所属节点下线时，尚未接收分片的任务由收到请求的节点接管，
+// 其余请求返回 503，待节点恢复后重试。
+const clusterForwardedHeader = "X-Upload-Forwarded-By" // 转发请求携带的来源节点ID，防止循环转发
+
+var (
+	clusterNodeID            string                  // 本节点ID，为空表示未启用集群模式
+	clusterAdvertiseURL      string                  // 本节点对其他节点公布的地址
+	clusterHeartbeatInterval = 10 * time.Second      // 心跳间隔
+	clusterNodeTimeout       = 30 * time.Second      // 超过该时间未心跳视为下线
+	clusterHeartbeat         atomic.Int64            // 最近一次成功心跳的时间（UnixNano）
+	clusterTransport         = http.DefaultTransport // 转发请求使用的连接池
+
+	clusterProxied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "cluster_proxied_requests_total",
+		Help: "Requests forwarded to the node owning the upload, by outcome.",
+	}, []string{"result"})
+)
+
+// ClusterNode 集群节点
+type ClusterNode struct {
+	NodeID        string    `json:"node_id"`        // 节点ID
+	AdvertiseURL  string    `json:"advertise_url"`  // 转发地址
+	Version       string    `json:"version"`        // 构建版本
+	StartedAt     time.Time `json:"started_at"`     // 启动时间
+	LastHeartbeat time.Time `json:"last_heartbeat"` // 最近心跳时间
+	Alive         bool      `json:"alive"`          // 是否在线
+	Self          bool      `json:"self"`           // 是否为处理本次请求的节点
+}
+
+// defaultNodeID 默认节点ID取主机名
+func defaultNodeID() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// validNodeID 节点ID只允许字母、数字与 -_.，最长 64 字符
+func validNodeID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// initCluster 注册本节点并启动心跳协程，未启用集群模式时不做任何事
+func initCluster(ctx context.Context) error {
+	if clusterNodeID == "" {
+		return nil
+	}
+	if err := registerNode(ctx); err != nil {
+		return err
+	}
+	slog.Info("Cluster node registered", "node_id", clusterNodeID, "advertise_url", clusterAdvertiseURL)
+	goBackground(runClusterHeartbeat)
+	return nil
+}
+
+// registerNode 写入本节点记录，覆盖同ID节点的旧记录（如重启前的自身）
+func registerNode(ctx context.Context) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM cluster_nodes WHERE node_id = ?", clusterNodeID); err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO cluster_nodes (node_id, advertise_url, version, started_at, last_heartbeat) VALUES (?, ?, ?, ?, ?)",
+		clusterNodeID, clusterAdvertiseURL, version, startedAt, now,
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	clusterHeartbeat.Store(now.UnixNano())
+	return nil
+}
+
+// runClusterHeartbeat 定期更新心跳；记录被删除（如管理员清理）时重新注册
+func runClusterHeartbeat() {
+	ticker := time.NewTicker(clusterHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-backgroundCtx.Done():
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(backgroundCtx, clusterHeartbeatInterval)
+		now := time.Now()
+		res, err := db.ExecContext(ctx, "UPDATE cluster_nodes SET last_heartbeat = ?, advertise_url = ? WHERE node_id = ?",
+			now, clusterAdvertiseURL, clusterNodeID)
+		if n, _ := rowsAffected(res, err); err == nil && n == 0 {
+			slog.Warn("Cluster node record missing, registering again", "node_id", clusterNodeID)
+			err = registerNode(ctx)
+		} else if err == nil {
+			clusterHeartbeat.Store(now.UnixNano())
+		}
+		cancel()
+		if err != nil {
+			slog.Error("Cluster heartbeat error", "node_id", clusterNodeID, "err", err)
+		}
+	}
+}
+
+// rowsAffected 执行结果影响的行数
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// listClusterNodes 全部节点，按节点ID排序
+func listClusterNodes(ctx context.Context) ([]*ClusterNode, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT node_id, advertise_url, version, started_at, last_heartbeat
+		FROM cluster_nodes
+		ORDER BY node_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []*ClusterNode{}
+	for rows.Next() {
+		node, err := scanClusterNode(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// getClusterNode 获取节点，不存在时返回 sql.ErrNoRows
+func getClusterNode(ctx context.Context, nodeID string) (*ClusterNode, error) {
+	return scanClusterNode(db.QueryRowContext(ctx, `
+		SELECT node_id, advertise_url, version, started_at, last_heartbeat
+		FROM cluster_nodes
+		WHERE node_id = ?
+	`, nodeID))
+}
+
+// scanClusterNode 扫描节点记录并计算在线状态
+func scanClusterNode(row interface{ Scan(...interface{}) error }) (*ClusterNode, error) {
+	node := &ClusterNode{}
+	if err := row.Scan(&node.NodeID, &node.AdvertiseURL, &node.Version, &node.StartedAt, &node.LastHeartbeat); err != nil {
+		return nil, err
+	}
+	node.Alive = time.Since(node.LastHeartbeat) <= clusterNodeTimeout
+	node.Self = node.NodeID == clusterNodeID
+	return node, nil
+}
+
+// claimUpload 将所属节点已下线且尚未接收分片的任务转移到本节点，返回是否成功
+func claimUpload(ctx context.Context, file *FileRecord) (bool, error) {
+	if file.Status != StatusInProgress {
+		return false, nil
+	}
+	chunks, err := uploadStore.ListChunks(ctx, file.UploadID)
+	if err != nil || len(chunks) > 0 {
+		return false, err
+	}
+	// 以原所属节点为条件，并发接管时只有一个节点成功
+	n, err := rowsAffected(db.ExecContext(ctx, "UPDATE uploads SET owner_node = ? WHERE upload_id = ? AND owner_node = ?",
+		clusterNodeID, file.UploadID, file.OwnerNode))
+	return n > 0, err
+}
+
+// withUploadOwner 集群模式下将请求转发给上传任务的所属节点，本节点所属或任务不存在时直接处理
+func withUploadOwner(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if clusterNodeID == "" {
+			next(w, r)
+			return
+		}
+		file, err := uploadStore.GetUpload(dbCtx(r), mux.Vars(r)["upload_id"])
+		if err != nil || !forwardToOwner(w, r, file) {
+			next(w, r) // 由处理函数返回 404/500
+		}
+	}
+}
+
+// forwardToOwner 集群模式下将请求转发给文件的所属节点
+// 已转发或已写入错误响应时返回 true；本节点所属、未记录所属节点（启用集群模式前创建）
+// 或已从下线节点接管时返回 false，由调用方在本地处理
+func forwardToOwner(w http.ResponseWriter, r *http.Request, file *FileRecord) bool {
+	if clusterNodeID == "" || file.OwnerNode == "" || file.OwnerNode == clusterNodeID {
+		return false
+	}
+	ctx := dbCtx(r)
+	logger := reqLogger(r).With("upload_id", file.UploadID)
+	if from := r.Header.Get(clusterForwardedHeader); from != "" {
+		// 转发期间所属节点发生变化，交由客户端重试，避免循环转发
+		logger.Warn("Forwarded request for upload owned by another node", "from", from, "owner", file.OwnerNode)
+		writeError(w, http.StatusMisdirectedRequest, "Upload is owned by another node")
+		return true
+	}
+
+	node, err := getClusterNode(ctx, file.OwnerNode)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Error("Get cluster node error", "owner", file.OwnerNode, "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return true
+	}
+	if node == nil || !node.Alive {
+		claimed, err := claimUpload(ctx, file)
+		if err != nil {
+			logger.Error("Claim upload error", "owner", file.OwnerNode, "err", err)
+		}
+		if claimed {
+			logger.Info("Claimed upload from unavailable node", "owner", file.OwnerNode)
+			return false
+		}
+		clusterProxied.WithLabelValues("owner_down").Inc()
+		w.Header().Set("Retry-After", "30")
+		writeError(w, http.StatusServiceUnavailable, "Upload owner node is unavailable")
+		return true
+	}
+	proxyToNode(w, r, node)
+	return true
+}
+
+// proxyToNode 将请求原样转发给指定节点（保留方法、路径、查询参数与请求体）
+func proxyToNode(w http.ResponseWriter, r *http.Request, node *ClusterNode) {
+	target, err := url.Parse(node.AdvertiseURL)
+	if err != nil {
+		reqLogger(r).Error("Invalid node advertise URL", "node_id", node.NodeID, "url", node.AdvertiseURL, "err", err)
+		writeError(w, http.StatusBadGateway, "Upload owner node is unreachable")
+		return
+	}
+	requestID := w.Header().Get(requestIDHeader)
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			// 保留上游负载均衡设置的对外地址，所属节点生成的链接与直接访问时一致
+			for _, h := range []string{"X-Forwarded-Host", "X-Forwarded-Proto"} {
+				if v := pr.In.Header.Get(h); v != "" {
+					pr.Out.Header.Set(h, v)
+				}
+			}
+			pr.Out.Header.Set(clusterForwardedHeader, clusterNodeID)
+			pr.Out.Header.Set(requestIDHeader, requestID)
+			// CORS 由本节点处理，所属节点不再重复添加响应头
+			pr.Out.Header.Del("Origin")
+			otel.GetTextMapPropagator().Inject(pr.In.Context(), propagation.HeaderCarrier(pr.Out.Header))
+		},
+		Transport: clusterTransport,
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del(requestIDHeader) // 本节点已写入
+			clusterProxied.WithLabelValues("ok").Inc()
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			clusterProxied.WithLabelValues("error").Inc()
+			reqLogger(r).Warn("Forward to owner node error", "node_id", node.NodeID, "err", err)
+			w.Header().Set("Retry-After", "5")
+			writeError(w, http.StatusBadGateway, "Upload owner node is unreachable")
+		},
+	}
+	reqLogger(r).Debug("Forwarding request to owner node", "node_id", node.NodeID)
+	proxy.ServeHTTP(w, r)
+}
+
+// ListClusterNodes 查看集群节点与在线状态
+// GET /api/v1/admin/cluster/nodes
+func ListClusterNodes(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+	if clusterNodeID == "" {
+		writeError(w, http.StatusNotFound, "Cluster mode is not enabled")
+		return
+	}
+	nodes, err := listClusterNodes(dbCtx(r))
+	if err != nil {
+		reqLogger(r).Error("List cluster nodes error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"node_id": clusterNodeID,
+		"data":    nodes,
+	})
+}
+
+// checkCluster 检查本节点心跳是否正常
+func checkCluster() *HealthComponent {
+	c := &HealthComponent{Status: HealthOK, Details: map[string]interface{}{"node_id": clusterNodeID}}
+	ts := clusterHeartbeat.Load()
+	if ts == 0 {
+		c.fail("node not registered")
+		return c
+	}
+	last := time.Unix(0, ts)
+	c.Details["last_heartbeat"] = last.UTC()
+	if time.Since(last) > clusterNodeTimeout {
+		c.fail("heartbeat stalled, other nodes consider this node down")
+	}
+	return c
+}
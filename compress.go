@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩算法
+const (
+	CompressionNone = "none" // 不压缩
+	CompressionGzip = "gzip" // gzip
+	CompressionZstd = "zstd" // zstd
+)
+
+// 块存储格式：
+//
+//	[块0][块1]...[块N-1][索引: N×uint32 各块物理长度][尾部: 24字节]
+//
+// 每块独立压缩、加密，逻辑大小固定为 blockSize（最后一块可能较小），
+// 因此可按块随机读取以支持 Range 请求。加密块为 [nonce][密文][GCM tag]，
+// 附加数据为 [所属文件标识][块号][是否最后一块]：块不能被重排、在文件间替换，
+// 索引与尾部虽未加密，但截断尾部的块并改写尾部会因最后一块标志不符而被发现。
+// 加密文件至少包含一块（空文件为一个空的最后一块）。
+// 未加密的 zstd 文件中索引与尾部被包装为 skippable frame，整个文件仍是合法的 zstd 流。
+const (
+	storeBlockSize   = 1 << 20 // 每块逻辑大小（1MB）
+	storeTrailerSize = 24      // 尾部长度
+	storeMagic       = "UPCI"  // 尾部魔数
+	storeVersion     = 2       // 写入的格式版本
+
+	storeFlagEncrypted = 1 << 0 // 尾部标志：块已加密
+
+	zstdSkippableMagic = 0x184D2A5E // zstd skippable frame 魔数
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+
+	errBadStoredFile = errors.New("corrupt stored file trailer")
+)
+
+// storeParams 存储文件的编码参数
+type storeParams struct {
+	codec string      // 压缩算法，为空表示不压缩
+	aead  cipher.AEAD // 数据密钥，为nil表示不加密
+	aad   string      // 加密块绑定的文件标识（上传ID，分片与缩略图另加后缀）
+}
+
+// plain 是否原样存储（既不压缩也不加密）
+func (sp storeParams) plain() bool {
+	return sp.codec == "" && sp.aead == nil
+}
+
+// flags 尾部标志位
+func (sp storeParams) flags() byte {
+	if sp.aead != nil {
+		return storeFlagEncrypted
+	}
+	return 0
+}
+
+// codecID 压缩算法在尾部中的编号
+var codecID = map[string]byte{CompressionGzip: 1, CompressionZstd: 2}
+
+// validCompression 判断压缩算法是否受支持
+func validCompression(c string) bool {
+	return c == "" || c == CompressionNone || c == CompressionGzip || c == CompressionZstd
+}
+
+// chooseCompression 决定文件的压缩算法：上传请求指定优先，其次为全局策略
+// 已压缩格式（图片、视频、压缩包等）不再压缩
+func chooseCompression(requested, fileName string) string {
+	if requested != "" {
+		if requested == CompressionNone {
+			return ""
+		}
+		return requested
+	}
+	if compressionDefault == "" || compressionDefault == CompressionNone {
+		return ""
+	}
+	if storedExtensions[strings.ToLower(filepath.Ext(fileName))] {
+		return ""
+	}
+	return compressionDefault
+}
+
+// compressBlock 压缩单个块
+func compressBlock(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression: %s", codec)
+}
+
+// decompressBlock 解压单个块
+func decompressBlock(codec string, data []byte, sizeHint int) ([]byte, error) {
+	switch codec {
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(data, make([]byte, 0, sizeHint))
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		out := bytes.NewBuffer(make([]byte, 0, sizeHint))
+		_, err = io.Copy(out, zr)
+		return out.Bytes(), err
+	}
+	return nil, fmt.Errorf("unsupported compression: %s", codec)
+}
+
+// storedWriter 写入存储文件：先写入 .part 临时文件，Commit 时原子重命名
+type storedWriter struct {
+	storeParams
+
+	f       *os.File
+	path    string
+	buf     []byte   // 当前块缓冲
+	sizes   []uint32 // 各块物理长度
+	logical int64    // 已写入的逻辑字节数
+	written int64    // 已写入的物理字节数
+}
+
+// createStoredFile 创建存储文件写入器
+func createStoredFile(path string, sp storeParams) (*storedWriter, error) {
+	if sp.codec == CompressionNone {
+		sp.codec = ""
+	}
+	if sp.codec != "" && codecID[sp.codec] == 0 {
+		return nil, fmt.Errorf("unsupported compression: %s", sp.codec)
+	}
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, err
+	}
+	return &storedWriter{f: f, path: path, storeParams: sp}, nil
+}
+
+// Write 写入逻辑数据
+func (w *storedWriter) Write(p []byte) (int, error) {
+	if w.plain() {
+		n, err := w.f.Write(p)
+		w.logical += int64(n)
+		w.written += int64(n)
+		return n, err
+	}
+	total := len(p)
+	for len(p) > 0 {
+		// 写满的块在有后续数据时才写出，最后一块留到 Commit 时标记
+		if len(w.buf) == storeBlockSize {
+			if err := w.flushBlock(false); err != nil {
+				return total - len(p), err
+			}
+		}
+		n := storeBlockSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		w.logical += int64(n)
+	}
+	return total, nil
+}
+
+// flushBlock 压缩、加密并写出当前块，final 表示文件的最后一块
+// 加密文件的最后一块即使为空也会写出
+func (w *storedWriter) flushBlock(final bool) error {
+	if len(w.buf) == 0 && (!final || w.aead == nil) {
+		return nil
+	}
+	block := w.buf
+	if w.codec != "" {
+		var err error
+		if block, err = compressBlock(w.codec, block); err != nil {
+			return err
+		}
+	}
+	if w.aead != nil {
+		block = sealBlock(w.aead, blockAAD(w.aad, len(w.sizes), final), block)
+	}
+	if _, err := w.f.Write(block); err != nil {
+		return err
+	}
+	w.sizes = append(w.sizes, uint32(len(block)))
+	w.written += int64(len(block))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Commit 写出索引与尾部并重命名为最终路径，返回物理大小
+func (w *storedWriter) Commit() (int64, error) {
+	if !w.plain() {
+		if err := w.flushBlock(true); err != nil {
+			w.Abort()
+			return 0, err
+		}
+		if err := w.writeTrailer(); err != nil {
+			w.Abort()
+			return 0, err
+		}
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.path + ".part")
+		return 0, err
+	}
+	if err := os.Rename(w.path+".part", w.path); err != nil {
+		os.Remove(w.path + ".part")
+		return 0, err
+	}
+	return w.written, nil
+}
+
+// writeTrailer 写出块索引与尾部
+func (w *storedWriter) writeTrailer() error {
+	meta := make([]byte, 0, 8+4*len(w.sizes)+storeTrailerSize)
+	if w.codec == CompressionZstd && w.aead == nil {
+		meta = binary.LittleEndian.AppendUint32(meta, zstdSkippableMagic)
+		meta = binary.LittleEndian.AppendUint32(meta, uint32(4*len(w.sizes)+storeTrailerSize))
+	}
+	for _, s := range w.sizes {
+		meta = binary.LittleEndian.AppendUint32(meta, s)
+	}
+	meta = append(meta, storeMagic...)
+	meta = append(meta, storeVersion, codecID[w.codec], w.flags(), 0)
+	meta = binary.LittleEndian.AppendUint32(meta, storeBlockSize)
+	meta = binary.LittleEndian.AppendUint32(meta, uint32(len(w.sizes)))
+	meta = binary.LittleEndian.AppendUint64(meta, uint64(w.logical))
+	n, err := w.f.Write(meta)
+	w.written += int64(n)
+	return err
+}
+
+// Abort 放弃写入并删除临时文件
+func (w *storedWriter) Abort() {
+	w.f.Close()
+	os.Remove(w.path + ".part")
+}
+
+// storedFile 存储文件的只读视图，对压缩、加密文件透明解码
+// 实现 io.ReadSeeker 与 io.ReaderAt，可直接用于 http.ServeContent 与 zip.NewReader
+type storedFile struct {
+	storeParams
+
+	f         *os.File
+	size      int64   // 逻辑大小
+	blockSize int64   // 块逻辑大小
+	offsets   []int64 // 各块物理起始偏移，末尾追加数据区结束位置
+	pos       int64   // Read/Seek 当前位置
+
+	mu         sync.Mutex // 保护块缓存
+	cacheIndex int        // 缓存的块号
+	cacheData  []byte     // 缓存的块数据
+}
+
+// openStoredFile 打开文件记录对应的存储文件
+// 主副本缺失、大小与记录不符或无法解码时改读副本（见 openReplica）；
+// 完整性巡检标记为损坏的文件只读副本，没有可用副本时返回 errFileCorrupt
+func openStoredFile(file *FileRecord) (*storedFile, error) {
+	sp, err := fileStoreParams(file)
+	if err != nil {
+		return nil, err
+	}
+	if file.IntegrityStatus == IntegrityCorrupt {
+		return openReplica(file, sp, errFileCorrupt)
+	}
+	sf, err := openStoredPath(storedFilePath(file), sp)
+	if err == nil {
+		if err = checkStoredSize(sf, file); err != nil {
+			sf.Close()
+		}
+	}
+	if err != nil && len(replicaTargets) > 0 {
+		return openReplica(file, sp, err)
+	}
+	return sf, err
+}
+
+// openStoredPath 按编码参数打开存储文件
+func openStoredPath(path string, sp storeParams) (*storedFile, error) {
+	if sp.codec == CompressionNone {
+		sp.codec = ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sf := &storedFile{f: f, storeParams: sp, size: info.Size(), cacheIndex: -1}
+	if sp.plain() {
+		return sf, nil
+	}
+	if err := sf.readIndex(info.Size()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return sf, nil
+}
+
+// readIndex 读取尾部与块索引
+func (sf *storedFile) readIndex(physical int64) error {
+	if physical < storeTrailerSize {
+		return errBadStoredFile
+	}
+	trailer := make([]byte, storeTrailerSize)
+	if _, err := sf.f.ReadAt(trailer, physical-storeTrailerSize); err != nil {
+		return err
+	}
+	if string(trailer[:4]) != storeMagic || trailer[4] != storeVersion ||
+		trailer[5] != codecID[sf.codec] || trailer[6] != sf.flags() {
+		return errBadStoredFile
+	}
+	sf.blockSize = int64(binary.LittleEndian.Uint32(trailer[8:]))
+	count := int64(binary.LittleEndian.Uint32(trailer[12:]))
+	sf.size = int64(binary.LittleEndian.Uint64(trailer[16:]))
+	if sf.blockSize <= 0 || 4*count > physical-storeTrailerSize {
+		return errBadStoredFile
+	}
+
+	index := make([]byte, 4*count)
+	if _, err := sf.f.ReadAt(index, physical-storeTrailerSize-4*count); err != nil {
+		return err
+	}
+	sf.offsets = make([]int64, count+1)
+	for i := int64(0); i < count; i++ {
+		sf.offsets[i+1] = sf.offsets[i] + int64(binary.LittleEndian.Uint32(index[4*i:]))
+	}
+	if sf.offsets[count] > physical-storeTrailerSize-4*count {
+		return errBadStoredFile
+	}
+
+	// 加密文件校验最后一块的标志与逻辑大小，发现截断或改写的尾部
+	if sf.aead != nil {
+		if count == 0 {
+			return errBadStoredFile
+		}
+		last, err := sf.block(int(count - 1))
+		if err != nil {
+			return err
+		}
+		if sf.size != (count-1)*sf.blockSize+int64(len(last)) {
+			return errBadStoredFile
+		}
+	}
+	return nil
+}
+
+// Size 返回逻辑大小
+func (sf *storedFile) Size() int64 { return sf.size }
+
+// Close 关闭文件
+func (sf *storedFile) Close() error { return sf.f.Close() }
+
+// Read 实现 io.Reader
+func (sf *storedFile) Read(p []byte) (int, error) {
+	n, err := sf.ReadAt(p, sf.pos)
+	sf.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek 实现 io.Seeker
+func (sf *storedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sf.pos
+	case io.SeekEnd:
+		offset += sf.size
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	sf.pos = offset
+	return offset, nil
+}
+
+// ReadAt 实现 io.ReaderAt，按需解压涉及的块
+func (sf *storedFile) ReadAt(p []byte, off int64) (int, error) {
+	if sf.plain() {
+		return sf.f.ReadAt(p, off)
+	}
+	if off >= sf.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off < sf.size {
+		idx := int(off / sf.blockSize)
+		block, err := sf.block(idx)
+		if err != nil {
+			return n, err
+		}
+		inBlock := off - int64(idx)*sf.blockSize
+		if inBlock >= int64(len(block)) {
+			return n, errBadStoredFile
+		}
+		c := copy(p[n:], block[inBlock:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block 读取并解码第 idx 块（带单块缓存）
+func (sf *storedFile) block(idx int) ([]byte, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if idx == sf.cacheIndex {
+		return sf.cacheData, nil
+	}
+	if idx+1 >= len(sf.offsets) {
+		return nil, errBadStoredFile
+	}
+	raw := make([]byte, sf.offsets[idx+1]-sf.offsets[idx])
+	if _, err := sf.f.ReadAt(raw, sf.offsets[idx]); err != nil {
+		return nil, err
+	}
+	data := raw
+	if sf.aead != nil {
+		var err error
+		if data, err = openBlock(sf.aead, blockAAD(sf.aad, idx, idx == len(sf.offsets)-2), data); err != nil {
+			return nil, fmt.Errorf("decrypt block %d: %v", idx, err)
+		}
+	}
+	if sf.codec != "" {
+		var err error
+		if data, err = decompressBlock(sf.codec, data, int(sf.blockSize)); err != nil {
+			return nil, fmt.Errorf("decompress block %d: %v", idx, err)
+		}
+	}
+	// 除最后一块外每块的逻辑大小必须等于 blockSize，否则偏移计算会错位
+	if int64(len(data)) > sf.blockSize || (idx < len(sf.offsets)-2 && int64(len(data)) != sf.blockSize) {
+		return nil, errBadStoredFile
+	}
+	sf.cacheIndex, sf.cacheData = idx, data
+	return data, nil
+}
+
+// sealBlock 加密单个块：随机nonce，附加数据见 blockAAD
+func sealBlock(aead cipher.AEAD, aad, data []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, data, aad)
+}
+
+// openBlock 解密并校验单个块
+func openBlock(aead cipher.AEAD, aad, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, errBadStoredFile
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, data[:n], data[n:], aad)
+}
+
+// blockAAD 块的附加认证数据：[文件标识长度][文件标识][块号][是否最后一块]
+func blockAAD(fileID string, idx int, final bool) []byte {
+	aad := binary.BigEndian.AppendUint16(nil, uint16(len(fileID)))
+	aad = append(aad, fileID...)
+	aad = binary.BigEndian.AppendUint64(aad, uint64(idx))
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
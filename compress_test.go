@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testBlockSizes 覆盖空文件、单字节与块边界两侧的逻辑大小
+var testBlockSizes = []int{0, 1, storeBlockSize - 1, storeBlockSize, storeBlockSize + 1, storeBlockSize * 5 / 2}
+
+// testContent 生成可压缩但每个位置都不同的测试内容
+func testContent(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i/4096)
+	}
+	return data
+}
+
+// writeStored 按编码参数写入存储文件，返回路径与物理大小
+func writeStored(t *testing.T, sp storeParams, data []byte) (string, int64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stored")
+	w, err := createStoredFile(path, sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 分多次写入，写入边界与块边界不对齐
+	for p := data; len(p) > 0; {
+		n := min(len(p), 300000)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	physical, err := w.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != physical {
+		t.Fatalf("stat stored file: %v, size %v, Commit returned %d", err, info, physical)
+	}
+	return path, physical
+}
+
+func TestStoredFileRoundTrip(t *testing.T) {
+	for _, codec := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		for _, size := range testBlockSizes {
+			t.Run(fmt.Sprintf("%s/%d", codec, size), func(t *testing.T) {
+				data := testContent(size)
+				sp := storeParams{codec: codec}
+				path, physical := writeStored(t, sp, data)
+				if codec != CompressionNone && size >= storeBlockSize && physical >= int64(size) {
+					t.Errorf("physical size %d not smaller than logical %d", physical, size)
+				}
+
+				sf, err := openStoredPath(path, sp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer sf.Close()
+				if sf.Size() != int64(size) {
+					t.Fatalf("Size() = %d, want %d", sf.Size(), size)
+				}
+				got, err := io.ReadAll(sf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), size)
+				}
+			})
+		}
+	}
+}
+
+func TestStoredFileZstdStream(t *testing.T) {
+	// 未加密的 zstd 存储文件整体仍可被标准 zstd 解码器解码
+	data := testContent(storeBlockSize * 5 / 2)
+	path, _ := writeStored(t, storeParams{codec: CompressionZstd}, data)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := zstdDecoder.DecodeAll(raw, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("zstd stream decodes to %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestStoredFileReadAcrossBlocks(t *testing.T) {
+	const bs = storeBlockSize
+	data := testContent(bs * 5 / 2)
+	size := int64(len(data))
+	tests := []struct {
+		off int64
+		n   int
+	}{
+		{0, 10},
+		{bs - 10, 20},
+		{bs - 1, 2},
+		{bs, 1},
+		{2*bs - 5, 10},
+		{bs / 2, 2 * bs}, // 跨越三块
+		{0, len(data)},
+	}
+	for _, codec := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(codec, func(t *testing.T) {
+			sp := storeParams{codec: codec}
+			path, _ := writeStored(t, sp, data)
+			sf, err := openStoredPath(path, sp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sf.Close()
+
+			for _, tt := range tests {
+				p := make([]byte, tt.n)
+				if n, err := sf.ReadAt(p, tt.off); err != nil || n != tt.n || !bytes.Equal(p, data[tt.off:tt.off+int64(tt.n)]) {
+					t.Errorf("ReadAt(%d, %d) = %d, %v", tt.off, tt.n, n, err)
+				}
+				if _, err := sf.Seek(tt.off, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.ReadFull(sf, p); err != nil || !bytes.Equal(p, data[tt.off:tt.off+int64(tt.n)]) {
+					t.Errorf("Seek(%d) + Read(%d): %v", tt.off, tt.n, err)
+				}
+			}
+
+			// 读到文件末尾返回已读字节数与 io.EOF
+			p := make([]byte, 10)
+			if n, err := sf.ReadAt(p, size-4); n != 4 || err != io.EOF || !bytes.Equal(p[:4], data[size-4:]) {
+				t.Errorf("ReadAt past end = %d, %v", n, err)
+			}
+			if n, err := sf.ReadAt(p, size); n != 0 || err != io.EOF {
+				t.Errorf("ReadAt at end = %d, %v", n, err)
+			}
+			if pos, err := sf.Seek(-3, io.SeekEnd); err != nil || pos != size-3 {
+				t.Errorf("Seek(-3, SeekEnd) = %d, %v", pos, err)
+			}
+		})
+	}
+}
+
+func TestStoredFileServeRange(t *testing.T) {
+	const bs = storeBlockSize
+	data := testContent(bs * 5 / 2)
+	sp := storeParams{codec: CompressionZstd}
+	path, _ := writeStored(t, sp, data)
+
+	tests := []struct {
+		rng        string
+		start, end int // 期望返回 data[start:end]
+	}{
+		{fmt.Sprintf("bytes=%d-%d", bs-100, bs+99), bs - 100, bs + 100},
+		{fmt.Sprintf("bytes=%d-", 2*bs-1), 2*bs - 1, len(data)},
+		{"bytes=-1000", len(data) - 1000, len(data)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rng, func(t *testing.T) {
+			sf, err := openStoredPath(path, sp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sf.Close()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Range", tt.rng)
+			w := httptest.NewRecorder()
+			http.ServeContent(w, r, "a.bin", time.Time{}, sf)
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("status = %d, want 206", w.Code)
+			}
+			if !bytes.Equal(w.Body.Bytes(), data[tt.start:tt.end]) {
+				t.Fatalf("body: got %d bytes, want %d", w.Body.Len(), tt.end-tt.start)
+			}
+		})
+	}
+}
+
+func TestStoredFileBadTrailer(t *testing.T) {
+	data := testContent(storeBlockSize + 1)
+	sp := storeParams{codec: CompressionGzip}
+	path, physical := writeStored(t, sp, data)
+
+	tests := []struct {
+		name   string
+		mutate func(b []byte) []byte
+	}{
+		{"truncated", func(b []byte) []byte { return b[:storeTrailerSize-1] }},
+		{"bad magic", func(b []byte) []byte { b[len(b)-storeTrailerSize] = 'X'; return b }},
+		{"wrong codec", func(b []byte) []byte { b[len(b)-storeTrailerSize+5] = codecID[CompressionZstd]; return b }},
+		{"block count too large", func(b []byte) []byte { b[len(b)-storeTrailerSize+12] = 0xff; return b }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil || int64(len(raw)) != physical {
+				t.Fatal(err)
+			}
+			bad := filepath.Join(t.TempDir(), "bad")
+			if err := os.WriteFile(bad, tt.mutate(raw), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := openStoredPath(bad, sp); err != errBadStoredFile {
+				t.Fatalf("open: err = %v, want errBadStoredFile", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置优先级（后者覆盖前者）：默认值 < 配置文件 < 环境变量 < 命令行参数
+// 配置文件通过 -config 参数或 UPLOAD_CONFIG 环境变量指定，按扩展名识别 YAML/TOML
+
+const redacted = "******" // 敏感配置的脱敏占位
+
+// Duration 支持 "30m"、"1h30m" 形式的时长配置
+type Duration time.Duration
+
+// UnmarshalText 解析时长字符串
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText 输出时长字符串
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Config 服务配置
+type Config struct {
+	Server      ServerConfig      `yaml:"server" toml:"server" json:"server"`                // HTTP服务
+	Log         LogConfig         `yaml:"log" toml:"log" json:"log"`                         // 日志
+	Tracing     TracingConfig     `yaml:"tracing" toml:"tracing" json:"tracing"`             // 链路追踪
+	Database    DatabaseConfig    `yaml:"database" toml:"database" json:"database"`          // 数据库
+	Storage     StorageConfig     `yaml:"storage" toml:"storage" json:"storage"`             // 存储
+	CORS        CORSConfig        `yaml:"cors" toml:"cors" json:"cors"`                      // 跨域
+	Limits      LimitsConfig      `yaml:"limits" toml:"limits" json:"limits"`                // 限制
+	Security    SecurityConfig    `yaml:"security" toml:"security" json:"security"`          // 密钥
+	Processing  ProcessingConfig  `yaml:"processing" toml:"processing" json:"processing"`    // 后处理
+	Health      HealthConfig      `yaml:"health" toml:"health" json:"health"`                // 就绪检查阈值
+	Lock        LockConfig        `yaml:"lock" toml:"lock" json:"lock"`                      // 上传任务锁
+	Cluster     ClusterConfig     `yaml:"cluster" toml:"cluster" json:"cluster"`             // 集群模式
+	Replication ReplicationConfig `yaml:"replication" toml:"replication" json:"replication"` // 副本复制
+	Integrity   IntegrityConfig   `yaml:"integrity" toml:"integrity" json:"integrity"`       // 完整性巡检
+	Lifecycle   LifecycleConfig   `yaml:"lifecycle" toml:"lifecycle" json:"lifecycle"`       // 存储层级与生命周期规则
+}
+
+// ServerConfig HTTP服务配置
+type ServerConfig struct {
+	Addr         string   `yaml:"addr" toml:"addr" json:"addr"`                            // 监听地址
+	ReadTimeout  Duration `yaml:"read_timeout" toml:"read_timeout" json:"read_timeout"`    // 读取超时（大文件上传需较长）
+	WriteTimeout Duration `yaml:"write_timeout" toml:"write_timeout" json:"write_timeout"` // 写入超时（大文件下载需较长）
+	IdleTimeout  Duration `yaml:"idle_timeout" toml:"idle_timeout" json:"idle_timeout"`    // 空闲连接超时，0表示与读取超时相同
+
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" json:"shutdown_timeout"` // 停机时等待进行中上传与合并完成的时间
+}
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level" json:"level"`    // 日志级别：debug/info/warn/error
+	Format string `yaml:"format" toml:"format" json:"format"` // 输出格式：text/json
+}
+
+// TracingConfig OpenTelemetry 链路追踪配置
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" json:"exporter"`             // 导出方式：none/otlp/stdout
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" json:"endpoint"`             // OTLP/HTTP 地址（host:port），为空时使用 OTEL_EXPORTER_OTLP_ENDPOINT 或 localhost:4318
+	Insecure    bool    `yaml:"insecure" toml:"insecure" json:"insecure"`             // OTLP 使用 HTTP 而非 HTTPS
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" json:"sample_ratio"` // 采样比例（0~1），请求已带采样决策时沿用上游
+	ServiceName string  `yaml:"service_name" toml:"service_name" json:"service_name"` // 服务名
+}
+
+// DatabaseConfig 数据库配置
+type DatabaseConfig struct {
+	Driver          string   `yaml:"driver" toml:"driver" json:"driver"`                                  // 驱动：mysql/postgres/sqlite
+	DSN             string   `yaml:"dsn" toml:"dsn" json:"dsn"`                                           // 连接串，为空时使用驱动的默认值（MySQL 需包含 parseTime=true）
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns" json:"max_open_conns"`          // 最大打开连接数
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns" json:"max_idle_conns"`          // 最大空闲连接数
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" json:"conn_max_lifetime"` // 连接最大生命周期
+	AutoMigrate     bool     `yaml:"auto_migrate" toml:"auto_migrate" json:"auto_migrate"`                // 启动时执行未应用的迁移
+}
+
+// StorageConfig 存储配置
+type StorageConfig struct {
+	TmpDir        string `yaml:"tmp_dir" toml:"tmp_dir" json:"tmp_dir"`                      // 临时分片目录
+	FinalDir      string `yaml:"final_dir" toml:"final_dir" json:"final_dir"`                // 文件存储目录
+	QuarantineDir string `yaml:"quarantine_dir" toml:"quarantine_dir" json:"quarantine_dir"` // 感染文件隔离目录
+	Compression   string `yaml:"compression" toml:"compression" json:"compression"`          // 默认压缩算法
+}
+
+// CORSConfig 跨域配置
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" toml:"allowed_origins" json:"allowed_origins"`       // 允许的源，为空时不允许跨域
+	AllowedMethods   []string `yaml:"allowed_methods" toml:"allowed_methods" json:"allowed_methods"`       // 允许的方法
+	AllowedHeaders   []string `yaml:"allowed_headers" toml:"allowed_headers" json:"allowed_headers"`       // 允许的请求头
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials" json:"allow_credentials"` // 是否允许携带凭证（不能与 "*" 同时使用）
+	MaxAge           int      `yaml:"max_age" toml:"max_age" json:"max_age"`                               // 预检请求缓存时间（秒）
+}
+
+// LimitsConfig 大小与数量限制，0表示不限制
+type LimitsConfig struct {
+	MaxFileSize            int64 `yaml:"max_file_size" toml:"max_file_size" json:"max_file_size"`                                     // 单文件最大大小
+	MaxChunkSize           int   `yaml:"max_chunk_size" toml:"max_chunk_size" json:"max_chunk_size"`                                  // 最大分片大小
+	MaxZipFiles            int   `yaml:"max_zip_files" toml:"max_zip_files" json:"max_zip_files"`                                     // 打包下载最大文件数
+	MaxArchiveEntries      int   `yaml:"max_archive_entries" toml:"max_archive_entries" json:"max_archive_entries"`                   // 压缩包最大条目数
+	MaxArchiveExpandedSize int64 `yaml:"max_archive_expanded_size" toml:"max_archive_expanded_size" json:"max_archive_expanded_size"` // 压缩包解压后最大总大小
+	MaxImagePixels         int64 `yaml:"max_image_pixels" toml:"max_image_pixels" json:"max_image_pixels"`                            // 生成缩略图的最大像素数
+}
+
+// SecurityConfig 密钥配置（查看时脱敏）
+type SecurityConfig struct {
+	SigningKey          string `yaml:"signing_key" toml:"signing_key" json:"signing_key"`                               // 分享/预签名链接的HMAC密钥，为空时随机生成
+	MasterKeys          string `yaml:"master_keys" toml:"master_keys" json:"master_keys"`                               // 静态加密主密钥，格式 "id:base64key,..."
+	Keyring             string `yaml:"keyring" toml:"keyring" json:"keyring"`                                           // 本地密钥环文件路径
+	WebhookAllowPrivate bool   `yaml:"webhook_allow_private" toml:"webhook_allow_private" json:"webhook_allow_private"` // 是否允许Webhook投递到回环、内网与链路本地地址
+}
+
+// ProcessingConfig 后处理配置
+type ProcessingConfig struct {
+	Workers        int    `yaml:"workers" toml:"workers" json:"workers"`                         // 处理协程数
+	ClamdAddress   string `yaml:"clamd_address" toml:"clamd_address" json:"clamd_address"`       // clamd地址，为空时不扫描
+	ThumbnailSizes []int  `yaml:"thumbnail_sizes" toml:"thumbnail_sizes" json:"thumbnail_sizes"` // 缩略图尺寸
+	ExifKeepGPS    bool   `yaml:"exif_keep_gps" toml:"exif_keep_gps" json:"exif_keep_gps"`       // 是否保留EXIF中的GPS
+}
+
+// HealthConfig 就绪检查阈值，超出时就绪探针返回503
+type HealthConfig struct {
+	MinFreeSpace int64    `yaml:"min_free_space" toml:"min_free_space" json:"min_free_space"` // 临时目录与存储目录最小剩余空间（字节），0表示不检查
+	MaxDBLatency Duration `yaml:"max_db_latency" toml:"max_db_latency" json:"max_db_latency"` // 数据库 Ping 最大延迟
+	MaxBacklog   int      `yaml:"max_backlog" toml:"max_backlog" json:"max_backlog"`          // 到期未投递的 Webhook 最大积压数，0表示不检查
+}
+
+// LockConfig 上传任务锁配置，多实例部署时需使用 database 或 redis
+type LockConfig struct {
+	Backend       string   `yaml:"backend" toml:"backend" json:"backend"`                      // 锁后端：local/database/redis
+	Timeout       Duration `yaml:"timeout" toml:"timeout" json:"timeout"`                      // 等待锁的最长时间
+	Lease         Duration `yaml:"lease" toml:"lease" json:"lease"`                            // 租约时长，持有期间定期续约，进程退出后到期自动释放
+	RedisAddr     string   `yaml:"redis_addr" toml:"redis_addr" json:"redis_addr"`             // Redis 地址 host:port
+	RedisPassword string   `yaml:"redis_password" toml:"redis_password" json:"redis_password"` // Redis 密码
+	RedisDB       int      `yaml:"redis_db" toml:"redis_db" json:"redis_db"`                   // Redis 数据库编号
+}
+
+// ClusterConfig 集群模式配置：各节点使用本地磁盘，上传任务固定由创建它的节点处理
+type ClusterConfig struct {
+	Enabled           bool     `yaml:"enabled" toml:"enabled" json:"enabled"`                                  // 是否启用集群模式
+	NodeID            string   `yaml:"node_id" toml:"node_id" json:"node_id"`                                  // 节点ID，重启后需保持不变，默认取主机名
+	AdvertiseURL      string   `yaml:"advertise_url" toml:"advertise_url" json:"advertise_url"`                // 其他节点转发请求使用的地址，如 http://10.0.0.1:8080
+	HeartbeatInterval Duration `yaml:"heartbeat_interval" toml:"heartbeat_interval" json:"heartbeat_interval"` // 心跳间隔
+	NodeTimeout       Duration `yaml:"node_timeout" toml:"node_timeout" json:"node_timeout"`                   // 超过该时间未心跳的节点视为下线
+}
+
+// ReplicationConfig 副本复制配置：已完成的文件异步复制到一个或多个副本目录
+type ReplicationConfig struct {
+	Targets        []string `yaml:"targets" toml:"targets" json:"targets"`                         // 副本目录（如挂载的其他磁盘或网络存储），为空时不复制
+	MaxAttempts    int      `yaml:"max_attempts" toml:"max_attempts" json:"max_attempts"`          // 单个副本最大尝试次数，耗尽后标记为 failed 并由修复任务重试
+	RepairInterval Duration `yaml:"repair_interval" toml:"repair_interval" json:"repair_interval"` // 修复任务间隔，0表示只在手动触发时运行
+}
+
+// IntegrityConfig 完整性巡检配置：后台按限速重新计算已完成文件的哈希并与记录比对
+type IntegrityConfig struct {
+	Enabled  bool     `yaml:"enabled" toml:"enabled" json:"enabled"`    // 是否启用后台巡检
+	Rate     int64    `yaml:"rate" toml:"rate" json:"rate"`             // 巡检读取速率上限（字节/秒），0表示不限速
+	Interval Duration `yaml:"interval" toml:"interval" json:"interval"` // 同一文件两次校验的最短间隔
+}
+
+// LifecycleConfig 生命周期配置：按规则在存储层级之间移动文件或到期删除
+type LifecycleConfig struct {
+	Enabled  bool            `yaml:"enabled" toml:"enabled" json:"enabled"`    // 是否按间隔自动执行规则
+	Interval Duration        `yaml:"interval" toml:"interval" json:"interval"` // 执行间隔
+	DryRun   bool            `yaml:"dry_run" toml:"dry_run" json:"dry_run"`    // 只记录将要执行的操作，不移动、删除文件
+	Tiers    []TierConfig    `yaml:"tiers" toml:"tiers" json:"tiers"`          // 存储层级，standard 层级固定为 storage.final_dir
+	Rules    []LifecycleRule `yaml:"rules" toml:"rules" json:"rules"`          // 规则，按顺序匹配
+}
+
+// TierConfig 存储层级
+type TierConfig struct {
+	Name        string `yaml:"name" toml:"name" json:"name"`                      // 层级名称
+	Dir         string `yaml:"dir" toml:"dir" json:"dir"`                         // 存储目录（如归档磁盘或网络存储的挂载目录）
+	Compression string `yaml:"compression" toml:"compression" json:"compression"` // 移入时转换的压缩算法，为空表示保持原格式
+}
+
+// LifecycleRule 生命周期规则：条件均满足时执行动作，未设置的条件不限制
+type LifecycleRule struct {
+	Name          string   `yaml:"name" toml:"name" json:"name"`                                         // 规则名称
+	Action        string   `yaml:"action" toml:"action" json:"action"`                                   // move / delete
+	Tier          string   `yaml:"tier" toml:"tier" json:"tier,omitempty"`                               // move 的目标层级
+	MinAge        Duration `yaml:"min_age" toml:"min_age" json:"min_age,omitempty"`                      // 完成时间早于该时长
+	UnaccessedFor Duration `yaml:"unaccessed_for" toml:"unaccessed_for" json:"unaccessed_for,omitempty"` // 该时长内未被读取（从未读取时按完成时间计）
+	MinSize       int64    `yaml:"min_size" toml:"min_size" json:"min_size,omitempty"`                   // 最小文件大小（字节）
+	MaxSize       int64    `yaml:"max_size" toml:"max_size" json:"max_size,omitempty"`                   // 最大文件大小（字节），0表示不限
+	Users         []string `yaml:"users" toml:"users" json:"users,omitempty"`                            // 所属用户之一
+	Tags          []string `yaml:"tags" toml:"tags" json:"tags,omitempty"`                               // 带有其中任一标签
+	FromTiers     []string `yaml:"from_tiers" toml:"from_tiers" json:"from_tiers,omitempty"`             // 当前所在层级之一
+}
+
+var config *Config // 当前生效的配置
+
+// defaultConfig 默认配置，取各模块变量的初始值
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:         ":8080",
+			ReadTimeout:  Duration(30 * time.Minute),
+			WriteTimeout: Duration(30 * time.Minute),
+
+			ShutdownTimeout: Duration(time.Minute),
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "go-upload",
+		},
+		Database: DatabaseConfig{
+			Driver:          "mysql",
+			MaxOpenConns:    10,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: Duration(3 * time.Minute),
+			AutoMigrate:     true,
+		},
+		Storage: StorageConfig{
+			TmpDir:        tmpDir,
+			FinalDir:      finalDir,
+			QuarantineDir: quarantineDir,
+			Compression:   CompressionNone,
+		},
+		CORS: CORSConfig{
+			// 默认不允许跨域；身份请求头 X-User-ID、X-User-Role 由网关注入，不应由浏览器跨域发送
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Range", "X-Request-ID", "X-Share-Password"},
+			MaxAge:         86400,
+		},
+		Limits: LimitsConfig{
+			MaxZipFiles:            maxZipDownloadFiles,
+			MaxArchiveEntries:      maxArchiveEntries,
+			MaxArchiveExpandedSize: maxArchiveExpandedSize,
+			MaxImagePixels:         maxImagePixels,
+		},
+		Processing: ProcessingConfig{
+			Workers:        processWorkers,
+			ThumbnailSizes: thumbnailSizes,
+		},
+		Health: HealthConfig{
+			MinFreeSpace: healthMinFreeSpace,
+			MaxDBLatency: Duration(healthMaxDBLatency),
+			MaxBacklog:   healthMaxBacklog,
+		},
+		Lock: LockConfig{
+			Backend: "local",
+			Timeout: Duration(lockTimeout),
+			Lease:   Duration(lockLease),
+		},
+		Cluster: ClusterConfig{
+			NodeID:            defaultNodeID(),
+			HeartbeatInterval: Duration(clusterHeartbeatInterval),
+			NodeTimeout:       Duration(clusterNodeTimeout),
+		},
+		Replication: ReplicationConfig{
+			MaxAttempts:    replicaMaxAttempts,
+			RepairInterval: Duration(replicaRepairInterval),
+		},
+		Integrity: IntegrityConfig{
+			Rate:     scrubRate,
+			Interval: Duration(scrubInterval),
+		},
+		Lifecycle: LifecycleConfig{
+			Interval: Duration(lifecycleInterval),
+		},
+	}
+}
+
+// configField 可通过环境变量与命令行设置的配置项
+type configField struct {
+	key   string      // 配置键，同时作为命令行参数名
+	env   string      // 环境变量名
+	ptr   interface{} // 指向配置字段的指针
+	usage string      // 说明
+}
+
+// configFields 列出全部配置项，环境变量沿用已有名称
+func configFields(c *Config) []configField {
+	return []configField{
+		{"server.addr", "UPLOAD_ADDR", &c.Server.Addr, "listen address"},
+		{"server.read_timeout", "UPLOAD_READ_TIMEOUT", &c.Server.ReadTimeout, "HTTP read timeout"},
+		{"server.write_timeout", "UPLOAD_WRITE_TIMEOUT", &c.Server.WriteTimeout, "HTTP write timeout"},
+		{"server.idle_timeout", "UPLOAD_IDLE_TIMEOUT", &c.Server.IdleTimeout, "HTTP idle timeout"},
+		{"server.shutdown_timeout", "UPLOAD_SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout, "time to drain in-flight uploads on shutdown"},
+		{"log.level", "UPLOAD_LOG_LEVEL", &c.Log.Level, "log level (debug/info/warn/error)"},
+		{"log.format", "UPLOAD_LOG_FORMAT", &c.Log.Format, "log format (text/json)"},
+		{"tracing.exporter", "UPLOAD_TRACING_EXPORTER", &c.Tracing.Exporter, "trace exporter (none/otlp/stdout)"},
+		{"tracing.endpoint", "UPLOAD_TRACING_ENDPOINT", &c.Tracing.Endpoint, "OTLP/HTTP endpoint host:port"},
+		{"tracing.insecure", "UPLOAD_TRACING_INSECURE", &c.Tracing.Insecure, "use plain HTTP for OTLP"},
+		{"tracing.sample_ratio", "UPLOAD_TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio, "trace sampling ratio 0..1"},
+		{"tracing.service_name", "UPLOAD_TRACING_SERVICE_NAME", &c.Tracing.ServiceName, "service name reported in traces"},
+		{"database.driver", "UPLOAD_DB_DRIVER", &c.Database.Driver, "database driver (mysql/postgres/sqlite)"},
+		{"database.dsn", "UPLOAD_DB_DSN", &c.Database.DSN, "database DSN, empty for the driver default"},
+		{"database.max_open_conns", "UPLOAD_DB_MAX_OPEN_CONNS", &c.Database.MaxOpenConns, "max open connections"},
+		{"database.max_idle_conns", "UPLOAD_DB_MAX_IDLE_CONNS", &c.Database.MaxIdleConns, "max idle connections"},
+		{"database.conn_max_lifetime", "UPLOAD_DB_CONN_MAX_LIFETIME", &c.Database.ConnMaxLifetime, "connection max lifetime"},
+		{"database.auto_migrate", "UPLOAD_DB_AUTO_MIGRATE", &c.Database.AutoMigrate, "apply pending schema migrations on startup"},
+		{"storage.tmp_dir", "UPLOAD_TMP_DIR", &c.Storage.TmpDir, "chunk directory"},
+		{"storage.final_dir", "UPLOAD_STORE_DIR", &c.Storage.FinalDir, "file store directory"},
+		{"storage.quarantine_dir", "UPLOAD_QUARANTINE_DIR", &c.Storage.QuarantineDir, "quarantine directory"},
+		{"storage.compression", "COMPRESSION_DEFAULT", &c.Storage.Compression, "default compression (none/gzip/zstd)"},
+		{"cors.allowed_origins", "UPLOAD_CORS_ORIGINS", &c.CORS.AllowedOrigins, "comma separated allowed origins"},
+		{"cors.allowed_methods", "UPLOAD_CORS_METHODS", &c.CORS.AllowedMethods, "comma separated allowed methods"},
+		{"cors.allowed_headers", "UPLOAD_CORS_HEADERS", &c.CORS.AllowedHeaders, "comma separated allowed headers"},
+		{"cors.allow_credentials", "UPLOAD_CORS_CREDENTIALS", &c.CORS.AllowCredentials, "allow credentials"},
+		{"cors.max_age", "UPLOAD_CORS_MAX_AGE", &c.CORS.MaxAge, "preflight cache seconds"},
+		{"limits.max_file_size", "UPLOAD_MAX_FILE_SIZE", &c.Limits.MaxFileSize, "max file size in bytes, 0 for unlimited"},
+		{"limits.max_chunk_size", "UPLOAD_MAX_CHUNK_SIZE", &c.Limits.MaxChunkSize, "max chunk size in bytes, 0 for unlimited"},
+		{"limits.max_zip_files", "UPLOAD_MAX_ZIP_FILES", &c.Limits.MaxZipFiles, "max files per zip download"},
+		{"limits.max_archive_entries", "UPLOAD_MAX_ARCHIVE_ENTRIES", &c.Limits.MaxArchiveEntries, "max archive entries"},
+		{"limits.max_archive_expanded_size", "UPLOAD_MAX_ARCHIVE_EXPANDED_SIZE", &c.Limits.MaxArchiveExpandedSize, "max expanded archive size in bytes"},
+		{"limits.max_image_pixels", "UPLOAD_MAX_IMAGE_PIXELS", &c.Limits.MaxImagePixels, "max image pixels for thumbnails"},
+		{"security.signing_key", "UPLOAD_SIGNING_KEY", &c.Security.SigningKey, "HMAC key for share and presigned links"},
+		{"security.master_keys", "ENCRYPTION_MASTER_KEYS", &c.Security.MasterKeys, "encryption master keys id:base64,..."},
+		{"security.keyring", "ENCRYPTION_KEYRING", &c.Security.Keyring, "local keyring file"},
+		{"security.webhook_allow_private", "UPLOAD_WEBHOOK_ALLOW_PRIVATE", &c.Security.WebhookAllowPrivate, "allow webhook delivery to loopback, private and link-local addresses"},
+		{"processing.workers", "UPLOAD_PROCESS_WORKERS", &c.Processing.Workers, "post-processing workers"},
+		{"processing.clamd_address", "CLAMD_ADDRESS", &c.Processing.ClamdAddress, "clamd address"},
+		{"processing.thumbnail_sizes", "THUMBNAIL_SIZES", &c.Processing.ThumbnailSizes, "comma separated thumbnail sizes"},
+		{"processing.exif_keep_gps", "EXIF_KEEP_GPS", &c.Processing.ExifKeepGPS, "keep GPS in recorded EXIF"},
+		{"health.min_free_space", "UPLOAD_HEALTH_MIN_FREE_SPACE", &c.Health.MinFreeSpace, "min free bytes in tmp and store dirs for readiness, 0 to skip"},
+		{"health.max_db_latency", "UPLOAD_HEALTH_MAX_DB_LATENCY", &c.Health.MaxDBLatency, "max database ping latency for readiness"},
+		{"health.max_backlog", "UPLOAD_HEALTH_MAX_BACKLOG", &c.Health.MaxBacklog, "max due webhook deliveries for readiness, 0 to skip"},
+		{"lock.backend", "UPLOAD_LOCK_BACKEND", &c.Lock.Backend, "upload lock backend (local/database/redis)"},
+		{"lock.timeout", "UPLOAD_LOCK_TIMEOUT", &c.Lock.Timeout, "max time to wait for an upload lock"},
+		{"lock.lease", "UPLOAD_LOCK_LEASE", &c.Lock.Lease, "distributed lock lease, renewed while held"},
+		{"lock.redis_addr", "UPLOAD_LOCK_REDIS_ADDR", &c.Lock.RedisAddr, "Redis address host:port"},
+		{"lock.redis_password", "UPLOAD_LOCK_REDIS_PASSWORD", &c.Lock.RedisPassword, "Redis password"},
+		{"lock.redis_db", "UPLOAD_LOCK_REDIS_DB", &c.Lock.RedisDB, "Redis database number"},
+		{"cluster.enabled", "UPLOAD_CLUSTER_ENABLED", &c.Cluster.Enabled, "enable cluster mode with upload affinity"},
+		{"cluster.node_id", "UPLOAD_CLUSTER_NODE_ID", &c.Cluster.NodeID, "stable node ID, defaults to the hostname"},
+		{"cluster.advertise_url", "UPLOAD_CLUSTER_ADVERTISE_URL", &c.Cluster.AdvertiseURL, "base URL other nodes use to reach this node"},
+		{"cluster.heartbeat_interval", "UPLOAD_CLUSTER_HEARTBEAT_INTERVAL", &c.Cluster.HeartbeatInterval, "node heartbeat interval"},
+		{"cluster.node_timeout", "UPLOAD_CLUSTER_NODE_TIMEOUT", &c.Cluster.NodeTimeout, "time without heartbeat before a node is considered down"},
+		{"replication.targets", "UPLOAD_REPLICATION_TARGETS", &c.Replication.Targets, "comma separated replica directories"},
+		{"replication.max_attempts", "UPLOAD_REPLICATION_MAX_ATTEMPTS", &c.Replication.MaxAttempts, "max attempts per replica before it is marked failed"},
+		{"replication.repair_interval", "UPLOAD_REPLICATION_REPAIR_INTERVAL", &c.Replication.RepairInterval, "replica repair interval, 0 for manual only"},
+		{"integrity.enabled", "UPLOAD_INTEGRITY_ENABLED", &c.Integrity.Enabled, "enable background integrity scrubbing"},
+		{"integrity.rate", "UPLOAD_INTEGRITY_RATE", &c.Integrity.Rate, "scrub read rate limit in bytes per second, 0 for unlimited"},
+		{"integrity.interval", "UPLOAD_INTEGRITY_INTERVAL", &c.Integrity.Interval, "minimum interval between verifications of the same file"},
+		{"lifecycle.enabled", "UPLOAD_LIFECYCLE_ENABLED", &c.Lifecycle.Enabled, "run lifecycle rules periodically"},
+		{"lifecycle.interval", "UPLOAD_LIFECYCLE_INTERVAL", &c.Lifecycle.Interval, "lifecycle run interval"},
+		{"lifecycle.dry_run", "UPLOAD_LIFECYCLE_DRY_RUN", &c.Lifecycle.DryRun, "only log what lifecycle rules would do"},
+	}
+}
+
+// setConfigValue 将字符串值写入配置字段
+func setConfigValue(ptr interface{}, value string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *int64:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *Duration:
+		return p.UnmarshalText([]byte(value))
+	case *[]string:
+		*p = splitList(value)
+	case *[]int:
+		var list []int
+		for _, s := range splitList(value) {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				return err
+			}
+			list = append(list, v)
+		}
+		*p = list
+	default:
+		return fmt.Errorf("unsupported config type %T", ptr)
+	}
+	return nil
+}
+
+// splitList 拆分逗号分隔的列表
+func splitList(s string) []string {
+	var list []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
+
+// loadConfig 按优先级加载配置并校验
+func loadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+	fields := configFields(cfg)
+
+	// 命令行参数最后生效，先记录下来
+	type flagValue struct {
+		field configField
+		value string
+	}
+	var flagValues []flagValue
+	fs := flag.NewFlagSet("go-upload", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("UPLOAD_CONFIG"), "config file (.yaml, .yml or .toml)")
+	for _, f := range fields {
+		f := f
+		fs.Func(f.key, f.usage+" (env "+f.env+")", func(v string) error {
+			flagValues = append(flagValues, flagValue{f, v})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		if err := loadConfigFile(cfg, *configPath); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range fields {
+		if v := os.Getenv(f.env); v != "" {
+			if err := setConfigValue(f.ptr, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", f.env, err)
+			}
+		}
+	}
+	for _, fv := range flagValues {
+		if err := setConfigValue(fv.field.ptr, fv.value); err != nil {
+			return nil, fmt.Errorf("invalid -%s: %v", fv.field.key, err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadConfigFile 读取配置文件，存在未知配置项时报错
+func loadConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parse %s: %v", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("parse %s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parse %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("unsupported config file format: %s", path)
+	}
+	return nil
+}
+
+// validate 校验配置，并规范化部分字段
+func (c *Config) validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ReadTimeout >= 0 && c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0 && c.Server.ShutdownTimeout >= 0, "server timeouts must not be negative")
+
+	check(validLogLevel(c.Log.Level), "log.level must be one of debug, info, warn, error")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json")
+
+	check(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "otlp" || c.Tracing.Exporter == "stdout", "tracing.exporter must be one of none, otlp, stdout")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+
+	if drv, ok := storeDrivers[c.Database.Driver]; ok {
+		if c.Database.DSN == "" {
+			c.Database.DSN = drv.defaultDSN
+		}
+		_, err := drv.connector(c.Database.DSN)
+		check(err == nil, "database.dsn is invalid: %v", err)
+	} else {
+		check(false, "database.driver must be one of mysql, postgres, sqlite")
+	}
+	check(c.Database.MaxOpenConns > 0, "database.max_open_conns must be positive")
+	check(c.Database.MaxIdleConns >= 0 && c.Database.MaxIdleConns <= c.Database.MaxOpenConns, "database.max_idle_conns must be between 0 and max_open_conns")
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime must not be negative")
+
+	check(c.Storage.TmpDir != "" && c.Storage.FinalDir != "" && c.Storage.QuarantineDir != "", "storage directories are required")
+	check(validCompression(c.Storage.Compression), "storage.compression must be one of none, gzip, zstd")
+
+	for _, o := range c.CORS.AllowedOrigins {
+		check(!(c.CORS.AllowCredentials && o == "*"), "cors.allow_credentials cannot be used with allowed origin \"*\"")
+		check(!(o == "*" && corsAllowsIdentityHeaders(c.CORS.AllowedHeaders)),
+			"cors.allowed_headers must not allow X-User-ID or X-User-Role when allowed origin is \"*\"")
+	}
+	check(c.CORS.MaxAge >= 0, "cors.max_age must not be negative")
+
+	check(c.Limits.MaxFileSize >= 0 && c.Limits.MaxChunkSize >= 0, "limits.max_file_size and max_chunk_size must not be negative")
+	check(c.Limits.MaxZipFiles > 0, "limits.max_zip_files must be positive")
+	check(c.Limits.MaxArchiveEntries > 0, "limits.max_archive_entries must be positive")
+	check(c.Limits.MaxArchiveExpandedSize > 0, "limits.max_archive_expanded_size must be positive")
+	check(c.Limits.MaxImagePixels > 0, "limits.max_image_pixels must be positive")
+
+	check(c.Security.MasterKeys == "" || c.Security.Keyring == "", "security.master_keys and security.keyring are mutually exclusive")
+
+	check(c.Processing.Workers > 0, "processing.workers must be positive")
+	check(len(c.Processing.ThumbnailSizes) > 0, "processing.thumbnail_sizes is required")
+	for _, s := range c.Processing.ThumbnailSizes {
+		check(s >= 16 && s <= 4096, "invalid thumbnail size: %d", s)
+	}
+	sort.Ints(c.Processing.ThumbnailSizes)
+
+	check(c.Health.MinFreeSpace >= 0 && c.Health.MaxBacklog >= 0, "health.min_free_space and max_backlog must not be negative")
+	check(c.Health.MaxDBLatency > 0, "health.max_db_latency must be positive")
+
+	switch c.Lock.Backend {
+	case "local":
+	case "database":
+		check(c.Database.Driver != "sqlite", "lock.backend database requires mysql or postgres")
+	case "redis":
+		check(c.Lock.RedisAddr != "", "lock.redis_addr is required for lock.backend redis")
+	default:
+		check(false, "lock.backend must be one of local, database, redis")
+	}
+	check(c.Lock.Timeout > 0, "lock.timeout must be positive")
+	check(c.Lock.Lease >= Duration(time.Second), "lock.lease must be at least 1s")
+	check(c.Lock.RedisDB >= 0, "lock.redis_db must not be negative")
+
+	if c.Cluster.Enabled {
+		check(c.Database.Driver != "sqlite", "cluster.enabled requires mysql or postgres")
+		check(c.Lock.Backend != "local", "cluster.enabled requires lock.backend database or redis")
+		check(validNodeID(c.Cluster.NodeID), "cluster.node_id must be 1-64 letters, digits or -_.")
+		u, err := url.Parse(c.Cluster.AdvertiseURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "cluster.advertise_url must be an http(s) URL")
+		check(c.Cluster.HeartbeatInterval >= Duration(time.Second), "cluster.heartbeat_interval must be at least 1s")
+		check(c.Cluster.NodeTimeout >= 2*c.Cluster.HeartbeatInterval, "cluster.node_timeout must be at least twice heartbeat_interval")
+	}
+
+	seen := map[string]bool{}
+	for i, t := range c.Replication.Targets {
+		t = filepath.Clean(t)
+		c.Replication.Targets[i] = t
+		check(t != "." && len(t) <= 255, "invalid replication target: %q", t)
+		check(!seen[t], "duplicate replication target: %s", t)
+		check(t != filepath.Clean(c.Storage.FinalDir) && t != filepath.Clean(c.Storage.TmpDir) && t != filepath.Clean(c.Storage.QuarantineDir),
+			"replication target %s must differ from the storage directories", t)
+		seen[t] = true
+	}
+	check(c.Replication.MaxAttempts > 0, "replication.max_attempts must be positive")
+	check(c.Replication.RepairInterval >= 0, "replication.repair_interval must not be negative")
+	check(c.Integrity.Rate >= 0, "integrity.rate must not be negative")
+	check(c.Integrity.Interval >= Duration(time.Minute), "integrity.interval must be at least 1m")
+
+	check(c.Lifecycle.Interval >= Duration(time.Minute), "lifecycle.interval must be at least 1m")
+	tiers := map[string]bool{TierStandard: true}
+	dirs := map[string]bool{filepath.Clean(c.Storage.FinalDir): true, filepath.Clean(c.Storage.TmpDir): true, filepath.Clean(c.Storage.QuarantineDir): true}
+	for _, t := range c.Replication.Targets {
+		dirs[t] = true
+	}
+	for i := range c.Lifecycle.Tiers {
+		t := &c.Lifecycle.Tiers[i]
+		check(validTierName(t.Name), "lifecycle tier name must be 1-32 letters, digits or -_: %q", t.Name)
+		check(!tiers[t.Name], "duplicate lifecycle tier: %s", t.Name)
+		tiers[t.Name] = true
+		t.Dir = filepath.Clean(t.Dir)
+		check(t.Dir != "." && !dirs[t.Dir], "lifecycle tier %s must use its own directory", t.Name)
+		dirs[t.Dir] = true
+		check(validCompression(t.Compression), "lifecycle tier %s: compression must be none, gzip or zstd", t.Name)
+	}
+	rules := map[string]bool{}
+	for i := range c.Lifecycle.Rules {
+		r := &c.Lifecycle.Rules[i]
+		check(r.Name != "" && !rules[r.Name], "lifecycle rule names must be unique and non-empty: %q", r.Name)
+		rules[r.Name] = true
+		switch r.Action {
+		case LifecycleMove:
+			check(tiers[r.Tier], "lifecycle rule %s: unknown tier %q", r.Name, r.Tier)
+		case LifecycleDelete:
+			check(r.MinAge > 0 || r.UnaccessedFor > 0, "lifecycle rule %s: delete requires min_age or unaccessed_for", r.Name)
+		default:
+			check(false, "lifecycle rule %s: action must be move or delete", r.Name)
+		}
+		check(r.MinAge >= 0 && r.UnaccessedFor >= 0, "lifecycle rule %s: durations must not be negative", r.Name)
+		check(r.MinSize >= 0 && r.MaxSize >= 0 && (r.MaxSize == 0 || r.MaxSize >= r.MinSize), "lifecycle rule %s: invalid size range", r.Name)
+		for _, t := range r.FromTiers {
+			check(tiers[t], "lifecycle rule %s: unknown tier %q", r.Name, t)
+		}
+		var err error
+		r.Tags, err = normalizeTags(r.Tags)
+		check(err == nil, "lifecycle rule %s: %v", r.Name, err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// corsAllowsIdentityHeaders 判断跨域请求头是否包含身份请求头（或 "*"）
+func corsAllowsIdentityHeaders(headers []string) bool {
+	for _, h := range headers {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "*", "x-user-id", "x-user-role":
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted 返回隐藏敏感信息后的配置副本
+func (c Config) Redacted() Config {
+	if drv, ok := storeDrivers[c.Database.Driver]; ok {
+		c.Database.DSN = drv.redactDSN(c.Database.DSN)
+	} else {
+		c.Database.DSN = redacted
+	}
+	if c.Security.SigningKey != "" {
+		c.Security.SigningKey = redacted
+	}
+	if c.Security.MasterKeys != "" {
+		c.Security.MasterKeys = redacted
+	}
+	if c.Lock.RedisPassword != "" {
+		c.Lock.RedisPassword = redacted
+	}
+	return c
+}
+
+// applyConfig 将配置写入各模块的全局变量
+func applyConfig(c *Config) {
+	config = c
+	tmpDir = c.Storage.TmpDir
+	finalDir = c.Storage.FinalDir
+	quarantineDir = c.Storage.QuarantineDir
+	compressionDefault = c.Storage.Compression
+	maxZipDownloadFiles = c.Limits.MaxZipFiles
+	maxArchiveEntries = c.Limits.MaxArchiveEntries
+	maxArchiveExpandedSize = c.Limits.MaxArchiveExpandedSize
+	maxImagePixels = c.Limits.MaxImagePixels
+	processWorkers = c.Processing.Workers
+	clamdAddress = c.Processing.ClamdAddress
+	thumbnailSizes = c.Processing.ThumbnailSizes
+	keepExifGPS = c.Processing.ExifKeepGPS
+	webhookAllowPrivate = c.Security.WebhookAllowPrivate
+	healthMinFreeSpace = c.Health.MinFreeSpace
+	healthMaxDBLatency = time.Duration(c.Health.MaxDBLatency)
+	healthMaxBacklog = c.Health.MaxBacklog
+	lockTimeout = time.Duration(c.Lock.Timeout)
+	lockLease = time.Duration(c.Lock.Lease)
+	if c.Cluster.Enabled {
+		clusterNodeID = c.Cluster.NodeID
+		clusterAdvertiseURL = strings.TrimSuffix(c.Cluster.AdvertiseURL, "/")
+	}
+	clusterHeartbeatInterval = time.Duration(c.Cluster.HeartbeatInterval)
+	clusterNodeTimeout = time.Duration(c.Cluster.NodeTimeout)
+	replicaTargets = c.Replication.Targets
+	replicaMaxAttempts = c.Replication.MaxAttempts
+	replicaRepairInterval = time.Duration(c.Replication.RepairInterval)
+	scrubEnabled = c.Integrity.Enabled
+	scrubRate = c.Integrity.Rate
+	scrubInterval = time.Duration(c.Integrity.Interval)
+	lifecycleEnabled = c.Lifecycle.Enabled
+	lifecycleInterval = time.Duration(c.Lifecycle.Interval)
+	lifecycleDryRun = c.Lifecycle.DryRun
+	lifecycleRules = c.Lifecycle.Rules
+	storageTiers = map[string]TierConfig{}
+	for _, t := range c.Lifecycle.Tiers {
+		storageTiers[t.Name] = t
+	}
+}
+
+// GetConfig 查看当前生效的配置（敏感信息已脱敏）
+// GET /api/v1/admin/config
+func GetConfig(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": config.Redacted(),
+	})
+}
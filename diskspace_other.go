@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// diskFree 当前平台不支持统计剩余空间，就绪检查跳过该项
+func diskFree(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
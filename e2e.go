@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// 客户端加密（端到端加密）模式：文件在客户端加密后上传，服务端只保存密文与客户端提供的
+// 包装密钥、加密参数，不解析也无法解密。服务端仍按密文校验分片大小、总大小与MD5，
+// 但跳过依赖明文的处理（病毒扫描、缩略图、MIME嗅探、压缩包索引等）。
+// 下载时加密信息整体编码进一个响应头，各字段上限使其不超过 maxEncryptionHeader，
+// 避免超出反向代理常见的响应头缓冲（4~8KB）导致下载失败。
+const (
+	maxKeyBlobSize      = 1 << 10               // 包装密钥最大长度（解码后）
+	maxEncryptionParams = 1 << 10               // 加密参数最大长度
+	maxAlgorithmLength  = 64                    // 算法名称最大长度
+	maxEncryptionHeader = 4 << 10               // 加密信息响应头最大长度
+	clientEncryptionHdr = "X-Client-Encryption" // 下载时返回客户端加密信息的响应头
+)
+
+// ClientEncryption 客户端加密信息，由客户端提供，服务端原样保存并随下载返回
+type ClientEncryption struct {
+	Algorithm     string          `json:"algorithm"`                // 加密算法（如 AES-256-GCM）
+	KeyBlob       string          `json:"key_blob"`                 // 客户端包装后的文件密钥（base64）
+	Params        json.RawMessage `json:"params,omitempty"`         // 其他加密参数（分段大小、nonce前缀等）
+	CiphertextMD5 string          `json:"ciphertext_md5,omitempty"` // 密文MD5，完成上传时校验
+}
+
+// ciphertextProcessor 可处理密文的处理器（如元数据），客户端加密文件仅执行此类处理器
+type ciphertextProcessor interface {
+	AcceptsCiphertext() bool
+}
+
+// acceptsCiphertext 判断处理器能否处理客户端加密文件
+func acceptsCiphertext(p Processor) bool {
+	cp, ok := p.(ciphertextProcessor)
+	return ok && cp.AcceptsCiphertext()
+}
+
+// validateClientEncryption 校验客户端加密上传请求
+// 密文MD5取自请求的 md5 字段（或 client_encryption.ciphertext_md5），客户端加密模式下必填
+func validateClientEncryption(req *UploadRequest) error {
+	ce := req.ClientEncryption
+	if ce.Algorithm == "" || len(ce.Algorithm) > maxAlgorithmLength {
+		return fmt.Errorf("client_encryption.algorithm is required")
+	}
+	blob, err := base64.StdEncoding.DecodeString(ce.KeyBlob)
+	if err != nil || len(blob) == 0 || len(blob) > maxKeyBlobSize {
+		return fmt.Errorf("client_encryption.key_blob must be non-empty base64, at most %d bytes", maxKeyBlobSize)
+	}
+	if len(ce.Params) > 0 {
+		var obj map[string]interface{}
+		if len(ce.Params) > maxEncryptionParams || json.Unmarshal(ce.Params, &obj) != nil {
+			return fmt.Errorf("client_encryption.params must be a JSON object, at most %d bytes", maxEncryptionParams)
+		}
+	}
+
+	md5 := strings.ToLower(req.MD5)
+	if md5 == "" {
+		md5 = strings.ToLower(ce.CiphertextMD5)
+	}
+	if b, err := hex.DecodeString(md5); err != nil || len(b) != 16 {
+		return fmt.Errorf("md5 of the ciphertext is required for client-encrypted uploads")
+	}
+	ce.CiphertextMD5 = md5
+
+	// 字段上限之内，转义仍可能使编码后的响应头超长
+	if data, err := json.Marshal(ce); err != nil || base64.StdEncoding.EncodedLen(len(data)) > maxEncryptionHeader {
+		return fmt.Errorf("client_encryption is too large, encoded size must be at most %d bytes", maxEncryptionHeader)
+	}
+
+	// 密文不可压缩
+	if req.Compression == CompressionGzip || req.Compression == CompressionZstd {
+		return fmt.Errorf("compression is not supported for client-encrypted uploads")
+	}
+	req.Compression = CompressionNone
+	return nil
+}
+
+// parseClientEncryption 解析数据库中保存的客户端加密信息
+func parseClientEncryption(file *FileRecord, raw []byte) error {
+	if raw == nil {
+		return nil
+	}
+	file.ClientEncryption = &ClientEncryption{}
+	return json.Unmarshal(raw, file.ClientEncryption)
+}
+
+// setClientEncryptionHeader 下载客户端加密文件时通过响应头返回包装密钥与参数；
+// 超过 maxEncryptionHeader 时（早于当前上限创建的记录）不设置，客户端改从文件详情获取
+func setClientEncryptionHeader(w http.ResponseWriter, file *FileRecord) {
+	if file.ClientEncryption == nil {
+		return
+	}
+	data, err := json.Marshal(file.ClientEncryption)
+	if err != nil {
+		return
+	}
+	value := base64.StdEncoding.EncodeToString(data)
+	if len(value) > maxEncryptionHeader {
+		slog.Warn("Client encryption header too large, omitted", "upload_id", file.UploadID, "size", len(value))
+		return
+	}
+	w.Header().Set(clientEncryptionHdr, value)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateClientEncryption(t *testing.T) {
+	const md5 = "0123456789abcdef0123456789abcdef"
+	blob := func(n int) string { return base64.StdEncoding.EncodeToString(make([]byte, n)) }
+	params := func(n int) json.RawMessage { // 长度恰为 n 的 JSON 对象
+		return json.RawMessage(`{"p":"` + strings.Repeat("a", n-8) + `"}`)
+	}
+
+	tests := []struct {
+		name string
+		ce   ClientEncryption
+		ok   bool
+	}{
+		{"minimal", ClientEncryption{Algorithm: "AES-256-GCM", KeyBlob: blob(32)}, true},
+		{"limits", ClientEncryption{Algorithm: strings.Repeat("A", maxAlgorithmLength), KeyBlob: blob(maxKeyBlobSize), Params: params(maxEncryptionParams)}, true},
+		{"no algorithm", ClientEncryption{KeyBlob: blob(32)}, false},
+		{"long algorithm", ClientEncryption{Algorithm: strings.Repeat("A", maxAlgorithmLength+1), KeyBlob: blob(32)}, false},
+		{"bad key blob", ClientEncryption{Algorithm: "AES-256-GCM", KeyBlob: "not base64!"}, false},
+		{"large key blob", ClientEncryption{Algorithm: "AES-256-GCM", KeyBlob: blob(maxKeyBlobSize + 1)}, false},
+		{"large params", ClientEncryption{Algorithm: "AES-256-GCM", KeyBlob: blob(32), Params: params(maxEncryptionParams + 1)}, false},
+		{"params not object", ClientEncryption{Algorithm: "AES-256-GCM", KeyBlob: blob(32), Params: json.RawMessage(`[1]`)}, false},
+		// 字段长度在上限内，但 HTML 转义使编码后的响应头超长
+		{"escaped params", ClientEncryption{Algorithm: "AES-256-GCM", KeyBlob: blob(maxKeyBlobSize),
+			Params: json.RawMessage(`{"p":"` + strings.Repeat("<", maxEncryptionParams-8) + `"}`)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce := tt.ce
+			req := &UploadRequest{MD5: md5, ClientEncryption: &ce}
+			err := validateClientEncryption(req)
+			if (err == nil) != tt.ok {
+				t.Fatalf("validateClientEncryption() error = %v, want ok=%v", err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+
+			// 通过校验的加密信息作为响应头不超过上限
+			w := httptest.NewRecorder()
+			setClientEncryptionHeader(w, &FileRecord{UploadID: "u1", ClientEncryption: req.ClientEncryption})
+			hdr := w.Header().Get(clientEncryptionHdr)
+			if hdr == "" || len(hdr) > maxEncryptionHeader {
+				t.Fatalf("header length = %d, want 1..%d", len(hdr), maxEncryptionHeader)
+			}
+			raw, _ := base64.StdEncoding.DecodeString(hdr)
+			var got ClientEncryption
+			if err := json.Unmarshal(raw, &got); err != nil || got.KeyBlob != ce.KeyBlob || got.CiphertextMD5 != md5 {
+				t.Fatalf("header does not round-trip: %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestClientEncryptionHeaderOmittedWhenTooLarge(t *testing.T) {
+	w := httptest.NewRecorder()
+	setClientEncryptionHeader(w, &FileRecord{UploadID: "u1", ClientEncryption: &ClientEncryption{
+		Algorithm: "AES-256-GCM",
+		KeyBlob:   base64.StdEncoding.EncodeToString(make([]byte, 8<<10)), // 早于当前上限创建的记录
+	}})
+	if hdr := w.Header().Get(clientEncryptionHdr); hdr != "" {
+		t.Fatalf("oversized header set (%d bytes)", len(hdr))
+	}
+}
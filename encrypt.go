@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 静态加密：每个文件生成独立的数据密钥（DEK）加密分片与最终文件，
+// DEK 由主密钥包装后存放在 uploads.wrapped_key 中。轮换主密钥时只需重新包装 DEK，
+// 无需重新加密文件数据。
+const (
+	dataKeySize    = 32  // 数据密钥长度（AES-256）
+	rewrapBatch    = 100 // 轮换时每批重新包装的密钥数
+	keyringVersion = 1   // 本地密钥环文件版本
+)
+
+var errUnknownMasterKey = errors.New("unknown master key")
+
+// MasterKey 主密钥
+type MasterKey struct {
+	ID        string    `json:"id"`         // 密钥ID
+	Key       []byte    `json:"key"`        // 密钥（base64）
+	CreatedAt time.Time `json:"created_at"` // 创建时间
+}
+
+// keyringFile 本地密钥环文件（本地KMS替代）
+type keyringFile struct {
+	Version int          `json:"version"` // 文件版本
+	Active  string       `json:"active"`  // 当前使用的主密钥ID
+	Keys    []*MasterKey `json:"keys"`    // 全部主密钥（旧密钥保留用于解包）
+}
+
+// masterKeyring 主密钥集合，active 用于包装新密钥，其余仅用于解包
+type masterKeyring struct {
+	mu     sync.RWMutex
+	keys   map[string]cipher.AEAD
+	active string
+	path   string // 本地密钥环文件路径，为空时密钥来自配置，不支持自动轮换
+}
+
+var keyring *masterKeyring // 为nil时不加密
+
+// initKeyring 初始化主密钥
+// masterKeys 格式为 "id:base64key,id2:base64key"，第一个为当前密钥；
+// 否则使用 keyringPath 指定的本地密钥环文件，不存在时自动生成
+func initKeyring(masterKeys, keyringPath string) error {
+	if masterKeys != "" {
+		kr := &masterKeyring{keys: map[string]cipher.AEAD{}}
+		for i, part := range strings.Split(masterKeys, ",") {
+			id, encoded, ok := strings.Cut(strings.TrimSpace(part), ":")
+			if !ok || id == "" {
+				return fmt.Errorf("invalid master keys entry %d", i)
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return fmt.Errorf("invalid master key %s: %v", id, err)
+			}
+			if err := kr.add(id, key); err != nil {
+				return err
+			}
+			if i == 0 {
+				kr.active = id
+			}
+		}
+		keyring = kr
+		slog.Info("Encryption at rest enabled", "active_key", kr.active)
+		return nil
+	}
+
+	if keyringPath != "" {
+		kr, err := loadKeyringFile(keyringPath)
+		if err != nil {
+			return err
+		}
+		keyring = kr
+		slog.Info("Encryption at rest enabled", "active_key", kr.active, "keyring", keyringPath)
+	}
+	return nil
+}
+
+// loadKeyringFile 读取本地密钥环，不存在时生成包含一个主密钥的新密钥环
+func loadKeyringFile(path string) (*masterKeyring, error) {
+	kr := &masterKeyring{keys: map[string]cipher.AEAD{}, path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if _, err := kr.rotate(); err != nil {
+			return nil, err
+		}
+		return kr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var kf keyringFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("parse keyring %s: %v", path, err)
+	}
+	for _, k := range kf.Keys {
+		if err := kr.add(k.ID, k.Key); err != nil {
+			return nil, err
+		}
+	}
+	if kr.keys[kf.Active] == nil {
+		return nil, fmt.Errorf("keyring %s: active key %q not found", path, kf.Active)
+	}
+	kr.active = kf.Active
+	return kr, nil
+}
+
+// reload 重新读取本地密钥环文件，加入其他实例轮换后新增的主密钥并切换当前密钥
+// 多实例共享密钥环文件时，由某个实例轮换后其他实例在遇到未知主密钥时调用
+func (kr *masterKeyring) reload() error {
+	if kr.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(kr.path)
+	if err != nil {
+		return err
+	}
+	var kf keyringFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return fmt.Errorf("parse keyring %s: %v", kr.path, err)
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	var added []string
+	for _, k := range kf.Keys {
+		if kr.keys[k.ID] != nil {
+			continue
+		}
+		if err := kr.add(k.ID, k.Key); err != nil {
+			return err
+		}
+		added = append(added, k.ID)
+	}
+	if kr.keys[kf.Active] != nil && kr.active != kf.Active {
+		kr.active = kf.Active
+	}
+	if len(added) > 0 {
+		slog.Info("Keyring reloaded", "keyring", kr.path, "added", added, "active_key", kr.active)
+	}
+	return nil
+}
+
+// add 添加主密钥
+func (kr *masterKeyring) add(id string, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("master key %s must be 32 bytes", id)
+	}
+	if kr.keys[id] != nil {
+		return fmt.Errorf("duplicate master key %s", id)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	kr.keys[id] = aead
+	return nil
+}
+
+// rotate 在本地密钥环中生成新的主密钥并设为当前密钥
+func (kr *masterKeyring) rotate() (string, error) {
+	if kr.path == "" {
+		return "", fmt.Errorf("master keys come from configuration, add a new key there and restart")
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	var kf keyringFile
+	if data, err := os.ReadFile(kr.path); err == nil {
+		if err := json.Unmarshal(data, &kf); err != nil {
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	// 文件中可能有其他实例轮换生成、本实例尚未加载的主密钥
+	for _, k := range kf.Keys {
+		if kr.keys[k.ID] == nil {
+			if err := kr.add(k.ID, k.Key); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(key)
+	id := time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(sum[:4])
+	if err := kr.add(id, key); err != nil {
+		return "", err
+	}
+
+	kf.Version = keyringVersion
+	kf.Active = id
+	kf.Keys = append(kf.Keys, &MasterKey{ID: id, Key: key, CreatedAt: time.Now().UTC()})
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	_ = os.MkdirAll(filepath.Dir(kr.path), 0700)
+	tmp := kr.path + ".part"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, kr.path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	kr.active = id
+	return id, nil
+}
+
+// wrap 使用当前主密钥包装数据密钥，主密钥ID作为附加数据
+func (kr *masterKeyring) wrap(dek []byte) (string, []byte, error) {
+	kr.mu.RLock()
+	id, aead := kr.active, kr.keys[kr.active]
+	kr.mu.RUnlock()
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dek)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return id, aead.Seal(nonce, nonce, dek, []byte(id)), nil
+}
+
+// unwrap 解包数据密钥，主密钥未知时重新读取本地密钥环（可能已由其他实例轮换）
+func (kr *masterKeyring) unwrap(id string, wrapped []byte) ([]byte, error) {
+	aead := kr.lookup(id)
+	if aead == nil {
+		if err := kr.reload(); err != nil {
+			slog.Warn("Reload keyring error", "keyring", kr.path, "err", err)
+		}
+		aead = kr.lookup(id)
+	}
+	if aead == nil {
+		return nil, fmt.Errorf("%w: %s", errUnknownMasterKey, id)
+	}
+	n := aead.NonceSize()
+	if len(wrapped) < n+aead.Overhead() {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	return aead.Open(nil, wrapped[:n], wrapped[n:], []byte(id))
+}
+
+// lookup 按ID查找主密钥
+func (kr *masterKeyring) lookup(id string) cipher.AEAD {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[id]
+}
+
+// activeID 当前主密钥ID
+func (kr *masterKeyring) activeID() string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.active
+}
+
+// newAEAD 创建 AES-GCM 实例
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newDataKey 生成并包装新的数据密钥，未启用加密时返回空值
+func newDataKey() (string, []byte, error) {
+	if keyring == nil {
+		return "", nil, nil
+	}
+	dek := make([]byte, dataKeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", nil, err
+	}
+	return keyring.wrap(dek)
+}
+
+// newStoreParams 根据压缩算法与包装后的数据密钥构建存储参数
+func newStoreParams(compression, keyID string, wrappedKey []byte) (storeParams, error) {
+	sp := storeParams{codec: compression}
+	if keyID == "" {
+		return sp, nil
+	}
+	if keyring == nil {
+		return sp, fmt.Errorf("file is encrypted but no master key is configured")
+	}
+	dek, err := keyring.unwrap(keyID, wrappedKey)
+	if err != nil {
+		return sp, err
+	}
+	if sp.aead, err = newAEAD(dek); err != nil {
+		return sp, err
+	}
+	return sp, nil
+}
+
+// fileStoreParams 文件记录对应的存储参数，加密块绑定上传ID
+func fileStoreParams(file *FileRecord) (storeParams, error) {
+	sp, err := newStoreParams(file.Compression, file.KeyID, file.WrappedKey)
+	sp.aad = file.UploadID
+	return sp, err
+}
+
+// forChunk 由文件的存储参数得到第 index 个分片的存储参数：加密块另外绑定分片序号
+func (sp storeParams) forChunk(index int) storeParams {
+	sp.aad = fmt.Sprintf("%s/chunk/%d", sp.aad, index)
+	return sp
+}
+
+// RotateMasterKey 轮换主密钥并用新主密钥重新包装所有数据密钥（文件数据不重新加密）
+// 使用 security.master_keys 配置主密钥时不生成新密钥，仅将数据密钥迁移到当前主密钥
+// POST /api/v1/admin/keys/rotate
+func RotateMasterKey(w http.ResponseWriter, r *http.Request) {
+	logger := reqLogger(r)
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+	if keyring == nil {
+		writeError(w, http.StatusConflict, "Encryption at rest is not enabled")
+		return
+	}
+
+	if keyring.path != "" {
+		id, err := keyring.rotate()
+		if err != nil {
+			logger.Error("Rotate master key error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to rotate master key")
+			return
+		}
+		logger.Info("Master key rotated", "active_key", id)
+	}
+
+	rewrapped, err := rewrapDataKeys(dbCtx(r))
+	if err != nil {
+		logger.Error("Rewrap data keys error", "err", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Rewrapped %d keys before failing", rewrapped))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"active_key_id": keyring.activeID(),
+			"rewrapped":     rewrapped,
+		},
+	})
+}
+
+// rewrapDataKeys 将仍由旧主密钥包装的数据密钥迁移到当前主密钥
+// 按 upload_id 分页只遍历一次：其他实例在迁移过程中再次轮换时，已迁移的行不会被重复选中，
+// 由那次轮换自己的迁移处理
+func rewrapDataKeys(ctx context.Context) (int, error) {
+	total := 0
+	last := ""
+	for {
+		rows, err := db.QueryContext(ctx,
+			"SELECT upload_id, key_id, wrapped_key FROM uploads WHERE key_id IS NOT NULL AND key_id <> ? AND upload_id > ? ORDER BY upload_id LIMIT ?",
+			keyring.activeID(), last, rewrapBatch,
+		)
+		if err != nil {
+			return total, err
+		}
+		type pending struct {
+			uploadID, keyID string
+			wrapped         []byte
+		}
+		var batch []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.uploadID, &p.keyID, &p.wrapped); err != nil {
+				rows.Close()
+				return total, err
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+		last = batch[len(batch)-1].uploadID
+
+		for _, p := range batch {
+			dek, err := keyring.unwrap(p.keyID, p.wrapped)
+			if err != nil {
+				return total, fmt.Errorf("unwrap key of %s: %v", p.uploadID, err)
+			}
+			newID, wrapped, err := keyring.wrap(dek)
+			if err != nil {
+				return total, err
+			}
+			if newID == p.keyID {
+				continue
+			}
+			// 以旧密钥ID为条件，避免覆盖并发轮换的结果
+			_, err = db.ExecContext(ctx,
+				"UPDATE uploads SET key_id = ?, wrapped_key = ?, updated_at = updated_at WHERE upload_id = ? AND key_id = ?",
+				newID, wrapped, p.uploadID, p.keyID,
+			)
+			if err != nil {
+				return total, err
+			}
+			total++
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestKeyring 在临时目录中创建本地密钥环并启用静态加密，测试结束后恢复
+func useTestKeyring(t *testing.T) *masterKeyring {
+	t.Helper()
+	kr, err := loadKeyringFile(filepath.Join(t.TempDir(), "keyring.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := keyring
+	keyring = kr
+	t.Cleanup(func() { keyring = old })
+	return kr
+}
+
+// insertWrappedUpload 写入一条数据密钥由 kr 当前主密钥包装的上传记录
+func insertWrappedUpload(t *testing.T, kr *masterKeyring, uploadID string) {
+	t.Helper()
+	keyID, wrapped, err := kr.wrap(make([]byte, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newTestUpload(uploadID, "alice", "", uploadID+".bin", 10)
+	f.KeyID, f.WrappedKey = keyID, wrapped
+	if err := uploadStore.CreateUpload(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// keyIDCounts 统计各主密钥包装的数据密钥数
+func keyIDCounts(t *testing.T) map[string]int {
+	t.Helper()
+	rows, err := db.Query("SELECT key_id, COUNT(*) FROM uploads WHERE key_id IS NOT NULL GROUP BY key_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var id string
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			t.Fatal(err)
+		}
+		counts[id] = n
+	}
+	return counts
+}
+
+func TestRewrapDataKeys(t *testing.T) {
+	setupTestDB(t)
+	kr := useTestKeyring(t)
+	oldKey := kr.activeID()
+	for i := 0; i < 2*rewrapBatch+10; i++ {
+		insertWrappedUpload(t, kr, fmt.Sprintf("u%04d", i))
+	}
+
+	if _, err := kr.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := rewrapDataKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2*rewrapBatch+10 {
+		t.Fatalf("rewrapped %d keys, want %d", n, 2*rewrapBatch+10)
+	}
+	if counts := keyIDCounts(t); counts[oldKey] != 0 || counts[kr.activeID()] != n {
+		t.Fatalf("key ids after rewrap = %v", counts)
+	}
+	if n, err := rewrapDataKeys(context.Background()); err != nil || n != 0 {
+		t.Fatalf("second rewrap = %d, %v; want 0", n, err)
+	}
+}
+
+func TestRewrapDataKeysConcurrentRotation(t *testing.T) {
+	setupTestDB(t)
+	kr := useTestKeyring(t)
+	oldKey := kr.activeID()
+	for i := 0; i < 150; i++ {
+		insertWrappedUpload(t, kr, fmt.Sprintf("u%04d", i))
+	}
+	if _, err := kr.rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 另一实例共享密钥环文件并再次轮换，它写入的数据密钥使本实例在迁移中途重新加载密钥环、
+	// 切换到更新的主密钥
+	other, err := loadKeyringFile(kr.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest, err := other.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertWrappedUpload(t, other, "u0150")
+	for i := 151; i < 300; i++ {
+		insertWrappedUpload(t, kr, fmt.Sprintf("u%04d", i)) // 本实例尚未重新加载，仍用旧的当前密钥
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := rewrapDataKeys(ctx); err != nil {
+		t.Fatalf("rewrap did not finish: %v", err)
+	}
+	if kr.activeID() != newest {
+		t.Fatalf("active key = %s, want reloaded %s", kr.activeID(), newest)
+	}
+	if counts := keyIDCounts(t); counts[oldKey] != 0 {
+		t.Fatalf("keys still wrapped by the old master key: %v", counts)
+	}
+
+	// 再次迁移后全部使用最新的主密钥
+	if _, err := rewrapDataKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if counts := keyIDCounts(t); len(counts) != 1 || counts[newest] != 300 {
+		t.Fatalf("key ids after second rewrap = %v", counts)
+	}
+}
+
+// encryptedStoreParams 使用新生成的数据密钥构建加密存储参数，aad 为绑定的文件标识
+func encryptedStoreParams(t *testing.T, codec, aad string) storeParams {
+	t.Helper()
+	keyID, wrapped, err := newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := newStoreParams(codec, keyID, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.aead == nil {
+		t.Fatal("no data key generated")
+	}
+	sp.aad = aad
+	return sp
+}
+
+// readStored 打开存储文件并读出全部逻辑内容
+func readStored(path string, sp storeParams) ([]byte, error) {
+	sf, err := openStoredPath(path, sp)
+	if err != nil {
+		return nil, err
+	}
+	defer sf.Close()
+	return io.ReadAll(sf)
+}
+
+// splitStored 按索引拆分加密存储文件的各块，返回块数据与逻辑大小
+func splitStored(t *testing.T, raw []byte) ([][]byte, int64) {
+	t.Helper()
+	trailer := raw[len(raw)-storeTrailerSize:]
+	count := int(binary.LittleEndian.Uint32(trailer[12:]))
+	index := raw[len(raw)-storeTrailerSize-4*count : len(raw)-storeTrailerSize]
+	blocks := make([][]byte, count)
+	off := 0
+	for i := range blocks {
+		n := int(binary.LittleEndian.Uint32(index[4*i:]))
+		blocks[i] = append([]byte(nil), raw[off:off+n]...)
+		off += n
+	}
+	return blocks, int64(binary.LittleEndian.Uint64(trailer[16:]))
+}
+
+// joinStored 由各块重建存储文件，按块重写索引并在尾部写入块数与逻辑大小
+func joinStored(raw []byte, blocks [][]byte, logical int64) []byte {
+	trailer := append([]byte(nil), raw[len(raw)-storeTrailerSize:]...)
+	binary.LittleEndian.PutUint32(trailer[12:], uint32(len(blocks)))
+	binary.LittleEndian.PutUint64(trailer[16:], uint64(logical))
+	var out []byte
+	for _, b := range blocks {
+		out = append(out, b...)
+	}
+	for _, b := range blocks {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(b)))
+	}
+	return append(out, trailer...)
+}
+
+func TestEncryptedStoredFileRoundTrip(t *testing.T) {
+	useTestKeyring(t)
+	for _, codec := range []string{CompressionNone, CompressionZstd} {
+		for _, size := range testBlockSizes {
+			t.Run(fmt.Sprintf("%s/%d", codec, size), func(t *testing.T) {
+				data := testContent(size)
+				sp := encryptedStoreParams(t, codec, "u1")
+				path, _ := writeStored(t, sp, data)
+
+				raw, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// 空文件也有一个加密的最后一块
+				blocks, _ := splitStored(t, raw)
+				if want := max((size+storeBlockSize-1)/storeBlockSize, 1); len(blocks) != want {
+					t.Fatalf("block count = %d, want %d", len(blocks), want)
+				}
+				if size >= 64 && bytes.Contains(raw, data[:64]) {
+					t.Fatal("plaintext found in stored file")
+				}
+				got, err := readStored(path, sp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), size)
+				}
+			})
+		}
+	}
+}
+
+func TestEncryptedStoredFileReadAcrossBlocks(t *testing.T) {
+	useTestKeyring(t)
+	const bs = storeBlockSize
+	data := testContent(bs * 5 / 2)
+	sp := encryptedStoreParams(t, CompressionZstd, "u1")
+	path, _ := writeStored(t, sp, data)
+	sf, err := openStoredPath(path, sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	for _, r := range [][2]int{{bs - 10, 20}, {2*bs - 1, 2}, {bs / 2, 2 * bs}, {len(data) - 5, 5}} {
+		p := make([]byte, r[1])
+		if n, err := sf.ReadAt(p, int64(r[0])); err != nil || n != r[1] || !bytes.Equal(p, data[r[0]:r[0]+r[1]]) {
+			t.Errorf("ReadAt(%d, %d) = %d, %v", r[0], r[1], n, err)
+		}
+	}
+}
+
+func TestEncryptedStoredFileTamper(t *testing.T) {
+	kr := useTestKeyring(t)
+	const bs = storeBlockSize
+	data := testContent(bs * 5 / 2)
+	sp := encryptedStoreParams(t, CompressionNone, "u1")
+	path, _ := writeStored(t, sp, data)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSP := sp
+	otherSP.aad = "u2"
+	otherPath, _ := writeStored(t, otherSP, data)
+	otherRaw, err := os.ReadFile(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBlocks, _ := splitStored(t, otherRaw)
+	chunkSP := sp.forChunk(0)
+	chunkPath, _ := writeStored(t, chunkSP, data)
+	chunkRaw, err := os.ReadFile(chunkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一主密钥下的另一个数据密钥
+	keyID, wrapped, err := kr.wrap(bytes.Repeat([]byte{1}, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey, err := newStoreParams(CompressionNone, keyID, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey.aad = "u1"
+
+	tests := []struct {
+		name    string
+		raw     []byte
+		mutate  func(blocks [][]byte, logical int64) ([][]byte, int64) // 为nil表示不修改
+		sp      storeParams
+		wantErr bool
+	}{
+		{"intact", raw, nil, sp, false},
+		{"intact chunk", chunkRaw, nil, chunkSP, false},
+		{"rebuilt unchanged", raw, func(b [][]byte, n int64) ([][]byte, int64) {
+			return b, n
+		}, sp, false},
+		{"flipped ciphertext byte", raw, func(b [][]byte, n int64) ([][]byte, int64) {
+			b[1][100] ^= 1
+			return b, n
+		}, sp, true},
+		{"swapped blocks", raw, func(b [][]byte, n int64) ([][]byte, int64) {
+			b[0], b[1] = b[1], b[0]
+			return b, n
+		}, sp, true},
+		{"block from another file", raw, func(b [][]byte, n int64) ([][]byte, int64) {
+			b[0] = otherBlocks[0]
+			return b, n
+		}, sp, true},
+		{"final block truncated", raw, func(b [][]byte, n int64) ([][]byte, int64) {
+			return b[:2], 2 * bs
+		}, sp, true},
+		{"final block dropped, size kept", raw, func(b [][]byte, n int64) ([][]byte, int64) {
+			return b[:2], n
+		}, sp, true},
+		{"logical size changed", raw, func(b [][]byte, n int64) ([][]byte, int64) {
+			return b, n - 1
+		}, sp, true},
+		{"other upload id", raw, nil, otherSP, true},
+		{"chunk opened as file", chunkRaw, nil, sp, true},
+		{"other chunk index", chunkRaw, nil, sp.forChunk(1), true},
+		{"wrong data key", raw, nil, wrongKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := tt.raw
+			if tt.mutate != nil {
+				blocks, logical := splitStored(t, tt.raw)
+				blocks, logical = tt.mutate(blocks, logical)
+				content = joinStored(tt.raw, blocks, logical)
+			}
+			p := filepath.Join(t.TempDir(), "tampered")
+			if err := os.WriteFile(p, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readStored(p, tt.sp)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("tampered file read %d bytes without error", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("round trip mismatch: got %d bytes", len(got))
+			}
+		})
+	}
+}
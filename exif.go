@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExifInfo 从图片中提取的EXIF信息
+type ExifInfo struct {
+	Orientation int      `json:"orientation,omitempty"`  // 方向（1-8）
+	Make        string   `json:"make,omitempty"`         // 相机厂商
+	Model       string   `json:"model,omitempty"`        // 相机型号
+	DateTime    string   `json:"date_time,omitempty"`    // 拍摄时间
+	PixelWidth  int      `json:"pixel_width,omitempty"`  // EXIF记录的宽度
+	PixelHeight int      `json:"pixel_height,omitempty"` // EXIF记录的高度
+	GPS         *ExifGPS `json:"gps,omitempty"`          // GPS位置（按策略保留或丢弃）
+}
+
+// ExifGPS GPS位置
+type ExifGPS struct {
+	Latitude  float64  `json:"latitude"`           // 纬度
+	Longitude float64  `json:"longitude"`          // 经度
+	Altitude  *float64 `json:"altitude,omitempty"` // 海拔（米）
+}
+
+// EXIF 标签
+const (
+	exifTagMake        = 0x010F
+	exifTagModel       = 0x0110
+	exifTagOrientation = 0x0112
+	exifTagExifIFD     = 0x8769
+	exifTagGPSIFD      = 0x8825
+	exifTagDateTimeOrg = 0x9003
+	exifTagPixelX      = 0xA002
+	exifTagPixelY      = 0xA003
+
+	gpsTagLatRef = 1
+	gpsTagLat    = 2
+	gpsTagLonRef = 3
+	gpsTagLon    = 4
+	gpsTagAltRef = 5
+	gpsTagAlt    = 6
+
+	exifMaxScan = 1 << 20 // 查找EXIF数据时最多读取的字节数
+)
+
+// readExif 从JPEG、PNG或WebP文件中提取EXIF，未找到时返回nil
+func readExif(r io.Reader) (*ExifInfo, error) {
+	head, err := io.ReadAll(io.LimitReader(r, exifMaxScan))
+	if err != nil {
+		return nil, err
+	}
+
+	var tiff []byte
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8}):
+		tiff = jpegExif(head)
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		tiff = pngExif(head)
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		tiff = webpExif(head)
+	}
+	if tiff == nil {
+		return nil, nil
+	}
+	return parseTIFF(tiff)
+}
+
+// jpegExif 在JPEG的APP1段中查找EXIF
+func jpegExif(data []byte) []byte {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // 图像数据开始或结束
+			return nil
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + size
+		if size < 2 || end > len(data) {
+			return nil
+		}
+		seg := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:]
+		}
+		pos = end
+	}
+	return nil
+}
+
+// pngExif 查找PNG的eXIf块
+func pngExif(data []byte) []byte {
+	pos := 8
+	for pos+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		end := pos + 8 + size
+		if size < 0 || end > len(data) {
+			return nil
+		}
+		if typ == "eXIf" {
+			return data[pos+8 : end]
+		}
+		if typ == "IDAT" || typ == "IEND" {
+			return nil
+		}
+		pos = end + 4 // 跳过CRC
+	}
+	return nil
+}
+
+// webpExif 查找WebP的EXIF块
+func webpExif(data []byte) []byte {
+	pos := 12
+	for pos+8 <= len(data) {
+		typ := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size
+		if size < 0 || end > len(data) {
+			return nil
+		}
+		if typ == "EXIF" {
+			return bytes.TrimPrefix(data[pos+8:end], []byte("Exif\x00\x00"))
+		}
+		pos = end + size%2 // 块按偶数字节对齐
+	}
+	return nil
+}
+
+// tiffReader TIFF结构读取器
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// parseTIFF 解析EXIF的TIFF结构
+func parseTIFF(data []byte) (*ExifInfo, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("exif too short")
+	}
+	t := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid exif byte order")
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("invalid tiff magic")
+	}
+
+	info := &ExifInfo{}
+	ifd0 := t.readIFD(int(t.order.Uint32(data[4:])))
+	if v, ok := ifd0[exifTagOrientation]; ok {
+		info.Orientation = t.uint(v)
+	}
+	info.Make = t.ascii(ifd0[exifTagMake])
+	info.Model = t.ascii(ifd0[exifTagModel])
+
+	if v, ok := ifd0[exifTagExifIFD]; ok {
+		sub := t.readIFD(t.uint(v))
+		info.DateTime = t.ascii(sub[exifTagDateTimeOrg])
+		if v, ok := sub[exifTagPixelX]; ok {
+			info.PixelWidth = t.uint(v)
+		}
+		if v, ok := sub[exifTagPixelY]; ok {
+			info.PixelHeight = t.uint(v)
+		}
+	}
+
+	if v, ok := ifd0[exifTagGPSIFD]; ok {
+		gps := t.readIFD(t.uint(v))
+		lat, latOK := t.degrees(gps[gpsTagLat])
+		lon, lonOK := t.degrees(gps[gpsTagLon])
+		if latOK && lonOK {
+			if strings.HasPrefix(t.ascii(gps[gpsTagLatRef]), "S") {
+				lat = -lat
+			}
+			if strings.HasPrefix(t.ascii(gps[gpsTagLonRef]), "W") {
+				lon = -lon
+			}
+			info.GPS = &ExifGPS{Latitude: lat, Longitude: lon}
+			if alt := t.rationals(gps[gpsTagAlt]); len(alt) == 1 {
+				if ref := gps[gpsTagAltRef]; ref.count > 0 && t.uint(ref) == 1 {
+					alt[0] = -alt[0]
+				}
+				info.GPS.Altitude = &alt[0]
+			}
+		}
+	}
+	return info, nil
+}
+
+// tiffEntry IFD条目
+type tiffEntry struct {
+	typ   uint16 // 数据类型
+	count int    // 数量
+	value []byte // 数据
+}
+
+// tiffTypeSize TIFF数据类型字节数
+var tiffTypeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// readIFD 读取一个IFD中的全部条目，越界数据被忽略
+func (t *tiffReader) readIFD(offset int) map[uint16]tiffEntry {
+	entries := map[uint16]tiffEntry{}
+	if offset < 0 || offset+2 > len(t.data) {
+		return entries
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	for i := 0; i < n; i++ {
+		p := offset + 2 + i*12
+		if p+12 > len(t.data) {
+			break
+		}
+		tag := t.order.Uint16(t.data[p:])
+		typ := t.order.Uint16(t.data[p+2:])
+		count := int(t.order.Uint32(t.data[p+4:]))
+		size, ok := tiffTypeSize[typ]
+		if !ok || count < 0 || count > len(t.data) {
+			continue
+		}
+		total := size * count
+		var value []byte
+		if total <= 4 {
+			value = t.data[p+8 : p+8+total]
+		} else {
+			off := int(t.order.Uint32(t.data[p+8:]))
+			if off < 0 || off+total > len(t.data) {
+				continue
+			}
+			value = t.data[off : off+total]
+		}
+		entries[tag] = tiffEntry{typ: typ, count: count, value: value}
+	}
+	return entries
+}
+
+// uint 读取整数值（BYTE/SHORT/LONG）
+func (t *tiffReader) uint(e tiffEntry) int {
+	switch {
+	case e.typ == 1 && len(e.value) >= 1:
+		return int(e.value[0])
+	case e.typ == 3 && len(e.value) >= 2:
+		return int(t.order.Uint16(e.value))
+	case e.typ == 4 && len(e.value) >= 4:
+		return int(t.order.Uint32(e.value))
+	}
+	return 0
+}
+
+// ascii 读取字符串值
+func (t *tiffReader) ascii(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// rationals 读取无符号有理数数组
+func (t *tiffReader) rationals(e tiffEntry) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+	vals := make([]float64, 0, e.count)
+	for i := 0; i+8 <= len(e.value); i += 8 {
+		num := t.order.Uint32(e.value[i:])
+		den := t.order.Uint32(e.value[i+4:])
+		if den == 0 {
+			return nil
+		}
+		vals = append(vals, float64(num)/float64(den))
+	}
+	return vals
+}
+
+// degrees 将 度/分/秒 转换为十进制角度
+func (t *tiffReader) degrees(e tiffEntry) (float64, bool) {
+	v := t.rationals(e)
+	if len(v) != 3 {
+		return 0, false
+	}
+	return v[0] + v[1]/60 + v[2]/3600, true
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// 健康检查：存活探针只表示进程能够响应；就绪探针检查数据库、存储目录、后台协程与任务积压，
+// 任一组件失败或正在停机时返回 503 及各组件详情，负载均衡据此摘除实例。
+// 版本与提交在构建时注入：
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD)"
+const (
+	HealthOK   = "ok"   // 组件正常
+	HealthFail = "fail" // 组件异常
+
+	healthCheckTimeout = 3 * time.Second                                                     // 就绪检查整体超时
+	webhookStaleAfter  = webhookPollInterval + webhookBatchSize*webhookTimeout + time.Minute // Webhook 协程超过该时间未轮询视为卡住
+)
+
+var (
+	version = "dev" // 构建版本，构建时注入
+	commit  = ""    // 构建提交，未注入时取 Go 工具链记录的 VCS 信息
+
+	healthMinFreeSpace int64 = 1 << 30     // 临时目录与存储目录最小剩余空间
+	healthMaxDBLatency       = time.Second // 数据库 Ping 最大延迟
+	healthMaxBacklog         = 1000        // 到期未投递的 Webhook 最大积压数
+
+	startedAt = time.Now() // 进程启动时间
+
+	processWorkersAlive atomic.Int32 // 运行中的后处理协程数
+	webhookHeartbeat    atomic.Int64 // Webhook 协程最近一次轮询时间（UnixNano）
+)
+
+// HealthComponent 单个组件的检查结果
+type HealthComponent struct {
+	Status  string                 `json:"status"`            // ok / fail
+	Error   string                 `json:"error,omitempty"`   // 失败原因
+	Details map[string]interface{} `json:"details,omitempty"` // 检查数据
+}
+
+// fail 标记组件失败，多个原因以分号连接
+func (c *HealthComponent) fail(msg string) {
+	c.Status = HealthFail
+	if c.Error != "" {
+		c.Error += "; "
+	}
+	c.Error += msg
+}
+
+// buildCommit 返回构建提交
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// LivenessCheck 存活探针，不检查依赖，进程能响应即返回200
+// GET /api/v1/health
+// GET /api/v1/health/live
+func LivenessCheck(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "healthy",
+		"timestamp": time.Now().UTC(),
+		"version":   version,
+		"commit":    buildCommit(),
+		"uptime":    time.Since(startedAt).Round(time.Second).String(),
+	})
+}
+
+// ReadinessCheck 就绪探针，任一组件失败或正在停机时返回503
+// GET /api/v1/health/ready
+func ReadinessCheck(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	components := map[string]*HealthComponent{
+		"database":  checkDatabase(ctx),
+		"tmp_dir":   checkStorageDir(tmpDir),
+		"store_dir": checkStorageDir(finalDir),
+		"workers":   checkWorkers(),
+		"backlog":   checkBacklog(ctx),
+	}
+	if clusterNodeID != "" {
+		components["cluster"] = checkCluster()
+	}
+
+	status, code := "healthy", http.StatusOK
+	for name, c := range components {
+		if c.Status != HealthOK {
+			status, code = "degraded", http.StatusServiceUnavailable
+			reqLogger(r).Warn("Readiness check failed", "component", name, "err", c.Error)
+		}
+	}
+	if draining.Load() {
+		status, code = "draining", http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, code, map[string]interface{}{
+		"status":     status,
+		"timestamp":  time.Now().UTC(),
+		"version":    version,
+		"commit":     buildCommit(),
+		"components": components,
+	})
+}
+
+// checkDatabase 检查数据库连通性与 Ping 延迟
+func checkDatabase(ctx context.Context) *HealthComponent {
+	c := &HealthComponent{Status: HealthOK}
+	start := time.Now()
+	err := db.PingContext(ctx)
+	latency := time.Since(start)
+	stats := db.Stats()
+	c.Details = map[string]interface{}{
+		"latency_ms":       latency.Milliseconds(),
+		"open_connections": stats.OpenConnections,
+		"in_use":           stats.InUse,
+	}
+	if err != nil {
+		c.fail(err.Error())
+	} else if latency > healthMaxDBLatency {
+		c.fail(fmt.Sprintf("ping latency %v exceeds %v", latency.Round(time.Millisecond), healthMaxDBLatency))
+	}
+	return c
+}
+
+// checkStorageDir 检查目录可写且剩余空间充足
+func checkStorageDir(dir string) *HealthComponent {
+	c := &HealthComponent{Status: HealthOK, Details: map[string]interface{}{"path": dir}}
+
+	f, err := os.CreateTemp(dir, ".health-*")
+	if err == nil {
+		_, err = f.Write([]byte("ok"))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		os.Remove(f.Name())
+	}
+	if err != nil {
+		c.fail("not writable: " + err.Error())
+	}
+
+	free, err := diskFree(dir)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+	case err != nil:
+		c.fail("stat filesystem: " + err.Error())
+	default:
+		c.Details["free_bytes"] = free
+		if healthMinFreeSpace > 0 && free < healthMinFreeSpace {
+			c.fail("free space below minimum")
+		}
+	}
+	return c
+}
+
+// checkWorkers 检查后处理协程与 Webhook 投递协程是否在运行
+func checkWorkers() *HealthComponent {
+	c := &HealthComponent{Status: HealthOK}
+	alive := int(processWorkersAlive.Load())
+	c.Details = map[string]interface{}{
+		"process_workers":          alive,
+		"process_workers_expected": processWorkers,
+	}
+	if alive < processWorkers {
+		c.fail("process workers not running")
+	}
+
+	if ts := webhookHeartbeat.Load(); ts == 0 {
+		c.fail("webhook worker not started")
+	} else {
+		last := time.Unix(0, ts)
+		c.Details["webhook_last_poll"] = last.UTC()
+		if time.Since(last) > webhookStaleAfter {
+			c.fail("webhook worker stalled")
+		}
+	}
+	return c
+}
+
+// checkBacklog 检查后处理队列与到期未投递的 Webhook 数量
+func checkBacklog(ctx context.Context) *HealthComponent {
+	c := &HealthComponent{Status: HealthOK}
+	c.Details = map[string]interface{}{
+		"process_queue":          len(processQueue),
+		"process_queue_capacity": cap(processQueue),
+	}
+	if len(processQueue) >= cap(processQueue) {
+		c.fail("process queue full")
+	}
+
+	var pending int
+	err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ?",
+		DeliveryPending, time.Now().UTC(),
+	).Scan(&pending)
+	if err != nil {
+		c.fail("count webhook backlog: " + err.Error())
+		return c
+	}
+	c.Details["webhook_pending"] = pending
+	if healthMaxBacklog > 0 && pending > healthMaxBacklog {
+		c.fail("webhook backlog exceeds maximum")
+	}
+	return c
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 完整性巡检：后台协程按 verified_at 从旧到新逐个读取已完成文件（限速），解码后重新计算
+// MD5 与 SHA-256，与合并时记录的 content_md5 及 metadata 处理器记录的 sha256 比对，
+// 结果写入 uploads.integrity_status / integrity_error / verified_at。
+// 不一致的文件标记为 corrupt，读取时优先使用副本；配置了副本目录时尝试从副本恢复。
+
+// 完整性校验结果
+const (
+	IntegrityOK       = "ok"       // 内容与记录一致
+	IntegrityCorrupt  = "corrupt"  // 内容与记录不一致或无法解码
+	IntegrityMissing  = "missing"  // 存储文件不存在
+	IntegrityRepaired = "repaired" // 曾损坏或丢失，已从副本恢复
+
+	scrubBatchSize      = 50          // 每批巡检的文件数
+	scrubIdleWait       = time.Minute // 没有到期文件时的等待时间
+	integrityReportSize = 100         // 报告默认列出的文件数
+	maxIntegrityReport  = 1000        // 报告最多列出的文件数
+)
+
+var (
+	scrubEnabled  = false              // 是否启用后台巡检
+	scrubRate     = int64(10 << 20)    // 巡检读取速率上限（字节/秒），0表示不限速
+	scrubInterval = 7 * 24 * time.Hour // 同一文件两次校验的最短间隔
+
+	errFileCorrupt = errors.New("file failed integrity verification")
+	errNotInStore  = errors.New("file is not in the store")
+
+	integrityChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "integrity_checks_total",
+		Help: "Stored file integrity checks, by result.",
+	}, []string{"result"})
+	integrityBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "integrity_bytes_verified_total",
+		Help: "File content bytes re-hashed by integrity checks.",
+	})
+)
+
+// IntegrityResult 单个文件的校验结果
+type IntegrityResult struct {
+	UploadID   string    `json:"upload_id"`        // 上传任务ID
+	Status     string    `json:"status"`           // ok / corrupt / missing / repaired
+	Error      string    `json:"error,omitempty"`  // 不一致或恢复的原因
+	MD5        string    `json:"md5,omitempty"`    // 本次计算的内容MD5
+	SHA256     string    `json:"sha256,omitempty"` // 本次计算的内容SHA-256
+	Bytes      int64     `json:"bytes"`            // 读取的内容字节数
+	VerifiedAt time.Time `json:"verified_at"`      // 校验时间
+}
+
+// startScrubber 启用时启动后台巡检协程
+func startScrubber() {
+	if !scrubEnabled {
+		return
+	}
+	slog.Info("Integrity scrubber enabled", "rate", scrubRate, "interval", scrubInterval)
+	goBackground(runScrubber)
+}
+
+// runScrubber 巡检协程：逐批校验到期的文件，没有到期文件时等待
+func runScrubber() {
+	for {
+		files, err := listScrubBatch(backgroundCtx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("Query scrub batch error", "err", err)
+		}
+		for _, file := range files {
+			if backgroundCtx.Err() != nil {
+				return
+			}
+			logger := slog.With("upload_id", file.UploadID)
+			_, err := verifyFile(withLogger(backgroundCtx, logger), file, scrubRate)
+			if err != nil && !errors.Is(err, context.Canceled) && err != sql.ErrNoRows && err != errNotInStore {
+				logger.Error("Integrity check error", "err", err)
+			}
+		}
+		if len(files) == scrubBatchSize {
+			continue
+		}
+		select {
+		case <-time.After(scrubIdleWait):
+		case <-backgroundCtx.Done():
+			return
+		}
+	}
+}
+
+// listScrubBatch 本节点所属、从未校验或距上次校验超过间隔的已完成文件，最久未校验的优先；
+// 扫描中、已隔离或扫描失败的文件不在存储目录中，不参与巡检
+func listScrubBatch(ctx context.Context) ([]*FileRecord, error) {
+	query := "SELECT " + fileColumns + ` FROM uploads
+		WHERE status = ? AND (verified_at IS NULL OR verified_at < ?)
+		AND (scan_status IS NULL OR scan_status NOT IN (?, ?, ?))`
+	args := []interface{}{StatusCompleted, time.Now().Add(-scrubInterval), ScanScanning, ScanInfected, ScanError}
+	if clusterNodeID != "" {
+		query += " AND owner_node = ?"
+		args = append(args, clusterNodeID)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY verified_at IS NOT NULL, verified_at, upload_id LIMIT ?",
+		append(args, scrubBatchSize)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*FileRecord
+	for rows.Next() {
+		file, err := scanFileRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// verifyFile 持有上传任务锁，重新计算文件内容的哈希并与记录比对，结果写入数据库；
+// rate 为读取速率上限，0表示不限速。损坏或丢失时若配置了副本目录则尝试从副本恢复。
+// 返回的错误表示无法完成校验（如密钥不可用），此时仍记录校验时间与原因，避免巡检反复卡在同一文件上
+func verifyFile(ctx context.Context, file *FileRecord, rate int64) (*IntegrityResult, error) {
+	ctx, span := tracer.Start(ctx, "verify_file", trace.WithAttributes(
+		attribute.String("upload.id", file.UploadID),
+	))
+	defer span.End()
+
+	_, unlock, err := lockUpload(ctx, file.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// 加锁前文件可能已被删除、移动到其他存储层级或重新扫描，按最新记录校验
+	file, err = uploadStore.GetUpload(ctx, file.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	switch file.ScanStatus {
+	case ScanScanning, ScanInfected, ScanError:
+		return nil, errNotInStore
+	}
+
+	res, err := hashStoredFile(ctx, file, rate)
+	if errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	if err != nil {
+		failSpan(span, err)
+		integrityChecks.WithLabelValues("error").Inc()
+		recordIntegrity(ctx, file, "", truncateString(err.Error(), 500))
+		return nil, err
+	}
+	integrityBytes.Add(float64(res.Bytes))
+
+	if res.Status == IntegrityOK {
+		expectMD5, expectSHA := file.ContentMD5, metadataSHA256(ctx, file.UploadID)
+		switch {
+		case expectMD5 != "" && res.MD5 != expectMD5:
+			res.Status, res.Error = IntegrityCorrupt, "MD5 mismatch: expected "+expectMD5
+		case expectSHA != "" && res.SHA256 != expectSHA:
+			res.Status, res.Error = IntegrityCorrupt, "SHA-256 mismatch: expected "+expectSHA
+		case expectMD5 == "":
+			// 早于记录MD5的文件：以本次结果作为后续巡检的基准
+			if _, err := db.ExecContext(ctx, "UPDATE uploads SET content_md5 = ?, updated_at = updated_at WHERE upload_id = ? AND content_md5 IS NULL",
+				res.MD5, file.UploadID); err != nil {
+				ctxLogger(ctx).Error("Record baseline MD5 error", "err", err)
+			}
+		}
+	}
+
+	if res.Status != IntegrityOK {
+		ctxLogger(ctx).Warn("Stored file failed integrity check", "status", res.Status, "reason", res.Error)
+		span.SetAttributes(attribute.String("integrity.status", res.Status))
+		if len(replicaTargets) > 0 {
+			if err := restorePrimaryLocked(ctx, file); err == nil {
+				res.Status, res.Error = IntegrityRepaired, "restored from replica after: "+res.Error
+			} else if errors.Is(err, context.Canceled) {
+				return nil, err
+			} else {
+				res.Error += "; restore from replica failed: " + err.Error()
+			}
+		}
+	}
+
+	integrityChecks.WithLabelValues(res.Status).Inc()
+	recordIntegrity(ctx, file, res.Status, truncateString(res.Error, 500))
+	return res, nil
+}
+
+// hashStoredFile 读取并解码主副本，计算内容的 MD5 与 SHA-256；
+// 文件不存在、大小与记录不符或无法解码时返回 missing / corrupt 结果，而非错误
+func hashStoredFile(ctx context.Context, file *FileRecord, rate int64) (*IntegrityResult, error) {
+	res := &IntegrityResult{UploadID: file.UploadID, Status: IntegrityOK, VerifiedAt: time.Now().UTC()}
+	sp, err := fileStoreParams(file)
+	if err != nil {
+		return nil, err
+	}
+	path := storedFilePath(file)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			res.Status, res.Error = IntegrityMissing, "stored file not found"
+			return res, nil
+		}
+		return nil, err
+	}
+
+	f, err := openStoredPath(path, sp)
+	if err != nil {
+		res.Status, res.Error = IntegrityCorrupt, err.Error()
+		return res, nil
+	}
+	defer f.Close()
+	if err := checkStoredSize(f, file); err != nil {
+		res.Status, res.Error = IntegrityCorrupt, err.Error()
+		return res, nil
+	}
+
+	hMD5, hSHA := md5.New(), sha256.New()
+	var r io.Reader = contextReader{ctx: ctx, r: f}
+	if rate > 0 {
+		r = &rateLimitedReader{ctx: ctx, r: r, rate: rate, start: time.Now()}
+	}
+	res.Bytes, err = io.Copy(io.MultiWriter(hMD5, hSHA), r)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// 解压或解密失败（块校验、认证标签不符等）
+		res.Status, res.Error = IntegrityCorrupt, err.Error()
+		return res, nil
+	}
+	res.MD5 = hex.EncodeToString(hMD5.Sum(nil))
+	res.SHA256 = hex.EncodeToString(hSHA.Sum(nil))
+	return res, nil
+}
+
+// metadataSHA256 metadata 处理器记录的内容SHA-256，未处理完成时为空
+func metadataSHA256(ctx context.Context, uploadID string) string {
+	results, err := loadProcessingStatus(ctx, uploadID)
+	if err != nil {
+		ctxLogger(ctx).Error("Load processing status error", "err", err)
+		return ""
+	}
+	if meta := results["metadata"]; meta != nil && meta.Status == ProcessDone {
+		if m, ok := meta.Result.(map[string]interface{}); ok {
+			sum, _ := m["sha256"].(string)
+			return sum
+		}
+	}
+	return ""
+}
+
+// recordIntegrity 写入校验时间与结果；status 为空时只更新时间与原因，保留上次的结果
+func recordIntegrity(ctx context.Context, file *FileRecord, status, reason string) {
+	_, err := db.ExecContext(ctx, `
+		UPDATE uploads
+		SET integrity_status = COALESCE(NULLIF(?, ''), integrity_status), integrity_error = NULLIF(?, ''),
+			verified_at = CURRENT_TIMESTAMP, updated_at = updated_at
+		WHERE upload_id = ?
+	`, status, reason, file.UploadID)
+	if err != nil {
+		ctxLogger(ctx).Error("Record integrity result error", "err", err)
+	}
+}
+
+// markRepaired 主副本从副本恢复后，将损坏或丢失的标记改为 repaired
+func markRepaired(ctx context.Context, uploadID string) {
+	_, err := db.ExecContext(ctx,
+		"UPDATE uploads SET integrity_status = ?, updated_at = updated_at WHERE upload_id = ? AND integrity_status IN (?, ?)",
+		IntegrityRepaired, uploadID, IntegrityCorrupt, IntegrityMissing)
+	if err != nil {
+		ctxLogger(ctx).Error("Record integrity result error", "err", err)
+	}
+}
+
+// rateLimitedReader 按平均速率限制读取，读取超前时休眠
+type rateLimitedReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64     // 字节/秒
+	start time.Time // 开始读取的时间
+	n     int64     // 已读取字节数
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.rate {
+		p = p[:l.rate] // 单次读取不超过一秒的配额，使休眠均匀
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if wait := time.Duration(l.n*int64(time.Second)/l.rate) - time.Since(l.start); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-l.ctx.Done():
+			return n, l.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+// VerifyFile 立即校验单个文件的完整性（不限速），返回校验结果
+// POST /api/v1/files/{upload_id}/verify
+func VerifyFile(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
+	ctx := dbCtx(r)
+
+	file, err := getFileByUploadID(ctx, uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		logger.Error("Database query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if file.UserID != "" && file.UserID != getUserID(r) && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Not the owner of this file")
+		return
+	}
+	if file.Status != StatusCompleted {
+		writeError(w, http.StatusConflict, "File is not completed")
+		return
+	}
+	switch file.ScanStatus {
+	case ScanScanning, ScanInfected, ScanError:
+		writeError(w, http.StatusConflict, "File is not in the store")
+		return
+	}
+
+	res, err := verifyFile(withLogger(ctx, logger), file, 0)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			return
+		case err == sql.ErrNoRows:
+			writeError(w, http.StatusNotFound, "File not found")
+			return
+		case err == errNotInStore:
+			writeError(w, http.StatusConflict, "File is not in the store")
+			return
+		case errors.Is(err, errLockTimeout):
+			writeLockError(w, r, err)
+			return
+		}
+		logger.Error("Integrity check error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Integrity check failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// IntegrityReportItem 报告中的文件
+type IntegrityReportItem struct {
+	UploadID        string     `json:"upload_id"`                 // 上传任务ID
+	FileName        string     `json:"file_name"`                 // 文件名
+	UserID          string     `json:"user_id,omitempty"`         // 上传用户
+	OwnerNode       string     `json:"owner_node,omitempty"`      // 所属节点
+	IntegrityStatus string     `json:"integrity_status"`          // 校验结果
+	IntegrityError  string     `json:"integrity_error,omitempty"` // 不一致或恢复的原因
+	VerifiedAt      *time.Time `json:"verified_at,omitempty"`     // 校验时间
+}
+
+// GetIntegrityReport 完整性巡检报告：已完成文件按校验结果的统计，以及指定结果（默认损坏与丢失）的文件
+// GET /api/v1/admin/integrity?status=corrupt&limit=100
+func GetIntegrityReport(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+	logger := reqLogger(r)
+	ctx := dbCtx(r)
+
+	statuses := []interface{}{IntegrityCorrupt, IntegrityMissing}
+	if s := r.URL.Query().Get("status"); s != "" {
+		switch s {
+		case IntegrityOK, IntegrityCorrupt, IntegrityMissing, IntegrityRepaired:
+			statuses = []interface{}{s}
+		default:
+			writeError(w, http.StatusBadRequest, "Invalid status")
+			return
+		}
+	}
+	limit := integrityReportSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxIntegrityReport {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxIntegrityReport))
+			return
+		}
+		limit = n
+	}
+
+	summary := map[string]int64{"unverified": 0}
+	rows, err := db.QueryContext(ctx, "SELECT COALESCE(integrity_status, ''), COUNT(*) FROM uploads WHERE status = ? GROUP BY integrity_status", StatusCompleted)
+	if err != nil {
+		logger.Error("Query integrity summary error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			rows.Close()
+			logger.Error("Scan integrity summary error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if status == "" {
+			status = "unverified"
+		}
+		summary[status] += n
+	}
+	rows.Close()
+
+	query := `SELECT upload_id, file_name, COALESCE(user_id, ''), COALESCE(owner_node, ''), integrity_status, COALESCE(integrity_error, ''), verified_at
+		FROM uploads WHERE status = ? AND integrity_status IN (?` + strings.Repeat(", ?", len(statuses)-1) + `)
+		ORDER BY verified_at DESC LIMIT ?`
+	rows, err = db.QueryContext(ctx, query, append(append([]interface{}{StatusCompleted}, statuses...), limit)...)
+	if err != nil {
+		logger.Error("Query integrity report error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	items := []*IntegrityReportItem{}
+	for rows.Next() {
+		item := &IntegrityReportItem{}
+		if err := rows.Scan(&item.UploadID, &item.FileName, &item.UserID, &item.OwnerNode,
+			&item.IntegrityStatus, &item.IntegrityError, &item.VerifiedAt); err != nil {
+			logger.Error("Scan integrity report error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		items = append(items, item)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":  scrubEnabled,
+		"rate":     scrubRate,
+		"interval": Duration(scrubInterval),
+		"summary":  summary,
+		"data":     items,
+	})
+}
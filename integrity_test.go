@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+)
+
+// storeTestFile 写入存储文件并将上传任务标记为已完成，返回最新记录
+func storeTestFile(t *testing.T, id string, content []byte) *FileRecord {
+	t.Helper()
+	ctx := context.Background()
+	if err := uploadStore.CreateUpload(ctx, newTestUpload(id, "alice", "", id+".txt", int64(len(content))), nil); err != nil {
+		t.Fatal(err)
+	}
+	file, err := uploadStore.GetUpload(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := fileStoreParams(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := createStoredFile(storedFilePath(file), sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := w.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(content)
+	if err := uploadStore.CompleteUpload(ctx, id, "", stored, hex.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	if file, err = uploadStore.GetUpload(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// useStorageTier 临时配置一个存储层级
+func useStorageTier(t *testing.T, name string) {
+	t.Helper()
+	old := storageTiers
+	storageTiers = map[string]TierConfig{name: {Name: name, Dir: filepath.Join(t.TempDir(), name)}}
+	t.Cleanup(func() { storageTiers = old })
+}
+
+func TestVerifyFileAfterTierMove(t *testing.T) {
+	setupTestDB(t)
+	useStorageTier(t, "cold")
+	ctx := context.Background()
+
+	stale := storeTestFile(t, "u1", []byte("integrity check content"))
+	if err := moveFileTier(ctx, stale, "cold"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 巡检批次中的记录早于迁移：仍按最新记录校验新层级中的文件
+	res, err := verifyFile(ctx, stale, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != IntegrityOK {
+		t.Fatalf("status = %s (%s), want ok", res.Status, res.Error)
+	}
+	file, err := uploadStore.GetUpload(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.IntegrityStatus != IntegrityOK {
+		t.Fatalf("recorded integrity_status = %q, want ok", file.IntegrityStatus)
+	}
+
+	// 主副本已完好时不从副本覆盖
+	restored, err := restorePrimary(ctx, stale)
+	if err != nil || restored {
+		t.Fatalf("restorePrimary = %v, %v; want skipped", restored, err)
+	}
+}
+
+func TestVerifyFileNotInStore(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+
+	file := storeTestFile(t, "u1", []byte("quarantined"))
+	if _, err := db.Exec("UPDATE uploads SET scan_status = ? WHERE upload_id = ?", ScanInfected, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyFile(ctx, file, 0); err != errNotInStore {
+		t.Fatalf("verify infected file: err = %v, want errNotInStore", err)
+	}
+	if err := uploadStore.DeleteUpload(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyFile(ctx, file, 0); err == nil {
+		t.Fatal("verify deleted file: want error")
+	}
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 存储层级与生命周期规则：standard 层级为 storage.final_dir，其他层级为配置的目录（如归档磁盘、
+// 网络存储），移入时可转换压缩算法。规则按顺序匹配已完成的文件，第一条满足条件且会产生变化的规则
+// 生效：move 将文件移动到目标层级，delete 删除文件。执行时持有上传任务锁并按最新记录重新判断条件。
+// 集群模式下各节点只处理自己所属的文件。
+
+// 层级与动作
+const (
+	TierStandard    = "standard" // 默认层级，即 storage.final_dir
+	LifecycleMove   = "move"     // 移动到目标层级
+	LifecycleDelete = "delete"   // 删除文件
+
+	lifecycleBatchSize   = 500       // 每批检查的文件数
+	accessRecordInterval = time.Hour // 同一文件最近访问时间的最小记录间隔
+	maxTags              = 20        // 单个文件最多标签数
+	maxTagLength         = 64        // 单个标签最大长度
+	lifecyclePlanSize    = 100       // 执行计划默认列出的操作数
+	maxLifecyclePlan     = 1000      // 执行计划最多列出的操作数
+)
+
+var (
+	lifecycleEnabled  = false                   // 是否按间隔自动执行规则
+	lifecycleInterval = time.Hour               // 执行间隔
+	lifecycleDryRun   = false                   // 只记录将要执行的操作
+	lifecycleRules    []LifecycleRule           // 规则，按顺序匹配
+	storageTiers      = map[string]TierConfig{} // 配置的存储层级（不含 standard）
+	lifecycleWake     = make(chan struct{}, 1)  // 手动触发执行
+
+	lastLifecycleRun   *LifecycleReport // 最近一次执行的结果
+	lastLifecycleRunMu sync.Mutex       // 保护 lastLifecycleRun
+
+	lifecycleActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "lifecycle_actions_total",
+		Help: "Files moved between tiers or deleted by lifecycle rules, by action and result.",
+	}, []string{"action", "result"})
+)
+
+// LifecycleAction 规则对单个文件的操作
+type LifecycleAction struct {
+	UploadID string `json:"upload_id"`         // 上传任务ID
+	FileName string `json:"file_name"`         // 文件名
+	UserID   string `json:"user_id,omitempty"` // 所属用户
+	Size     int64  `json:"size"`              // 文件大小
+	Rule     string `json:"rule"`              // 规则名称
+	Action   string `json:"action"`            // move / delete
+	FromTier string `json:"from_tier"`         // 当前层级
+	ToTier   string `json:"to_tier,omitempty"` // 目标层级（move）
+	Error    string `json:"error,omitempty"`   // 执行失败的原因
+}
+
+// LifecycleRuleStats 单条规则的统计
+type LifecycleRuleStats struct {
+	Files  int   `json:"files"`  // 匹配的文件数
+	Bytes  int64 `json:"bytes"`  // 匹配的文件总大小
+	Failed int   `json:"failed"` // 执行失败的文件数
+}
+
+// LifecycleReport 一次执行（或执行计划）的结果
+type LifecycleReport struct {
+	DryRun     bool                           `json:"dry_run"`         // 是否仅计划
+	StartedAt  time.Time                      `json:"started_at"`      // 开始时间
+	FinishedAt time.Time                      `json:"finished_at"`     // 结束时间
+	Checked    int                            `json:"checked"`         // 检查的文件数
+	Rules      map[string]*LifecycleRuleStats `json:"rules"`           // 各规则的统计
+	Actions    []*LifecycleAction             `json:"actions"`         // 操作明细（最多 limit 条）
+	Truncated  bool                           `json:"truncated"`       // 操作明细是否被截断
+	Error      string                         `json:"error,omitempty"` // 中止执行的原因
+}
+
+// validTierName 层级名称只允许字母、数字与 -_，最长 32 字符
+func validTierName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeTags 规范化标签：去除首尾空白、转为小写并去重；
+// 标签只允许字母、数字与 -_.:，最长 64 字符，每个文件最多 20 个
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || len(t) > maxTagLength {
+			return nil, fmt.Errorf("Invalid tag: %q", t)
+		}
+		for _, c := range t {
+			switch {
+			case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			case c == '-' || c == '_' || c == '.' || c == ':':
+			default:
+				return nil, fmt.Errorf("Invalid tag: %q", t)
+			}
+		}
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("Too many tags, at most %d", maxTags)
+	}
+	return out, nil
+}
+
+// joinTags 标签存储为逗号分隔的字符串
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// splitTags 解析逗号分隔的标签
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// storedFilePath 文件在其所在层级中的存储路径
+func storedFilePath(file *FileRecord) string {
+	return tierFilePath(file.Tier, file)
+}
+
+// tierFilePath 文件在指定层级中的存储路径，文件名与存储目录中相同
+func tierFilePath(tier string, file *FileRecord) string {
+	path := finalFilePath(file.UploadID, file.FileName)
+	if t, ok := storageTiers[tier]; ok {
+		return filepath.Join(t.Dir, filepath.Base(path))
+	}
+	return path
+}
+
+// recordAccess 记录文件的最近访问时间，同一文件每 accessRecordInterval 最多写一次
+func recordAccess(file *FileRecord) {
+	if file.LastAccessedAt != nil && time.Since(*file.LastAccessedAt) < accessRecordInterval {
+		return
+	}
+	uploadID := file.UploadID
+	goBackground(func() {
+		_, err := db.ExecContext(backgroundCtx, `
+			UPDATE uploads SET last_accessed_at = CURRENT_TIMESTAMP, updated_at = updated_at
+			WHERE upload_id = ? AND (last_accessed_at IS NULL OR last_accessed_at < ?)
+		`, uploadID, time.Now().Add(-accessRecordInterval))
+		if err != nil {
+			slog.Error("Record file access error", "upload_id", uploadID, "err", err)
+		}
+	})
+}
+
+// startLifecycle 检查文件所在的层级均已配置，配置了规则时启动执行协程
+func startLifecycle() {
+	checkStorageTiers()
+	if len(lifecycleRules) == 0 {
+		return
+	}
+	slog.Info("Lifecycle rules loaded", "rules", len(lifecycleRules), "tiers", len(storageTiers),
+		"enabled", lifecycleEnabled, "interval", lifecycleInterval, "dry_run", lifecycleDryRun)
+	goBackground(runLifecycleLoop)
+}
+
+// checkStorageTiers 数据库中存在文件但未配置的层级会导致这些文件无法读取，启动时告警
+func checkStorageTiers() {
+	rows, err := db.QueryContext(context.Background(), "SELECT tier, COUNT(*) FROM uploads WHERE tier IS NOT NULL GROUP BY tier")
+	if err != nil {
+		slog.Error("Query storage tiers error", "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tier string
+		var n int64
+		if err := rows.Scan(&tier, &n); err != nil {
+			slog.Error("Scan storage tiers error", "err", err)
+			return
+		}
+		if _, ok := storageTiers[tier]; !ok {
+			slog.Warn("Files stored in an unconfigured tier", "tier", tier, "files", n)
+		}
+	}
+}
+
+// runLifecycleLoop 执行协程：启用时按间隔执行，也可手动触发
+func runLifecycleLoop() {
+	var tick <-chan time.Time
+	if lifecycleEnabled {
+		ticker := time.NewTicker(lifecycleInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-lifecycleWake:
+		case <-backgroundCtx.Done():
+			return
+		}
+		report, err := runLifecycle(backgroundCtx, lifecycleDryRun, "", maxLifecyclePlan)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			slog.Error("Lifecycle run error", "err", err)
+		}
+		if report.DryRun {
+			for _, a := range report.Actions {
+				slog.Info("Lifecycle dry run", "upload_id", a.UploadID, "rule", a.Rule, "action", a.Action, "from_tier", a.FromTier, "to_tier", a.ToTier)
+			}
+		}
+		for name, stats := range report.Rules {
+			slog.Info("Lifecycle rule finished", "rule", name, "dry_run", report.DryRun,
+				"files", stats.Files, "bytes", stats.Bytes, "failed", stats.Failed)
+		}
+		lastLifecycleRunMu.Lock()
+		lastLifecycleRun = report
+		lastLifecycleRunMu.Unlock()
+	}
+}
+
+// runLifecycle 检查本节点所属的全部已完成文件并执行（dryRun 时只记录）匹配的规则；
+// ruleName 不为空时只报告该规则的操作（匹配仍按全部规则的顺序），明细最多 limit 条
+func runLifecycle(ctx context.Context, dryRun bool, ruleName string, limit int) (*LifecycleReport, error) {
+	report := &LifecycleReport{DryRun: dryRun, StartedAt: time.Now().UTC(), Rules: map[string]*LifecycleRuleStats{}, Actions: []*LifecycleAction{}}
+	for _, rule := range lifecycleRules {
+		if ruleName == "" || rule.Name == ruleName {
+			report.Rules[rule.Name] = &LifecycleRuleStats{}
+		}
+	}
+
+	err := func() error {
+		after := ""
+		for {
+			files, err := listCompletedBatch(ctx, after, lifecycleBatchSize)
+			if err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				return nil
+			}
+			after = files[len(files)-1].UploadID
+			for _, file := range files {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				// 扫描中、已隔离或扫描失败的文件不在存储层级中
+				if file.ScanStatus == ScanScanning || file.ScanStatus == ScanInfected || file.ScanStatus == ScanError {
+					continue
+				}
+				report.Checked++
+				rule := matchLifecycleRule(file, time.Now())
+				if rule == nil || report.Rules[rule.Name] == nil {
+					continue
+				}
+				action := &LifecycleAction{
+					UploadID: file.UploadID, FileName: file.FileName, UserID: file.UserID, Size: file.FileSize,
+					Rule: rule.Name, Action: rule.Action, FromTier: file.Tier,
+				}
+				if rule.Action == LifecycleMove {
+					action.ToTier = rule.Tier
+				}
+				stats := report.Rules[rule.Name]
+				if !dryRun {
+					applied, err := applyLifecycleRule(ctx, rule, file.UploadID)
+					if errors.Is(err, context.Canceled) {
+						return err
+					}
+					if err != nil {
+						action.Error = truncateString(err.Error(), 500)
+						stats.Failed++
+					} else if !applied {
+						continue // 加锁后条件已不满足
+					}
+				}
+				stats.Files++
+				stats.Bytes += file.FileSize
+				if len(report.Actions) < limit {
+					report.Actions = append(report.Actions, action)
+				} else {
+					report.Truncated = true
+				}
+			}
+		}
+	}()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	report.FinishedAt = time.Now().UTC()
+	return report, err
+}
+
+// matchLifecycleRule 第一条满足条件且会改变文件的规则，没有时返回 nil
+func matchLifecycleRule(file *FileRecord, now time.Time) *LifecycleRule {
+	for i := range lifecycleRules {
+		if ruleApplies(&lifecycleRules[i], file, now) {
+			return &lifecycleRules[i]
+		}
+	}
+	return nil
+}
+
+// ruleApplies 判断规则的全部条件是否满足，且移动的目标层级不是文件当前所在层级
+func ruleApplies(rule *LifecycleRule, file *FileRecord, now time.Time) bool {
+	if rule.Action == LifecycleMove && file.Tier == rule.Tier {
+		return false
+	}
+	completedAt := file.UpdatedAt
+	if file.CompletedAt != nil {
+		completedAt = *file.CompletedAt
+	}
+	if rule.MinAge > 0 && now.Sub(completedAt) < time.Duration(rule.MinAge) {
+		return false
+	}
+	if rule.UnaccessedFor > 0 {
+		accessedAt := completedAt
+		if file.LastAccessedAt != nil && file.LastAccessedAt.After(accessedAt) {
+			accessedAt = *file.LastAccessedAt
+		}
+		if now.Sub(accessedAt) < time.Duration(rule.UnaccessedFor) {
+			return false
+		}
+	}
+	if file.FileSize < rule.MinSize || (rule.MaxSize > 0 && file.FileSize > rule.MaxSize) {
+		return false
+	}
+	if len(rule.Users) > 0 && !containsString(rule.Users, file.UserID) {
+		return false
+	}
+	if len(rule.FromTiers) > 0 && !containsString(rule.FromTiers, file.Tier) {
+		return false
+	}
+	if len(rule.Tags) > 0 {
+		for _, t := range file.Tags {
+			if containsString(rule.Tags, t) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// containsString 判断列表中是否包含 s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// applyLifecycleRule 持有上传任务锁，按最新记录重新判断后执行规则；条件已不满足时返回 false
+func applyLifecycleRule(ctx context.Context, rule *LifecycleRule, uploadID string) (bool, error) {
+	logger := slog.With("upload_id", uploadID, "rule", rule.Name)
+	ctx, span := tracer.Start(withLogger(ctx, logger), "lifecycle_"+rule.Action, trace.WithAttributes(
+		attribute.String("upload.id", uploadID),
+		attribute.String("lifecycle.rule", rule.Name),
+	))
+	defer span.End()
+
+	_, unlock, err := lockUpload(ctx, uploadID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	file, err := uploadStore.GetUpload(ctx, uploadID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if file.Status != StatusCompleted || !ruleApplies(rule, file, time.Now()) {
+		return false, nil
+	}
+
+	switch rule.Action {
+	case LifecycleMove:
+		err = moveFileTier(ctx, file, rule.Tier)
+	case LifecycleDelete:
+		if err = removeFile(ctx, logger, file); err == nil {
+			logger.Info("File deleted by lifecycle rule")
+			publishUploadEvent(ctx, EventFileDeleted, file, nil, nil)
+		}
+	}
+	if err != nil {
+		failSpan(span, err)
+		lifecycleActions.WithLabelValues(rule.Action, "error").Inc()
+		logger.Error("Lifecycle action failed", "action", rule.Action, "err", err)
+		return false, err
+	}
+	lifecycleActions.WithLabelValues(rule.Action, "ok").Inc()
+	return true, nil
+}
+
+// moveFileTier 将文件移动到目标层级，调用方需持有上传任务锁。
+// 目标层级配置了不同的压缩算法时重新编码（客户端加密与已压缩格式的文件保持原样），
+// 写入并校验新文件后再更新记录、删除原文件；存储格式变化时副本重新复制
+func moveFileTier(ctx context.Context, file *FileRecord, tier string) error {
+	src, dst := storedFilePath(file), tierFilePath(tier, file)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	sp, err := fileStoreParams(file)
+	if err != nil {
+		return err
+	}
+
+	compression := file.Compression
+	if c := storageTiers[tier].Compression; c != "" && file.ClientEncryption == nil && !storedExtensions[strings.ToLower(filepath.Ext(file.FileName))] {
+		compression = chooseCompression(c, file.FileName)
+	}
+
+	storedSize := file.StoredSize
+	if compression == file.Compression {
+		err = copyStoredFile(ctx, src, dst, sp, file.ContentMD5)
+	} else {
+		var to storeParams
+		if to, err = newStoreParams(compression, file.KeyID, file.WrappedKey); err == nil {
+			to.aad = sp.aad
+			storedSize, err = transcodeStoredFile(ctx, src, dst, sp, to)
+			if err == nil && file.ContentMD5 != "" {
+				if err = verifyStoredMD5(ctx, dst, to, file.ContentMD5, nil); err != nil {
+					os.Remove(dst)
+				}
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		"UPDATE uploads SET tier = NULLIF(?, ?), compression = NULLIF(?, ''), stored_size = ?, updated_at = updated_at WHERE upload_id = ?",
+		tier, TierStandard, compression, storedSize, file.UploadID)
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if err := os.Remove(src); err != nil && !os.IsNotExist(err) {
+		ctxLogger(ctx).Error("Remove stored file error", "path", src, "err", err)
+	}
+
+	if compression != file.Compression && len(replicaTargets) > 0 {
+		if _, err := db.ExecContext(ctx, `
+			UPDATE file_replicas SET status = ?, attempts = 0, last_error = NULL, next_attempt_at = CURRENT_TIMESTAMP
+			WHERE upload_id = ?
+		`, ReplicaPending, file.UploadID); err != nil {
+			ctxLogger(ctx).Error("Requeue replicas error", "err", err)
+		}
+		wakeReplication()
+	}
+	ctxLogger(ctx).Info("File moved to tier", "from_tier", file.Tier, "to_tier", tier,
+		"compression", compression, "stored_size", storedSize)
+	return nil
+}
+
+// transcodeStoredFile 按新的编码参数重写存储文件，返回新文件的物理大小
+func transcodeStoredFile(ctx context.Context, src, dst string, from, to storeParams) (int64, error) {
+	in, err := openStoredPath(src, from)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	w, err := createStoredFile(dst, to)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(w, contextReader{ctx: ctx, r: in}); err != nil {
+		w.Abort()
+		return 0, err
+	}
+	return w.Commit()
+}
+
+// UpdateFileTags 替换文件的标签
+// PUT /api/v1/files/{upload_id}/tags
+func UpdateFileTags(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
+	ctx := dbCtx(r)
+
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, err := getFileByUploadID(ctx, uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		logger.Error("Database query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if file.UserID != "" && file.UserID != getUserID(r) && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Not the owner of this file")
+		return
+	}
+
+	if _, err := db.ExecContext(ctx, "UPDATE uploads SET tags = NULLIF(?, ''), updated_at = updated_at WHERE upload_id = ?",
+		joinTags(tags), uploadID); err != nil {
+		logger.Error("Update tags error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"upload_id": uploadID,
+		"tags":      tags,
+	})
+}
+
+// TierStats 存储层级的统计
+type TierStats struct {
+	Name        string `json:"name"`                  // 层级名称
+	Dir         string `json:"dir"`                   // 存储目录
+	Compression string `json:"compression,omitempty"` // 移入时转换的压缩算法
+	Files       int64  `json:"files"`                 // 已完成的文件数
+	StoredBytes int64  `json:"stored_bytes"`          // 占用的存储空间
+}
+
+// GetLifecycleStatus 存储层级统计、规则与最近一次执行的结果
+// GET /api/v1/admin/lifecycle
+func GetLifecycleStatus(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+
+	tiers := map[string]*TierStats{TierStandard: {Name: TierStandard, Dir: finalDir}}
+	order := []string{TierStandard}
+	for _, t := range config.Lifecycle.Tiers {
+		tiers[t.Name] = &TierStats{Name: t.Name, Dir: t.Dir, Compression: t.Compression}
+		order = append(order, t.Name)
+	}
+	rows, err := db.QueryContext(dbCtx(r), `
+		SELECT COALESCE(tier, ''), COUNT(*), COALESCE(SUM(COALESCE(stored_size, total_size)), 0)
+		FROM uploads WHERE status = ? GROUP BY tier
+	`, StatusCompleted)
+	if err != nil {
+		reqLogger(r).Error("Query tier stats error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var files, bytes int64
+		if err := rows.Scan(&name, &files, &bytes); err != nil {
+			reqLogger(r).Error("Scan tier stats error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if name == "" {
+			name = TierStandard
+		}
+		t := tiers[name]
+		if t == nil {
+			t = &TierStats{Name: name} // 未配置的层级
+			tiers[name] = t
+			order = append(order, name)
+		}
+		t.Files += files
+		t.StoredBytes += bytes
+	}
+
+	data := make([]*TierStats, 0, len(order))
+	for _, name := range order {
+		data = append(data, tiers[name])
+	}
+	lastLifecycleRunMu.Lock()
+	last := lastLifecycleRun
+	lastLifecycleRunMu.Unlock()
+	rules := lifecycleRules
+	if rules == nil {
+		rules = []LifecycleRule{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":  lifecycleEnabled,
+		"interval": Duration(lifecycleInterval),
+		"dry_run":  lifecycleDryRun,
+		"tiers":    data,
+		"rules":    rules,
+		"last_run": last,
+	})
+}
+
+// PlanLifecycle 按当前规则生成执行计划，不移动或删除文件
+// GET /api/v1/admin/lifecycle/plan?rule=archive-old&limit=100
+func PlanLifecycle(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+	if len(lifecycleRules) == 0 {
+		writeError(w, http.StatusNotFound, "No lifecycle rules configured")
+		return
+	}
+	ruleName := r.URL.Query().Get("rule")
+	if ruleName != "" {
+		found := false
+		for _, rule := range lifecycleRules {
+			found = found || rule.Name == ruleName
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, "Unknown rule: "+ruleName)
+			return
+		}
+	}
+	limit := lifecyclePlanSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxLifecyclePlan {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLifecyclePlan))
+			return
+		}
+		limit = n
+	}
+
+	report, err := runLifecycle(dbCtx(r), true, ruleName, limit)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		reqLogger(r).Error("Plan lifecycle error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// RunLifecycle 立即在后台执行一次生命周期规则（lifecycle.dry_run 开启时仍只记录）
+// POST /api/v1/admin/lifecycle/run
+func RunLifecycle(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+	if len(lifecycleRules) == 0 {
+		writeError(w, http.StatusNotFound, "No lifecycle rules configured")
+		return
+	}
+	select {
+	case lifecycleWake <- struct{}{}:
+	default: // 已有待执行的任务
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"message": "Lifecycle run scheduled",
+		"dry_run": lifecycleDryRun,
+	})
+}
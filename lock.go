@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 上传任务锁：完成上传与删除文件需对同一上传任务互斥。请求先在进程内按上传ID排队
+// （条目按引用计数，最后一个持有或等待者离开后删除），再按 lock.backend 获取跨实例的锁：
+//
+//	local     仅进程内互斥，适合单实例部署
+//	database  MySQL GET_LOCK / PostgreSQL advisory lock，会话级，连接断开即释放
+//	redis     SET NX PX 租约锁，持有期间定期续约，进程退出后租约到期自动释放
+const (
+	lockRetryInterval  = 100 * time.Millisecond // 锁被其他实例持有时的重试间隔
+	lockReleaseTimeout = 5 * time.Second        // 释放锁的超时
+)
+
+var (
+	lockTimeout = time.Minute      // 等待锁的最长时间
+	lockLease   = 30 * time.Second // 租约时长，每 1/3 租约续约一次
+
+	uploadLocker lockBackend // 跨实例锁后端，local 时为 nil
+
+	uploadLocks   = map[string]*localLock{} // 进程内上传任务锁
+	uploadLocksMu sync.Mutex                // 保护 uploadLocks
+)
+
+var (
+	errLockTimeout = errors.New("timed out waiting for upload lock")
+	errLockLost    = errors.New("upload lock lease lost")
+)
+
+// localLock 进程内上传任务锁
+type localLock struct {
+	sem  chan struct{} // 容量为1的信号量，等待可被取消
+	refs int           // 持有与等待中的请求数
+}
+
+// lockBackend 跨实例锁后端
+type lockBackend interface {
+	// tryAcquire 尝试获取锁，已被其他实例持有时返回 false；release 释放锁并停止续约。
+	// 持有期间锁被其他实例取得或可能已过期时调用 lost
+	tryAcquire(ctx context.Context, key string, lost func()) (release func(), ok bool, err error)
+}
+
+// initLocker 按配置创建跨实例锁后端
+func initLocker(cfg LockConfig) error {
+	switch cfg.Backend {
+	case "database":
+		uploadLocker = dbLocker{d: dialect}
+	case "redis":
+		l := &redisLocker{addr: cfg.RedisAddr, password: cfg.RedisPassword, db: cfg.RedisDB}
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		if _, err := l.do(ctx, "PING"); err != nil {
+			return err
+		}
+		uploadLocker = l
+	default:
+		uploadLocker = nil
+	}
+	slog.Info("Upload lock backend", "backend", cfg.Backend, "lease", lockLease)
+	return nil
+}
+
+// lockUpload 获取上传任务锁，返回的函数释放锁（可重复调用）
+// ctx 取消时放弃等待；等待超过 lock.timeout 返回 errLockTimeout。
+// 返回的 lease 在跨实例锁丢失（续约失败）时以 errLockLost 取消，释放锁时也会取消；
+// 持有者在落盘与提交前用 leaseLost 检查，锁已丢失时放弃操作
+func lockUpload(ctx context.Context, uploadID string) (lease context.Context, unlock func(), err error) {
+	ctx, cancel := context.WithTimeout(ctx, lockTimeout)
+	defer cancel()
+
+	l := refLocalLock(uploadID)
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		unrefLocalLock(uploadID, l)
+		return nil, nil, lockWaitError(ctx)
+	}
+
+	lease, endLease := context.WithCancelCause(context.Background())
+	release := func() {}
+	if uploadLocker != nil {
+		r, err := acquireDistributed(ctx, "upload:"+uploadID, func() { endLease(errLockLost) })
+		if err != nil {
+			endLease(nil)
+			<-l.sem
+			unrefLocalLock(uploadID, l)
+			return nil, nil, err
+		}
+		release = r
+	}
+
+	var once sync.Once
+	return lease, func() {
+		once.Do(func() {
+			release()
+			endLease(nil)
+			<-l.sem
+			unrefLocalLock(uploadID, l)
+		})
+	}, nil
+}
+
+// leaseLost 持有期间跨实例锁是否已丢失
+func leaseLost(lease context.Context) bool {
+	return errors.Is(context.Cause(lease), errLockLost)
+}
+
+// refLocalLock 获取进程内锁条目并增加引用
+func refLocalLock(uploadID string) *localLock {
+	uploadLocksMu.Lock()
+	defer uploadLocksMu.Unlock()
+	l, ok := uploadLocks[uploadID]
+	if !ok {
+		l = &localLock{sem: make(chan struct{}, 1)}
+		uploadLocks[uploadID] = l
+	}
+	l.refs++
+	return l
+}
+
+// unrefLocalLock 减少引用，无人持有或等待时删除条目
+func unrefLocalLock(uploadID string, l *localLock) {
+	uploadLocksMu.Lock()
+	defer uploadLocksMu.Unlock()
+	if l.refs--; l.refs == 0 {
+		delete(uploadLocks, uploadID)
+	}
+}
+
+// acquireDistributed 重试获取跨实例锁直到成功或 ctx 结束
+func acquireDistributed(ctx context.Context, key string, lost func()) (func(), error) {
+	for {
+		release, ok, err := uploadLocker.tryAcquire(ctx, key, lost)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return release, nil
+		}
+		select {
+		case <-time.After(lockRetryInterval):
+		case <-ctx.Done():
+			return nil, lockWaitError(ctx)
+		}
+	}
+}
+
+// lockWaitError 等待结束的原因：超时或请求取消
+func lockWaitError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errLockTimeout
+	}
+	return ctx.Err()
+}
+
+// writeLockError 获取锁失败时的响应
+func writeLockError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errLockTimeout):
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusConflict, "Upload is busy, retry later")
+	case errors.Is(err, errLockLost):
+		reqLogger(r).Warn("Upload lock lost, operation aborted")
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusConflict, "Upload lock lost, retry later")
+	case errors.Is(err, context.Canceled):
+		// 客户端已断开
+	default:
+		reqLogger(r).Error("Acquire upload lock error", "err", err)
+		writeError(w, http.StatusServiceUnavailable, "Lock service unavailable")
+	}
+}
+
+// keepLease 持有锁期间每 1/3 租约调用一次 renew，返回的函数停止续约。
+// renew 返回 errLockLost，或连续失败到下次续约前租约即到期时，调用 lost 并停止续约
+func keepLease(key string, renew func(ctx context.Context) error, lost func()) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockLease / 3)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), lockLease/3)
+			err := renew(ctx)
+			cancel()
+			switch {
+			case err == nil:
+				renewed = time.Now()
+			case errors.Is(err, errLockLost) || time.Since(renewed) >= lockLease-lockLease/3:
+				slog.Error("Upload lock lease lost", "key", key, "err", err)
+				lost()
+				return
+			default:
+				slog.Warn("Renew upload lock lease error", "key", key, "err", err)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// dbLocker 数据库会话级命名锁，持锁期间占用一个连接
+type dbLocker struct {
+	d *sqlDialect
+}
+
+func (l dbLocker) tryAcquire(ctx context.Context, key string, lost func()) (func(), bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	var ok sql.NullBool
+	if err := conn.QueryRowContext(ctx, l.d.tryLock, key).Scan(&ok); err != nil || !ok.Bool {
+		conn.Close()
+		return nil, false, err
+	}
+
+	// 定期 Ping 保持连接活跃，避免空闲超时断开导致锁被释放；
+	// 会话级锁随连接释放，Ping 失败即视为锁已丢失
+	stop := keepLease(key, func(ctx context.Context) error {
+		if err := conn.PingContext(ctx); err != nil {
+			return fmt.Errorf("%w: %v", errLockLost, err)
+		}
+		return nil
+	}, lost)
+	return func() {
+		stop()
+		ctx, cancel := context.WithTimeout(context.Background(), lockReleaseTimeout)
+		defer cancel()
+		if _, err := conn.ExecContext(ctx, l.d.unlock, key); err != nil {
+			slog.Warn("Release upload lock error", "key", key, "err", err)
+			// 丢弃连接，避免仍持有锁的会话回到连接池
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisTimeout   = 5 * time.Second   // 连接与读写超时
+	redisKeyPrefix = "go-upload:lock:" // 锁键前缀
+
+	// 仅当值仍为本实例的令牌时续约或删除，避免误操作其他实例已获取的锁
+	redisRenewScript   = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+	redisReleaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+)
+
+// redisError 服务端返回的错误应答
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisLocker 基于 Redis 协议（RESP）的租约锁，兼容 Redis、Valkey、KeyDB 等
+type redisLocker struct {
+	addr     string // host:port
+	password string // 为空时不认证
+	db       int    // 数据库编号
+}
+
+func (l *redisLocker) tryAcquire(ctx context.Context, key string, lost func()) (func(), bool, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, false, err
+	}
+	key = redisKeyPrefix + key
+	lease := strconv.FormatInt(lockLease.Milliseconds(), 10)
+	reply, err := l.do(ctx, "SET", key, token, "NX", "PX", lease)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+
+	stop := keepLease(key, func(ctx context.Context) error {
+		n, err := l.do(ctx, "EVAL", redisRenewScript, "1", key, token, lease)
+		if err == nil && n == int64(0) {
+			err = errLockLost
+		}
+		return err
+	}, lost)
+	return func() {
+		stop()
+		ctx, cancel := context.WithTimeout(context.Background(), lockReleaseTimeout)
+		defer cancel()
+		if _, err := l.do(ctx, "EVAL", redisReleaseScript, "1", key, token); err != nil {
+			slog.Warn("Release upload lock error", "key", key, "err", err)
+		}
+	}, true, nil
+}
+
+// do 建立连接并执行命令，返回应答：字符串、int64、nil 或数组
+// 每次调用新建连接，认证与选库命令在同一次写入中发送
+func (l *redisLocker) do(ctx context.Context, args ...string) (interface{}, error) {
+	dialer := net.Dialer{Timeout: redisTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", l.addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect redis: %v", err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var cmds [][]string
+	if l.password != "" {
+		cmds = append(cmds, []string{"AUTH", l.password})
+	}
+	if l.db != 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(l.db)})
+	}
+	cmds = append(cmds, args)
+
+	var b strings.Builder
+	for _, cmd := range cmds {
+		fmt.Fprintf(&b, "*%d\r\n", len(cmd))
+		for _, a := range cmd {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	if _, err := io.WriteString(conn, b.String()); err != nil {
+		return nil, fmt.Errorf("send redis command: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	var reply interface{}
+	for range cmds {
+		if reply, err = readRedisReply(br); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// readRedisReply 读取一个 RESP 应答
+func readRedisReply(br *bufio.Reader) (interface{}, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read redis reply: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("invalid redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, fmt.Errorf("read redis reply: %v", err)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisReply(br); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply: %q", line)
+}
+
+// randomToken 锁令牌，标识持有者
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// lostLocker 获取后立即报告锁已丢失的跨实例锁，模拟持有期间租约被其他实例取得
+type lostLocker struct{}
+
+func (lostLocker) tryAcquire(ctx context.Context, key string, lost func()) (func(), bool, error) {
+	lost()
+	return func() {}, true, nil
+}
+
+// useLocker 替换跨实例锁后端，测试结束后恢复
+func useLocker(t *testing.T, l lockBackend) {
+	t.Helper()
+	old := uploadLocker
+	uploadLocker = l
+	t.Cleanup(func() { uploadLocker = old })
+}
+
+func TestKeepLease(t *testing.T) {
+	old := lockLease
+	lockLease = 30 * time.Millisecond
+	t.Cleanup(func() { lockLease = old })
+
+	errDown := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		replies []error // 依次返回的续约结果，用完后一直成功
+		lost    bool
+	}{
+		{"renewed", nil, false},
+		{"taken by another instance", []error{errLockLost}, true},
+		{"single transient error", []error{errDown}, false},
+		{"failing until expiry", []error{errDown, errDown, errDown}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			lost := make(chan struct{})
+			stop := keepLease("test", func(ctx context.Context) error {
+				n := int(atomic.AddInt32(&calls, 1))
+				if n <= len(tt.replies) {
+					return tt.replies[n-1]
+				}
+				return nil
+			}, func() { close(lost) })
+
+			select {
+			case <-lost:
+			case <-time.After(10 * lockLease):
+			}
+			stop()
+			select {
+			case <-lost:
+				if !tt.lost {
+					t.Fatalf("lease reported lost after %d renewals", atomic.LoadInt32(&calls))
+				}
+			default:
+				if tt.lost {
+					t.Fatal("lease loss not reported")
+				}
+			}
+		})
+	}
+}
+
+func TestLockUploadLease(t *testing.T) {
+	lease, unlock, err := lockUpload(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Err() != nil || leaseLost(lease) {
+		t.Fatal("local lock lease should be held")
+	}
+	unlock()
+	if lease.Err() == nil || leaseLost(lease) {
+		t.Fatal("released lease should be done but not lost")
+	}
+
+	useLocker(t, lostLocker{})
+	lease, unlock, err = lockUpload(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if !leaseLost(lease) {
+		t.Fatal("lease loss not propagated to the holder")
+	}
+}
+
+func TestLeaseLostAbortsWrites(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	router := tracedUploadRouter()
+	files := mux.NewRouter()
+	files.HandleFunc("/api/v1/files/{upload_id}", DeleteFile).Methods("DELETE")
+
+	data := []byte("lease lost during merge")
+	body, _ := json.Marshal(UploadRequest{FileName: "a.txt", TotalSize: int64(len(data)), ChunkSize: len(data)})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/uploads", bytes.NewReader(body)))
+	var created UploadResponse
+	json.Unmarshal(w.Body.Bytes(), &created)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/uploads/"+created.UploadID+"/chunks/0", bytes.NewReader(data)))
+	if w.Code >= 300 {
+		t.Fatalf("upload chunk: %d %s", w.Code, w.Body.String())
+	}
+
+	useLocker(t, lostLocker{})
+
+	// 锁丢失时不提交合并结果，任务保持 in_progress
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/uploads/"+created.UploadID+"/complete", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("complete with lost lock: %d %s, want 409", w.Code, w.Body.String())
+	}
+	file, err := uploadStore.GetUpload(ctx, created.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Status != StatusInProgress {
+		t.Fatalf("status = %s, want in_progress", file.Status)
+	}
+
+	// 锁丢失时不删除
+	w = httptest.NewRecorder()
+	files.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/files/"+created.UploadID, nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("delete with lost lock: %d %s, want 409", w.Code, w.Body.String())
+	}
+	if _, err := uploadStore.GetUpload(ctx, created.UploadID); err != nil {
+		t.Fatalf("record deleted despite lost lock: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 结构化日志：基于 log/slog，支持日志级别与 text/json 输出。
+// 每个请求带有 request_id（取自 X-Request-ID 请求头，缺失或非法时自动生成并在响应头返回），
+// 处理函数通过 reqLogger(r) 获取带 request_id、user_id 的日志器，再附加 upload_id 等字段，
+// 合并与清理沿用同一日志器，便于按请求或上传任务串联日志。
+const (
+	requestIDHeader    = "X-Request-ID" // 请求ID请求头/响应头
+	maxRequestIDLength = 128            // 客户端提供的请求ID最大长度
+)
+
+type loggerKey struct{}
+
+// initLogger 按配置设置全局日志器，标准库 log 的输出也会转入该日志器
+func initLogger(cfg LogConfig) {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(cfg.Level)) // 已在配置校验中检查
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// validLogLevel 检查日志级别
+func validLogLevel(s string) bool {
+	switch strings.ToLower(s) {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// fatal 记录错误并退出
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// withLogger 将日志器存入上下文
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// ctxLogger 获取上下文中的日志器，不存在时返回全局日志器
+func ctxLogger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// reqLogger 获取请求的日志器（带 request_id、user_id）
+func reqLogger(r *http.Request) *slog.Logger {
+	return ctxLogger(r.Context())
+}
+
+// withRequestID 生成或沿用请求ID，写入响应头，并为请求创建日志器
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		if userID := getUserID(r); userID != "" {
+			logger = logger.With("user_id", userID)
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(withLogger(r.Context(), logger)))
+		logger.Debug("HTTP request",
+			"method", r.Method, "path", r.URL.Path, "status", rec.code(), "duration", time.Since(start))
+	})
+}
+
+// validRequestID 客户端提供的请求ID只允许字母、数字与 -_.: ，避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// 测试中只关心断言结果，丢弃服务日志
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	applyConfig(defaultConfig())
+	os.Exit(m.Run())
+}
+
+// setupTestDB 在临时目录中初始化SQLite数据库并执行全部迁移，
+// 同时将上传与存储目录指向临时目录，测试结束后恢复
+func setupTestDB(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	oldTmp, oldFinal, oldQuarantine := tmpDir, finalDir, quarantineDir
+	tmpDir = filepath.Join(dir, "tmp")
+	finalDir = filepath.Join(dir, "store")
+	quarantineDir = filepath.Join(dir, "quarantine")
+	for _, d := range []string{tmpDir, finalDir, quarantineDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := defaultConfig().Database
+	cfg.Driver = "sqlite"
+	cfg.DSN = filepath.Join(dir, "test.db")
+	if err := initDB(cfg); err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	if _, err := migrateUp(context.Background(), "sqlite"); err != nil {
+		t.Fatalf("migrateUp: %v", err)
+	}
+
+	t.Cleanup(func() {
+		background.Wait() // 等待请求触发的后台任务（如记录访问时间）结束，避免访问已关闭或被替换的数据库
+		db.Close()
+		tmpDir, finalDir, quarantineDir = oldTmp, oldFinal, oldQuarantine
+	})
+	return dir
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// 元数据导入导出：在不同数据库之间迁移元数据（如边缘节点的 SQLite 与中心的 MySQL）。
+// 导出为 JSON Lines，首行为文件头，其后每行一条记录 {"table": ..., "row": {...}}；
+// 各列按声明的类型编码，与驱动返回的原始类型无关。导入在单个事务中进行，主键冲突时整体回滚。
+const metadataFormat = "go-upload-metadata"
+
+// 列类型
+const (
+	colString = iota // 字符串（含 JSON 文本）
+	colInt           // 整数
+	colBool          // 布尔
+	colTime          // 时间，RFC3339 编码
+	colBytes         // 二进制，base64 编码
+)
+
+// metadataColumn 导出的列
+type metadataColumn struct {
+	name string
+	kind int
+}
+
+// metadataTable 导出的表，按外键依赖顺序排列
+type metadataTable struct {
+	name    string
+	columns []metadataColumn
+}
+
+// metadataTables 导出的表与列；upload_chunks 的自增 id 不导出，导入时由目标库生成；
+// cluster_nodes 为运行时状态，file_replicas 与本机副本目录相关，均不导出（导入后由副本修复任务重新生成）
+var metadataTables = []metadataTable{
+	{"uploads", []metadataColumn{
+		{"upload_id", colString}, {"file_name", colString}, {"total_size", colInt}, {"chunk_size", colInt},
+		{"total_chunks", colInt}, {"status", colString}, {"created_at", colTime}, {"updated_at", colTime},
+		{"extra", colString}, {"user_id", colString}, {"folder", colString}, {"scan_status", colString},
+		{"compression", colString}, {"stored_size", colInt}, {"key_id", colString}, {"wrapped_key", colBytes},
+		{"client_encryption", colString}, {"owner_node", colString}, {"content_md5", colString},
+		{"integrity_status", colString}, {"integrity_error", colString}, {"verified_at", colTime},
+		{"tier", colString}, {"tags", colString}, {"last_accessed_at", colTime}, {"presigned", colBool},
+	}},
+	{"upload_chunks", []metadataColumn{
+		{"upload_id", colString}, {"chunk_index", colInt}, {"chunk_size", colInt}, {"chunk_md5", colString},
+		{"received_at", colTime},
+	}},
+	{"file_shares", []metadataColumn{
+		{"share_id", colString}, {"user_id", colString}, {"upload_id", colString}, {"folder", colString},
+		{"scope", colString}, {"password_hash", colString}, {"max_downloads", colInt}, {"download_count", colInt},
+		{"expires_at", colTime}, {"revoked", colBool}, {"created_at", colTime},
+	}},
+	{"webhooks", []metadataColumn{
+		{"webhook_id", colString}, {"user_id", colString}, {"url", colString}, {"secret", colString},
+		{"events", colString}, {"is_global", colBool}, {"active", colBool}, {"created_at", colTime},
+	}},
+	{"webhook_deliveries", []metadataColumn{
+		{"delivery_id", colString}, {"webhook_id", colString}, {"event", colString}, {"payload", colString},
+		{"status", colString}, {"attempts", colInt}, {"last_status_code", colInt}, {"last_error", colString},
+		{"next_attempt_at", colTime}, {"created_at", colTime}, {"delivered_at", colTime},
+	}},
+}
+
+// metadataHeader 导出文件头
+type metadataHeader struct {
+	Format        string    `json:"format"`         // 固定为 go-upload-metadata
+	SchemaVersion int       `json:"schema_version"` // 导出时的表结构版本
+	Driver        string    `json:"driver"`         // 来源数据库驱动
+	ExportedAt    time.Time `json:"exported_at"`    // 导出时间
+}
+
+// metadataRecord 导出文件中的一条记录
+type metadataRecord struct {
+	Table string                     `json:"table"`
+	Row   map[string]json.RawMessage `json:"row"`
+}
+
+// columnNames 列名列表
+func (t *metadataTable) columnNames() string {
+	names := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		names[i] = c.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// scanTarget 按列类型创建扫描目标
+func (c metadataColumn) scanTarget() interface{} {
+	switch c.kind {
+	case colInt:
+		return &sql.NullInt64{}
+	case colBool:
+		return &sql.NullBool{}
+	case colTime:
+		return &sql.NullTime{}
+	case colBytes:
+		return &[]byte{}
+	default:
+		return &sql.NullString{}
+	}
+}
+
+// exportValue 将扫描结果转换为 JSON 值，NULL 转为 nil
+func exportValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+	case *sql.NullInt64:
+		if v.Valid {
+			return v.Int64
+		}
+	case *sql.NullBool:
+		if v.Valid {
+			return v.Bool
+		}
+	case *sql.NullTime:
+		if v.Valid {
+			return v.Time.UTC().Format(time.RFC3339Nano)
+		}
+	case *[]byte:
+		if *v != nil {
+			return base64.StdEncoding.EncodeToString(*v)
+		}
+	}
+	return nil
+}
+
+// importValue 将 JSON 值按列类型转换为语句参数
+func importValue(c metadataColumn, raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	switch c.kind {
+	case colInt:
+		var n int64
+		err := json.Unmarshal(raw, &n)
+		return n, err
+	case colBool:
+		var b bool
+		err := json.Unmarshal(raw, &b)
+		return b, err
+	case colTime:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case colBytes:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(s)
+	default:
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	}
+}
+
+// latestSchemaVersion 二进制已知的最新表结构版本
+func latestSchemaVersion(driver string) (int, error) {
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].version, nil
+}
+
+// exportMetadata 导出全部元数据，返回各表记录数
+func exportMetadata(ctx context.Context, w io.Writer, driver string) (map[string]int, error) {
+	version, err := latestSchemaVersion(driver)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(w)
+	err = enc.Encode(metadataHeader{Format: metadataFormat, SchemaVersion: version, Driver: driver, ExportedAt: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for i := range metadataTables {
+		t := &metadataTables[i]
+		n, err := exportTable(ctx, enc, t)
+		if err != nil {
+			return counts, fmt.Errorf("export %s: %v", t.name, err)
+		}
+		counts[t.name] = n
+	}
+	return counts, nil
+}
+
+// exportTable 导出一张表
+func exportTable(ctx context.Context, enc *json.Encoder, t *metadataTable) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+t.columnNames()+" FROM "+t.name)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	targets := make([]interface{}, len(t.columns))
+	for rows.Next() {
+		for i, c := range t.columns {
+			targets[i] = c.scanTarget()
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return n, err
+		}
+		row := make(map[string]interface{}, len(t.columns))
+		for i, c := range t.columns {
+			row[c.name] = exportValue(targets[i])
+		}
+		rec := struct {
+			Table string                 `json:"table"`
+			Row   map[string]interface{} `json:"row"`
+		}{t.name, row}
+		if err := enc.Encode(rec); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// importMetadata 在单个事务中导入元数据，返回各表记录数
+func importMetadata(ctx context.Context, r io.Reader, driver string) (map[string]int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header metadataHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+	if header.Format != metadataFormat {
+		return nil, errors.New("not a go-upload metadata export")
+	}
+	version, err := latestSchemaVersion(driver)
+	if err != nil {
+		return nil, err
+	}
+	if header.SchemaVersion != version {
+		return nil, fmt.Errorf("export has schema version %d, this server expects %d", header.SchemaVersion, version)
+	}
+
+	tables := map[string]*metadataTable{}
+	for i := range metadataTables {
+		tables[metadataTables[i].name] = &metadataTables[i]
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	counts := map[string]int{}
+	stmts := map[string]*sql.Stmt{}
+	for n := 1; ; n++ {
+		var rec metadataRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %v", n, err)
+		}
+		t := tables[rec.Table]
+		if t == nil {
+			return nil, fmt.Errorf("record %d: unknown table %q", n, rec.Table)
+		}
+
+		stmt := stmts[t.name]
+		if stmt == nil {
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(t.columns)), ", ")
+			stmt, err = tx.PrepareContext(ctx, "INSERT INTO "+t.name+" ("+t.columnNames()+") VALUES ("+placeholders+")")
+			if err != nil {
+				return nil, err
+			}
+			defer stmt.Close()
+			stmts[t.name] = stmt
+		}
+
+		args := make([]interface{}, len(t.columns))
+		for i, c := range t.columns {
+			if args[i], err = importValue(c, rec.Row[c.name]); err != nil {
+				return nil, fmt.Errorf("record %d: column %s.%s: %v", n, t.name, c.name, err)
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return nil, fmt.Errorf("record %d: insert into %s: %v", n, t.name, err)
+		}
+		counts[t.name]++
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// runMetadataCommand 执行 metadata 子命令，返回进程退出码
+// 用法：go-upload metadata <export|import> <文件|-> [配置参数]
+func runMetadataCommand(args []string) int {
+	if len(args) < 2 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(os.Stderr, "usage: go-upload metadata <export|import> <file|-> [flags]")
+		return 2
+	}
+	action, file := args[0], args[1]
+	cfg, code := initCommand(args[2:])
+	if code != 0 {
+		return code
+	}
+	defer db.Close()
+
+	// 导出前同样确保表结构为最新版本，导出文件与导入端的版本一致
+	ctx := context.Background()
+	if err := migrateOnStartup(ctx, cfg.Database); err != nil {
+		slog.Error("Database migration failed", "err", err)
+		return 1
+	}
+
+	var counts map[string]int
+	var err error
+	if action == "export" {
+		out := os.Stdout
+		if file != "-" {
+			if out, err = os.Create(file); err != nil {
+				slog.Error("Create export file error", "err", err)
+				return 1
+			}
+		}
+		bw := bufio.NewWriter(out)
+		counts, err = exportMetadata(ctx, bw, cfg.Database.Driver)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if file != "-" {
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}
+	} else {
+		in := os.Stdin
+		if file != "-" {
+			if in, err = os.Open(file); err != nil {
+				slog.Error("Open import file error", "err", err)
+				return 1
+			}
+			defer in.Close()
+		}
+		counts, err = importMetadata(ctx, in, cfg.Database.Driver)
+	}
+	if err != nil {
+		slog.Error("Metadata "+action+" failed", "err", err)
+		return 1
+	}
+
+	attrs := []interface{}{"driver", cfg.Database.Driver}
+	for _, t := range metadataTables {
+		attrs = append(attrs, t.name, counts[t.name])
+	}
+	slog.Info("Metadata "+action+" completed", attrs...)
+	return 0
+}
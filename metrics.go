@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Prometheus 指标，通过 GET /metrics 以文本格式暴露
+const (
+	metricsNamespace  = "upload"
+	diskUsageInterval = time.Minute // 目录占用统计间隔（遍历目录开销较大，不在抓取时计算）
+)
+
+var (
+	uploadsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "uploads_created_total",
+		Help: "Upload tasks created.",
+	})
+	uploadsCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "uploads_completed_total",
+		Help: "Uploads merged and completed.",
+	})
+	uploadsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "uploads_failed_total",
+		Help: "Uploads that failed to complete, by reason.",
+	}, []string{"reason"})
+	chunksReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "chunks_received_total",
+		Help: "Chunks written successfully.",
+	})
+	bytesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "bytes_received_total",
+		Help: "Chunk bytes received from clients.",
+	})
+	bytesServed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "bytes_served_total",
+		Help: "File content bytes sent to clients (downloads, shares, thumbnails, archives).",
+	})
+
+	chunkWriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Name: "chunk_write_duration_seconds",
+		Help:    "Time to receive and store one chunk.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14), // 10ms ~ 80s
+	})
+	mergeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Name: "merge_duration_seconds",
+		Help:    "Time to merge chunks into the final file.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 14), // 50ms ~ 7min
+	})
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Name: "db_query_duration_seconds",
+		Help:    "Database statement latency, by statement type.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms ~ 4s
+	}, []string{"operation"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "http_requests_total",
+		Help: "HTTP requests, labelled by route template.",
+	}, []string{"route", "method", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Name: "http_request_duration_seconds",
+		Help:    "HTTP request latency, labelled by route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+)
+
+// diskUsage 各目录的占用字节数，由后台协程定期更新
+var diskUsage = map[string]*atomic.Int64{
+	"tmp":        new(atomic.Int64),
+	"store":      new(atomic.Int64),
+	"quarantine": new(atomic.Int64),
+}
+
+// stateCollector 在抓取时读取的状态指标：进行中的上传数、上传锁数量、目录占用
+type stateCollector struct {
+	inProgress  *prometheus.Desc
+	uploadLocks *prometheus.Desc
+	diskUsage   *prometheus.Desc
+}
+
+func newStateCollector() *stateCollector {
+	return &stateCollector{
+		inProgress: prometheus.NewDesc(metricsNamespace+"_uploads_in_progress",
+			"Upload tasks currently in progress.", nil, nil),
+		uploadLocks: prometheus.NewDesc(metricsNamespace+"_upload_locks",
+			"Upload locks held or awaited by this instance.", nil, nil),
+		diskUsage: prometheus.NewDesc(metricsNamespace+"_disk_usage_bytes",
+			"Bytes used on disk by each storage directory.", []string{"dir"}, nil),
+	}
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inProgress
+	ch <- c.uploadLocks
+	ch <- c.diskUsage
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if n, err := uploadStore.CountByStatus(ctx, StatusInProgress); err != nil {
+		slog.Error("Metrics count in-progress uploads error", "err", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.inProgress, prometheus.GaugeValue, float64(n))
+	}
+
+	uploadLocksMu.Lock()
+	locks := len(uploadLocks)
+	uploadLocksMu.Unlock()
+	ch <- prometheus.MustNewConstMetric(c.uploadLocks, prometheus.GaugeValue, float64(locks))
+
+	for dir, v := range diskUsage {
+		ch <- prometheus.MustNewConstMetric(c.diskUsage, prometheus.GaugeValue, float64(v.Load()), dir)
+	}
+}
+
+// startMetrics 注册状态指标并启动目录占用统计协程
+func startMetrics() {
+	prometheus.MustRegister(newStateCollector())
+	goBackground(func() {
+		ticker := time.NewTicker(diskUsageInterval)
+		defer ticker.Stop()
+		for {
+			updateDiskUsage()
+			select {
+			case <-ticker.C:
+			case <-backgroundCtx.Done():
+				return
+			}
+		}
+	})
+}
+
+// updateDiskUsage 统计各存储目录的占用
+func updateDiskUsage() {
+	dirs := map[string]string{"tmp": tmpDir, "store": finalDir, "quarantine": quarantineDir}
+	for name, dir := range dirs {
+		var total int64
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+			return nil
+		})
+		diskUsage[name].Store(total)
+	}
+}
+
+// statusRecorder 记录响应状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+// code 响应状态码，处理函数未显式写入时为200
+func (sr *statusRecorder) code() int {
+	if sr.status == 0 {
+		return http.StatusOK
+	}
+	return sr.status
+}
+
+// Unwrap 供 http.ResponseController 访问底层连接
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// instrumentRoute 记录HTTP请求指标，按 mux 路由模板而非原始路径打标签，避免标签基数膨胀
+func instrumentRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.code())).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// servedWriter 统计输出给客户端的文件内容字节数
+type servedWriter struct {
+	http.ResponseWriter
+}
+
+func (sw servedWriter) Write(p []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(p)
+	bytesServed.Add(float64(n))
+	return n, err
+}
+
+// 数据库语句耗时与追踪：包装驱动连接器，在驱动层统计全部语句（含事务内语句），
+// 并按当前方言改写占位符
+
+// instrumentedConnector 为新建连接添加耗时统计
+type instrumentedConnector struct {
+	driver.Connector
+}
+
+func (c instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn}, nil
+}
+
+// instrumentedConn 转发底层连接实现的可选接口
+type instrumentedConn struct {
+	driver.Conn
+}
+
+func (c *instrumentedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	query = dialect.rebind(query)
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, query: query}, nil
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() // 驱动未实现 BeginTx 时回退
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	query = dialect.rebind(query)
+	start := time.Now()
+	res, err := e.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		observeQuery(ctx, query, start, err)
+	}
+	return res, err
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	query = dialect.rebind(query)
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		observeQuery(ctx, query, start, err)
+	}
+	return rows, err
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if t, ok := nv.Value.(time.Time); ok && dialect.utcTimes {
+		nv.Value = t.UTC()
+	}
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// instrumentedStmt 预编译语句（带参数的查询走此路径）
+type instrumentedStmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	start := time.Now()
+	defer func() { observeQuery(ctx, s.query, start, err) }()
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+	return s.Stmt.Exec(namedValues(args)) // 驱动未实现 ExecContext 时回退
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	start := time.Now()
+	defer func() { observeQuery(ctx, s.query, start, err) }()
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+	return s.Stmt.Query(namedValues(args)) // 驱动未实现 QueryContext 时回退
+}
+
+// namedValues 转换为旧版驱动接口的参数
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	return values
+}
+
+// queryOperation 语句类型（select/insert/update/delete/other），用作指标标签
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch op := strings.ToLower(fields[0]); op {
+	case "select", "insert", "update", "delete":
+		return op
+	}
+	return "other"
+}
+
+// observeQuery 记录语句耗时；上下文中存在父 span 时补记一个数据库 span
+// （驱动可能以 ErrSkip 拒绝后改走预编译路径，因此在语句完成后按开始时间创建 span）
+func observeQuery(ctx context.Context, query string, start time.Time, err error) {
+	op := queryOperation(query)
+	dbQueryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	_, span := tracer.Start(ctx, "db."+op,
+		trace.WithTimestamp(start),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", dialect.system),
+			attribute.String("db.operation", op),
+			attribute.String("db.statement", query),
+		),
+	)
+	failSpan(span, err)
+	span.End()
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 数据库迁移：迁移脚本按驱动嵌入二进制（migrations/<driver>/NNNN_name.up.sql 与 .down.sql），
+// 已应用的版本记录在 schema_migrations 表中。启动时默认执行未应用的迁移，
+// 数据库版本高于二进制已知的最新版本时拒绝启动，避免旧版本程序写坏新结构。
+// MySQL 的 DDL 会隐式提交，迁移中途失败时需人工检查后重试。
+
+//go:embed migrations
+var migrationFS embed.FS
+
+// migration 一个版本的迁移脚本
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// appliedMigration 已应用的迁移
+type appliedMigration struct {
+	version   int
+	name      string
+	appliedAt time.Time
+}
+
+// loadMigrations 读取驱动的迁移脚本，按版本升序返回
+func loadMigrations(driver string) ([]*migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		versionStr, name, ok2 := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || !ok2 || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %s", e.Name())
+		}
+		data, err := fs.ReadFile(migrationFS, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		} else if m.name != name {
+			return nil, fmt.Errorf("migration %04d has conflicting names %s and %s", version, m.name, name)
+		}
+		if direction == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := make([]*migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// splitStatements 拆分迁移脚本：语句以行尾分号结束，忽略 -- 注释行
+func splitStatements(script string) []string {
+	var stmts []string
+	var b strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(b.String()), ";"))
+			b.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(b.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
+
+// ensureSchemaTable 创建迁移记录表
+func ensureSchemaTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT NOT NULL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
+
+// appliedMigrations 已应用的迁移，按版本升序
+func appliedMigrations(ctx context.Context) ([]appliedMigration, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.name, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// pendingMigrations 返回未应用的迁移；数据库包含未知的更新版本时返回错误
+func pendingMigrations(migrations []*migration, applied []appliedMigration) ([]*migration, error) {
+	done := map[int]bool{}
+	for _, a := range applied {
+		done[a.version] = true
+	}
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].version
+	}
+	if n := len(applied); n > 0 && applied[n-1].version > latest {
+		return nil, fmt.Errorf("database schema version %d is newer than the latest known version %d, upgrade the server", applied[n-1].version, latest)
+	}
+
+	var pending []*migration
+	for _, m := range migrations {
+		if !done[m.version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// runMigration 在事务中执行一个方向的脚本并更新迁移记录
+func runMigration(ctx context.Context, m *migration, up bool) error {
+	script, record := m.up, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)"
+	args := []interface{}{m.version, m.name}
+	if !up {
+		script, record = m.down, "DELETE FROM schema_migrations WHERE version = ?"
+		args = args[:1]
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %04d_%s: %v", m.version, m.name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateUp 依次执行全部未应用的迁移，返回执行数量
+func migrateUp(ctx context.Context, driver string) (int, error) {
+	migrations, applied, err := loadMigrationState(ctx, driver)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := pendingMigrations(migrations, applied)
+	if err != nil {
+		return 0, err
+	}
+	for i, m := range pending {
+		if err := runMigration(ctx, m, true); err != nil {
+			return i, err
+		}
+		slog.Info("Applied migration", "version", m.version, "name", m.name)
+	}
+	return len(pending), nil
+}
+
+// migrateDown 回滚最近一次应用的迁移，没有可回滚的迁移时返回 nil
+func migrateDown(ctx context.Context, driver string) (*migration, error) {
+	migrations, applied, err := loadMigrationState(ctx, driver)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pendingMigrations(migrations, applied); err != nil {
+		return nil, err
+	}
+	if len(applied) == 0 {
+		return nil, nil
+	}
+	last := applied[len(applied)-1].version
+	for _, m := range migrations {
+		if m.version == last {
+			if err := runMigration(ctx, m, false); err != nil {
+				return nil, err
+			}
+			slog.Info("Rolled back migration", "version", m.version, "name", m.name)
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("migration %04d not found", last)
+}
+
+// loadMigrationState 读取迁移脚本与已应用的迁移记录
+func loadMigrationState(ctx context.Context, driver string) ([]*migration, []appliedMigration, error) {
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ensureSchemaTable(ctx); err != nil {
+		return nil, nil, err
+	}
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return migrations, applied, nil
+}
+
+// migrateOnStartup 启动时检查数据库结构版本，auto_migrate 开启时执行未应用的迁移
+func migrateOnStartup(ctx context.Context, cfg DatabaseConfig) error {
+	if cfg.AutoMigrate {
+		_, err := migrateUp(ctx, cfg.Driver)
+		return err
+	}
+	migrations, applied, err := loadMigrationState(ctx, cfg.Driver)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(migrations, applied)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations, run \"go-upload migrate up\" or enable database.auto_migrate", len(pending))
+	}
+	return nil
+}
+
+// initCommand 为子命令加载配置、初始化日志并连接数据库，失败时返回非0退出码
+func initCommand(args []string) (*Config, int) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Configuration error:", err)
+		return nil, 2
+	}
+	initLogger(cfg.Log)
+	if err := initDB(cfg.Database); err != nil {
+		slog.Error("Database initialization failed", "err", err)
+		return nil, 1
+	}
+	return cfg, 0
+}
+
+// runMigrateCommand 执行 migrate 子命令，返回进程退出码
+// 用法：go-upload migrate <up|down|status> [配置参数]
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, "usage: go-upload migrate <up|down|status> [flags]")
+		return 2
+	}
+	action := args[0]
+	cfg, code := initCommand(args[1:])
+	if code != 0 {
+		return code
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch action {
+	case "up":
+		n, err := migrateUp(ctx, cfg.Database.Driver)
+		if err != nil {
+			slog.Error("Migrate up failed", "applied", n, "err", err)
+			return 1
+		}
+		fmt.Printf("Applied %d migrations\n", n)
+	case "down":
+		m, err := migrateDown(ctx, cfg.Database.Driver)
+		if err != nil {
+			slog.Error("Migrate down failed", "err", err)
+			return 1
+		}
+		if m == nil {
+			fmt.Println("No migrations to roll back")
+		} else {
+			fmt.Printf("Rolled back %04d_%s\n", m.version, m.name)
+		}
+	case "status":
+		if err := printMigrationStatus(ctx, cfg.Database.Driver); err != nil {
+			slog.Error("Migrate status failed", "err", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// printMigrationStatus 输出各版本的应用状态
+func printMigrationStatus(ctx context.Context, driver string) error {
+	migrations, applied, err := loadMigrationState(ctx, driver)
+	if err != nil {
+		return err
+	}
+	appliedAt := map[int]time.Time{}
+	for _, a := range applied {
+		appliedAt[a.version] = a.appliedAt
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+	known := map[int]bool{}
+	for _, m := range migrations {
+		known[m.version] = true
+		status := "pending"
+		if t, ok := appliedAt[m.version]; ok {
+			status = t.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", m.version, m.name, status)
+	}
+	for _, a := range applied {
+		if !known[a.version] {
+			fmt.Fprintf(tw, "%04d\t%s\t%s (unknown to this binary)\n", a.version, a.name, a.appliedAt.Format(time.RFC3339))
+		}
+	}
+	return tw.Flush()
+}
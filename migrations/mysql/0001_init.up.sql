@@ -0,0 +1,85 @@
+-- 初始表结构（与旧版 sql/filedb.sql 一致），已有的表保持不变
+
+CREATE TABLE IF NOT EXISTS `uploads` (
+  `upload_id` varchar(64) NOT NULL,
+  `file_name` varchar(255) NOT NULL,
+  `total_size` bigint NOT NULL,
+  `chunk_size` int NOT NULL,
+  `total_chunks` int NOT NULL,
+  `status` enum('in_progress','completed','failed') NULL DEFAULT 'in_progress',
+  `created_at` datetime NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  `extra` json NULL,
+  `user_id` varchar(64) NULL DEFAULT NULL,
+  `folder` varchar(255) NOT NULL DEFAULT '',
+  `scan_status` enum('scanning','clean','infected','error') NULL DEFAULT NULL,
+  `compression` varchar(16) NULL DEFAULT NULL,
+  `stored_size` bigint NULL DEFAULT NULL,
+  `key_id` varchar(64) NULL DEFAULT NULL,
+  `wrapped_key` varbinary(128) NULL DEFAULT NULL,
+  `client_encryption` json NULL,
+  PRIMARY KEY (`upload_id`),
+  INDEX `user_folder`(`user_id` ASC, `folder` ASC),
+  INDEX `key_id`(`key_id` ASC)
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE IF NOT EXISTS `upload_chunks` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `upload_id` varchar(64) NULL DEFAULT NULL,
+  `chunk_index` int NULL DEFAULT NULL,
+  `chunk_size` int NULL DEFAULT NULL,
+  `chunk_md5` varchar(64) NULL DEFAULT NULL,
+  `received_at` datetime NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `upload_id`(`upload_id` ASC, `chunk_index` ASC),
+  INDEX `upload_id_2`(`upload_id` ASC),
+  CONSTRAINT `upload_chunks_ibfk_1` FOREIGN KEY (`upload_id`) REFERENCES `uploads` (`upload_id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE IF NOT EXISTS `file_shares` (
+  `share_id` varchar(64) NOT NULL,
+  `user_id` varchar(64) NOT NULL,
+  `upload_id` varchar(64) NULL DEFAULT NULL,
+  `folder` varchar(255) NULL DEFAULT NULL,
+  `scope` enum('download','view') NOT NULL DEFAULT 'download',
+  `password_hash` varchar(128) NULL DEFAULT NULL,
+  `max_downloads` int NULL DEFAULT NULL,
+  `download_count` int NOT NULL DEFAULT 0,
+  `expires_at` datetime NOT NULL,
+  `revoked` tinyint(1) NOT NULL DEFAULT 0,
+  `created_at` datetime NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`share_id`),
+  INDEX `user_id`(`user_id` ASC),
+  CONSTRAINT `file_shares_ibfk_1` FOREIGN KEY (`upload_id`) REFERENCES `uploads` (`upload_id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE IF NOT EXISTS `webhooks` (
+  `webhook_id` varchar(64) NOT NULL,
+  `user_id` varchar(64) NOT NULL,
+  `url` varchar(1024) NOT NULL,
+  `secret` varchar(128) NOT NULL,
+  `events` varchar(255) NOT NULL,
+  `is_global` tinyint(1) NOT NULL DEFAULT 0,
+  `active` tinyint(1) NOT NULL DEFAULT 1,
+  `created_at` datetime NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`webhook_id`),
+  INDEX `user_id`(`user_id` ASC)
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE IF NOT EXISTS `webhook_deliveries` (
+  `delivery_id` varchar(64) NOT NULL,
+  `webhook_id` varchar(64) NOT NULL,
+  `event` varchar(64) NOT NULL,
+  `payload` mediumtext NOT NULL,
+  `status` enum('pending','delivered','failed') NOT NULL DEFAULT 'pending',
+  `attempts` int NOT NULL DEFAULT 0,
+  `last_status_code` int NULL DEFAULT NULL,
+  `last_error` varchar(512) NULL DEFAULT NULL,
+  `next_attempt_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `created_at` datetime NULL DEFAULT CURRENT_TIMESTAMP,
+  `delivered_at` datetime NULL DEFAULT NULL,
+  PRIMARY KEY (`delivery_id`),
+  INDEX `status_next_attempt`(`status` ASC, `next_attempt_at` ASC),
+  INDEX `webhook_id`(`webhook_id` ASC, `created_at` ASC),
+  CONSTRAINT `webhook_deliveries_ibfk_1` FOREIGN KEY (`webhook_id`) REFERENCES `webhooks` (`webhook_id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1,2 @@
+-- 回滚前需确保不存在枚举之外的状态
+ALTER TABLE `uploads` MODIFY `status` enum('in_progress','completed','failed') NULL DEFAULT 'in_progress';
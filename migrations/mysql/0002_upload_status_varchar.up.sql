@@ -0,0 +1,2 @@
+-- 上传状态改为字符串，新增状态无需修改表结构
+ALTER TABLE `uploads` MODIFY `status` varchar(16) NULL DEFAULT 'in_progress';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `cluster_nodes`;
+ALTER TABLE `uploads` DROP COLUMN `owner_node`;
@@ -0,0 +1,12 @@
+-- 集群模式：上传任务的所属节点与节点心跳
+
+ALTER TABLE `uploads` ADD COLUMN `owner_node` varchar(64) NULL DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS `cluster_nodes` (
+  `node_id` varchar(64) NOT NULL,
+  `advertise_url` varchar(255) NOT NULL,
+  `version` varchar(64) NOT NULL DEFAULT '',
+  `started_at` datetime NOT NULL,
+  `last_heartbeat` datetime NOT NULL,
+  PRIMARY KEY (`node_id`)
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1,17 @@
+-- 副本复制：文件内容的MD5与各副本目标的复制状态（同时作为复制队列）
+
+ALTER TABLE `uploads` ADD COLUMN `content_md5` varchar(32) NULL DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS `file_replicas` (
+  `upload_id` varchar(64) NOT NULL,
+  `target` varchar(255) NOT NULL,
+  `status` varchar(16) NOT NULL DEFAULT 'pending',
+  `attempts` int NOT NULL DEFAULT 0,
+  `last_error` varchar(512) NULL DEFAULT NULL,
+  `next_attempt_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `replicated_at` datetime NULL DEFAULT NULL,
+  `created_at` datetime NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`upload_id`, `target`),
+  INDEX `status_next_attempt`(`status` ASC, `next_attempt_at` ASC),
+  CONSTRAINT `file_replicas_ibfk_1` FOREIGN KEY (`upload_id`) REFERENCES `uploads` (`upload_id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1,4 @@
+DROP INDEX `status_verified_at` ON `uploads`;
+ALTER TABLE `uploads` DROP COLUMN `verified_at`;
+ALTER TABLE `uploads` DROP COLUMN `integrity_error`;
+ALTER TABLE `uploads` DROP COLUMN `integrity_status`;
@@ -0,0 +1,6 @@
+-- 完整性巡检：文件最近一次校验的时间与结果
+
+ALTER TABLE `uploads` ADD COLUMN `integrity_status` varchar(16) NULL DEFAULT NULL;
+ALTER TABLE `uploads` ADD COLUMN `integrity_error` varchar(512) NULL DEFAULT NULL;
+ALTER TABLE `uploads` ADD COLUMN `verified_at` datetime NULL DEFAULT NULL;
+CREATE INDEX `status_verified_at` ON `uploads` (`status` ASC, `verified_at` ASC);
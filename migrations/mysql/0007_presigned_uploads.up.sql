@@ -0,0 +1,3 @@
+-- 预签名上传：标记后分片与完成请求必须携带有效签名
+
+ALTER TABLE `uploads` ADD COLUMN `presigned` tinyint(1) NOT NULL DEFAULT 0;
@@ -0,0 +1,77 @@
+-- 初始表结构
+-- uploads.status 不限定取值，与 MySQL 在 0002 中去掉枚举后一致
+
+CREATE TABLE IF NOT EXISTS uploads (
+  upload_id varchar(64) NOT NULL PRIMARY KEY,
+  file_name varchar(255) NOT NULL,
+  total_size bigint NOT NULL,
+  chunk_size int NOT NULL,
+  total_chunks int NOT NULL,
+  status varchar(16) DEFAULT 'in_progress',
+  created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+  updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+  extra jsonb NULL,
+  user_id varchar(64) NULL,
+  folder varchar(255) NOT NULL DEFAULT '',
+  scan_status varchar(16) NULL CHECK (scan_status IN ('scanning', 'clean', 'infected', 'error')),
+  compression varchar(16) NULL,
+  stored_size bigint NULL,
+  key_id varchar(64) NULL,
+  wrapped_key bytea NULL,
+  client_encryption jsonb NULL
+);
+CREATE INDEX IF NOT EXISTS uploads_user_folder ON uploads (user_id, folder);
+CREATE INDEX IF NOT EXISTS uploads_key_id ON uploads (key_id);
+
+CREATE TABLE IF NOT EXISTS upload_chunks (
+  id bigserial PRIMARY KEY,
+  upload_id varchar(64) NULL REFERENCES uploads (upload_id) ON DELETE CASCADE,
+  chunk_index int NULL,
+  chunk_size int NULL,
+  chunk_md5 varchar(64) NULL,
+  received_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (upload_id, chunk_index)
+);
+
+CREATE TABLE IF NOT EXISTS file_shares (
+  share_id varchar(64) NOT NULL PRIMARY KEY,
+  user_id varchar(64) NOT NULL,
+  upload_id varchar(64) NULL REFERENCES uploads (upload_id) ON DELETE CASCADE,
+  folder varchar(255) NULL,
+  scope varchar(16) NOT NULL DEFAULT 'download' CHECK (scope IN ('download', 'view')),
+  password_hash varchar(128) NULL,
+  max_downloads int NULL,
+  download_count int NOT NULL DEFAULT 0,
+  expires_at timestamptz NOT NULL,
+  revoked boolean NOT NULL DEFAULT FALSE,
+  created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS file_shares_user_id ON file_shares (user_id);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+  webhook_id varchar(64) NOT NULL PRIMARY KEY,
+  user_id varchar(64) NOT NULL,
+  url varchar(1024) NOT NULL,
+  secret varchar(128) NOT NULL,
+  events varchar(255) NOT NULL,
+  is_global boolean NOT NULL DEFAULT FALSE,
+  active boolean NOT NULL DEFAULT TRUE,
+  created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webhooks_user_id ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  delivery_id varchar(64) NOT NULL PRIMARY KEY,
+  webhook_id varchar(64) NOT NULL REFERENCES webhooks (webhook_id) ON DELETE CASCADE,
+  event varchar(64) NOT NULL,
+  payload text NOT NULL,
+  status varchar(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+  attempts int NOT NULL DEFAULT 0,
+  last_status_code int NULL,
+  last_error varchar(512) NULL,
+  next_attempt_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+  delivered_at timestamptz NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_status_next_attempt ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, created_at);
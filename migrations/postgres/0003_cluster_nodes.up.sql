@@ -0,0 +1,11 @@
+-- 集群模式：上传任务的所属节点与节点心跳
+
+ALTER TABLE uploads ADD COLUMN owner_node varchar(64) NULL;
+
+CREATE TABLE IF NOT EXISTS cluster_nodes (
+  node_id varchar(64) NOT NULL PRIMARY KEY,
+  advertise_url varchar(255) NOT NULL,
+  version varchar(64) NOT NULL DEFAULT '',
+  started_at timestamptz NOT NULL,
+  last_heartbeat timestamptz NOT NULL
+);
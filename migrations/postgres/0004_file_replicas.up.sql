@@ -0,0 +1,16 @@
+-- 副本复制：文件内容的MD5与各副本目标的复制状态（同时作为复制队列）
+
+ALTER TABLE uploads ADD COLUMN content_md5 varchar(32) NULL;
+
+CREATE TABLE IF NOT EXISTS file_replicas (
+  upload_id varchar(64) NOT NULL REFERENCES uploads (upload_id) ON DELETE CASCADE,
+  target varchar(255) NOT NULL,
+  status varchar(16) NOT NULL DEFAULT 'pending',
+  attempts int NOT NULL DEFAULT 0,
+  last_error varchar(512) NULL,
+  next_attempt_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  replicated_at timestamptz NULL,
+  created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (upload_id, target)
+);
+CREATE INDEX IF NOT EXISTS file_replicas_status_next_attempt ON file_replicas (status, next_attempt_at);
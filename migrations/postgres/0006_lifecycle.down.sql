@@ -0,0 +1,3 @@
+ALTER TABLE uploads DROP COLUMN last_accessed_at;
+ALTER TABLE uploads DROP COLUMN tags;
+ALTER TABLE uploads DROP COLUMN tier;
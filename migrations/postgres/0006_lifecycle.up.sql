@@ -0,0 +1,5 @@
+-- 生命周期规则：文件所在的存储层级、标签与最近访问时间
+
+ALTER TABLE uploads ADD COLUMN tier varchar(32) NULL;
+ALTER TABLE uploads ADD COLUMN tags varchar(1024) NULL;
+ALTER TABLE uploads ADD COLUMN last_accessed_at timestamptz NULL;
@@ -0,0 +1,78 @@
+-- 初始表结构；时间列以 UTC 文本（YYYY-MM-DD HH:MM:SS）存储，file_name 使用 NOCASE，
+-- 排序与 MySQL 默认排序规则一样不区分大小写
+-- uploads.status 不限定取值，与 MySQL 在 0002 中去掉枚举后一致
+
+CREATE TABLE IF NOT EXISTS uploads (
+  upload_id TEXT NOT NULL PRIMARY KEY,
+  file_name TEXT NOT NULL COLLATE NOCASE,
+  total_size INTEGER NOT NULL,
+  chunk_size INTEGER NOT NULL,
+  total_chunks INTEGER NOT NULL,
+  status TEXT DEFAULT 'in_progress',
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  extra TEXT NULL,
+  user_id TEXT NULL,
+  folder TEXT NOT NULL DEFAULT '',
+  scan_status TEXT NULL CHECK (scan_status IN ('scanning', 'clean', 'infected', 'error')),
+  compression TEXT NULL,
+  stored_size INTEGER NULL,
+  key_id TEXT NULL,
+  wrapped_key BLOB NULL,
+  client_encryption TEXT NULL
+);
+CREATE INDEX IF NOT EXISTS uploads_user_folder ON uploads (user_id, folder);
+CREATE INDEX IF NOT EXISTS uploads_key_id ON uploads (key_id);
+
+CREATE TABLE IF NOT EXISTS upload_chunks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  upload_id TEXT NULL REFERENCES uploads (upload_id) ON DELETE CASCADE,
+  chunk_index INTEGER NULL,
+  chunk_size INTEGER NULL,
+  chunk_md5 TEXT NULL,
+  received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (upload_id, chunk_index)
+);
+
+CREATE TABLE IF NOT EXISTS file_shares (
+  share_id TEXT NOT NULL PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  upload_id TEXT NULL REFERENCES uploads (upload_id) ON DELETE CASCADE,
+  folder TEXT NULL,
+  scope TEXT NOT NULL DEFAULT 'download' CHECK (scope IN ('download', 'view')),
+  password_hash TEXT NULL,
+  max_downloads INTEGER NULL,
+  download_count INTEGER NOT NULL DEFAULT 0,
+  expires_at DATETIME NOT NULL,
+  revoked BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS file_shares_user_id ON file_shares (user_id);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+  webhook_id TEXT NOT NULL PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  events TEXT NOT NULL,
+  is_global BOOLEAN NOT NULL DEFAULT FALSE,
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webhooks_user_id ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  delivery_id TEXT NOT NULL PRIMARY KEY,
+  webhook_id TEXT NOT NULL REFERENCES webhooks (webhook_id) ON DELETE CASCADE,
+  event TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_status_code INTEGER NULL,
+  last_error TEXT NULL,
+  next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  delivered_at DATETIME NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_status_next_attempt ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, created_at);
@@ -0,0 +1 @@
+-- uploads.status 在初始结构中已是不限定取值的字符串，无需变更
@@ -0,0 +1,11 @@
+-- 集群模式：上传任务的所属节点与节点心跳（SQLite 仅支持单节点，保持表结构一致）
+
+ALTER TABLE uploads ADD COLUMN owner_node TEXT NULL;
+
+CREATE TABLE IF NOT EXISTS cluster_nodes (
+  node_id TEXT NOT NULL PRIMARY KEY,
+  advertise_url TEXT NOT NULL,
+  version TEXT NOT NULL DEFAULT '',
+  started_at DATETIME NOT NULL,
+  last_heartbeat DATETIME NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS file_replicas;
+ALTER TABLE uploads DROP COLUMN content_md5;
@@ -0,0 +1,16 @@
+-- 副本复制：文件内容的MD5与各副本目标的复制状态（同时作为复制队列）
+
+ALTER TABLE uploads ADD COLUMN content_md5 TEXT NULL;
+
+CREATE TABLE IF NOT EXISTS file_replicas (
+  upload_id TEXT NOT NULL REFERENCES uploads (upload_id) ON DELETE CASCADE,
+  target TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NULL,
+  next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  replicated_at DATETIME NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (upload_id, target)
+);
+CREATE INDEX IF NOT EXISTS file_replicas_status_next_attempt ON file_replicas (status, next_attempt_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS uploads_status_verified_at;
+ALTER TABLE uploads DROP COLUMN verified_at;
+ALTER TABLE uploads DROP COLUMN integrity_error;
+ALTER TABLE uploads DROP COLUMN integrity_status;
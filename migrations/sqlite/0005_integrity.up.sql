@@ -0,0 +1,6 @@
+-- 完整性巡检：文件最近一次校验的时间与结果
+
+ALTER TABLE uploads ADD COLUMN integrity_status TEXT NULL;
+ALTER TABLE uploads ADD COLUMN integrity_error TEXT NULL;
+ALTER TABLE uploads ADD COLUMN verified_at DATETIME NULL;
+CREATE INDEX IF NOT EXISTS uploads_status_verified_at ON uploads (status, verified_at);
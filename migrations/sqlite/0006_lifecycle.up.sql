@@ -0,0 +1,5 @@
+-- 生命周期规则：文件所在的存储层级、标签与最近访问时间
+
+ALTER TABLE uploads ADD COLUMN tier TEXT NULL;
+ALTER TABLE uploads ADD COLUMN tags TEXT NULL;
+ALTER TABLE uploads ADD COLUMN last_accessed_at DATETIME NULL;
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 预签名上传相关常量
+const (
+	DefaultPresignTTL = time.Hour      // 默认有效期
+	MaxPresignTTL     = 24 * time.Hour // 最长有效期
+	MaxPresignChunks  = 10000          // 单个预签名上传的最大分片数（每个分片签发一个链接）
+
+	presignChunkPurpose    = "upload-chunk"    // 分片上传签名用途
+	presignCompletePurpose = "upload-complete" // 完成上传签名用途
+)
+
+// PresignRequest 创建预签名上传请求
+type PresignRequest struct {
+	UploadRequest
+	ExpiresIn int64 `json:"expires_in,omitempty"` // 链接有效期（秒）
+}
+
+// PresignedURL 预签名链接
+type PresignedURL struct {
+	Index   *int   `json:"index,omitempty"`    // 分片索引（完成链接为空）
+	Method  string `json:"method"`             // HTTP方法
+	URL     string `json:"url"`                // 预签名URL
+	MaxSize int64  `json:"max_size,omitempty"` // 允许的最大分片大小
+}
+
+// PresignResponse 创建预签名上传响应
+type PresignResponse struct {
+	UploadResponse
+	ExpiresAt time.Time       `json:"expires_at"` // 链接过期时间
+	Chunks    []*PresignedURL `json:"chunks"`     // 各分片上传链接
+	Complete  *PresignedURL   `json:"complete"`   // 完成上传链接
+}
+
+// CreatePresignedUpload 创建上传任务并返回预签名的分片上传与完成链接
+// 持有链接的第三方无需登录即可上传该文件
+// POST /api/v1/uploads/presign
+func CreatePresignedUpload(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, "Login required")
+		return
+	}
+
+	var req PresignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := validateUploadRequest(&req.UploadRequest); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ttl := DefaultPresignTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if req.ExpiresIn < 0 || ttl > MaxPresignTTL {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("expires_in must be between 1 and %d seconds", int64(MaxPresignTTL/time.Second)))
+		return
+	}
+
+	totalChunks := (req.TotalSize + int64(req.ChunkSize) - 1) / int64(req.ChunkSize)
+	if totalChunks > MaxPresignChunks {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Too many chunks, at most %d for presigned uploads; increase chunk_size", MaxPresignChunks))
+		return
+	}
+
+	upload, err := insertUpload(dbCtx(r), &req.UploadRequest, userID, true)
+	if err != nil {
+		reqLogger(r).Error("Database insert upload error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create upload task")
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	base := requestBaseURL(r) + "/api/v1/uploads/" + upload.UploadID
+
+	resp := PresignResponse{
+		UploadResponse: *upload,
+		ExpiresAt:      expiresAt,
+		Chunks:         make([]*PresignedURL, 0, upload.TotalChunks),
+	}
+	for i := 0; i < upload.TotalChunks; i++ {
+		index := i
+		maxSize := chunkExpectedSize(req.TotalSize, req.ChunkSize, i)
+		size := strconv.FormatInt(maxSize, 10)
+		q := url.Values{}
+		q.Set("expires", exp)
+		q.Set("max_size", size)
+		q.Set("signature", signValue(presignChunkPurpose, upload.UploadID, strconv.Itoa(i), size, exp))
+		resp.Chunks = append(resp.Chunks, &PresignedURL{
+			Index:   &index,
+			Method:  http.MethodPut,
+			URL:     fmt.Sprintf("%s/chunks/%d?%s", base, i, q.Encode()),
+			MaxSize: maxSize,
+		})
+	}
+
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", signValue(presignCompletePurpose, upload.UploadID, exp))
+	resp.Complete = &PresignedURL{
+		Method: http.MethodPost,
+		URL:    base + "/complete?" + q.Encode(),
+	}
+
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// 辅助函数
+
+// chunkExpectedSize 计算指定分片的预期大小（最后一个分片可能较小）
+func chunkExpectedSize(totalSize int64, chunkSize int, index int) int64 {
+	remaining := totalSize - int64(index)*int64(chunkSize)
+	if remaining < int64(chunkSize) {
+		return remaining
+	}
+	return int64(chunkSize)
+}
+
+// isPresignedRequest 判断请求是否携带预签名参数
+// 预签名上传任务（FileRecord.Presigned）不携带签名的请求由调用方拒绝
+func isPresignedRequest(r *http.Request) bool {
+	return r.URL.Query().Get("signature") != ""
+}
+
+// verifyPresignedChunk 校验分片上传预签名，返回允许的最大分片大小
+func verifyPresignedChunk(r *http.Request, uploadID string, index int) (int64, error) {
+	q := r.URL.Query()
+	exp, size := q.Get("expires"), q.Get("max_size")
+	if !verifySignature(q.Get("signature"), presignChunkPurpose, uploadID, strconv.Itoa(index), size, exp) {
+		return 0, fmt.Errorf("Invalid signature")
+	}
+	if err := checkPresignExpiry(exp); err != nil {
+		return 0, err
+	}
+	maxSize, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || maxSize <= 0 {
+		return 0, fmt.Errorf("Invalid max_size")
+	}
+	return maxSize, nil
+}
+
+// verifyPresignedComplete 校验完成上传预签名
+func verifyPresignedComplete(r *http.Request, uploadID string) error {
+	q := r.URL.Query()
+	exp := q.Get("expires")
+	if !verifySignature(q.Get("signature"), presignCompletePurpose, uploadID, exp) {
+		return fmt.Errorf("Invalid signature")
+	}
+	return checkPresignExpiry(exp)
+}
+
+// checkPresignExpiry 检查预签名是否过期
+func checkPresignExpiry(exp string) error {
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid expires")
+	}
+	if time.Now().Unix() > expUnix {
+		return fmt.Errorf("Signature expired")
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// presignRouter 预签名上传相关路由
+func presignRouter() http.Handler {
+	r := mux.NewRouter()
+	uploads := r.PathPrefix("/api/v1/uploads").Subrouter()
+	uploads.HandleFunc("/presign", CreatePresignedUpload).Methods("POST")
+	uploads.HandleFunc("/{upload_id}/complete", CompleteUpload).Methods("POST")
+	uploads.HandleFunc("/{upload_id}/chunks/{index}", UploadChunk).Methods("PUT", "POST")
+	return r
+}
+
+// createTestPresign 以 alice 身份创建预签名上传
+func createTestPresign(t *testing.T, router http.Handler, totalSize int64, chunkSize int) *PresignResponse {
+	t.Helper()
+	body, _ := json.Marshal(PresignRequest{UploadRequest: UploadRequest{FileName: "p.bin", TotalSize: totalSize, ChunkSize: chunkSize}})
+	r := httptest.NewRequest("POST", "/api/v1/uploads/presign", bytes.NewReader(body))
+	r.Header.Set("X-User-ID", "alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create presign: %d %s", w.Code, w.Body.String())
+	}
+	var resp PresignResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp
+}
+
+// tamperSignature 改写签名的第一个字符
+func tamperSignature(sig string) string {
+	if sig[0] == 'A' {
+		return "B" + sig[1:]
+	}
+	return "A" + sig[1:]
+}
+
+// presignedRequest 按预签名链接发起请求，edit 可改写路径与查询参数
+func presignedRequest(t *testing.T, router http.Handler, method, rawURL string, body []byte, edit func(u *url.URL, q url.Values)) *httptest.ResponseRecorder {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if edit != nil {
+		edit(u, q)
+	}
+	u.RawQuery = q.Encode()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, u.RequestURI(), bytes.NewReader(body)))
+	return w
+}
+
+func TestPresignedUpload(t *testing.T) {
+	setupTestDB(t)
+	router := presignRouter()
+	data := bytes.Repeat([]byte("presigned "), 250) // 2500 字节，3 个分片
+	resp := createTestPresign(t, router, int64(len(data)), 1024)
+	if len(resp.Chunks) != 3 || resp.Chunks[2].MaxSize != 452 {
+		t.Fatalf("chunks = %d, last max_size = %d", len(resp.Chunks), resp.Chunks[len(resp.Chunks)-1].MaxSize)
+	}
+
+	for i, c := range resp.Chunks {
+		end := min((i+1)*1024, len(data))
+		if w := presignedRequest(t, router, c.Method, c.URL, data[i*1024:end], nil); w.Code >= 300 {
+			t.Fatalf("chunk %d: %d %s", i, w.Code, w.Body.String())
+		}
+	}
+	if w := presignedRequest(t, router, resp.Complete.Method, resp.Complete.URL, nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("complete: %d %s", w.Code, w.Body.String())
+	}
+	background.Wait()
+	file, err := uploadStore.GetUpload(context.Background(), resp.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Status != StatusCompleted || file.FileSize != int64(len(data)) {
+		t.Fatalf("upload status %s size %d", file.Status, file.FileSize)
+	}
+}
+
+func TestPresignedChunkRejected(t *testing.T) {
+	setupTestDB(t)
+	router := presignRouter()
+	resp := createTestPresign(t, router, 2500, 1024)
+	other := createTestPresign(t, router, 2500, 1024)
+	chunk0, last := resp.Chunks[0], resp.Chunks[2]
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name string
+		url  string
+		body []byte
+		edit func(u *url.URL, q url.Values)
+		want int
+	}{
+		{"no signature", chunk0.URL, make([]byte, 10), func(u *url.URL, q url.Values) {
+			q.Del("signature")
+			q.Del("expires")
+			q.Del("max_size")
+		}, http.StatusForbidden},
+		{"tampered signature", chunk0.URL, make([]byte, 10), func(u *url.URL, q url.Values) {
+			q.Set("signature", tamperSignature(q.Get("signature")))
+		}, http.StatusForbidden},
+		{"index swapped", chunk0.URL, make([]byte, 10), func(u *url.URL, q url.Values) {
+			u.Path = "/api/v1/uploads/" + resp.UploadID + "/chunks/1"
+		}, http.StatusForbidden},
+		{"other upload", chunk0.URL, make([]byte, 10), func(u *url.URL, q url.Values) {
+			u.Path = "/api/v1/uploads/" + other.UploadID + "/chunks/0"
+		}, http.StatusForbidden},
+		{"max_size raised", last.URL, make([]byte, 10), func(u *url.URL, q url.Values) {
+			q.Set("max_size", "1024")
+		}, http.StatusForbidden},
+		{"expiry extended", chunk0.URL, make([]byte, 10), func(u *url.URL, q url.Values) {
+			exp, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+			q.Set("expires", strconv.FormatInt(exp+3600, 10))
+		}, http.StatusForbidden},
+		{"expired", chunk0.URL, make([]byte, 10), func(u *url.URL, q url.Values) {
+			q.Set("expires", past)
+			q.Set("signature", signValue(presignChunkPurpose, resp.UploadID, "0", q.Get("max_size"), past))
+		}, http.StatusForbidden},
+		{"complete signature on chunk", chunk0.URL, make([]byte, 10), func(u *url.URL, q url.Values) {
+			q.Set("signature", signValue(presignCompletePurpose, resp.UploadID, q.Get("expires")))
+		}, http.StatusForbidden},
+		{"body over max_size", last.URL, make([]byte, 1024), nil, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := presignedRequest(t, router, http.MethodPut, tt.url, tt.body, tt.edit)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+
+	chunks, err := uploadStore.ListChunks(context.Background(), resp.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 0 {
+		t.Fatalf("rejected requests stored chunks %v", chunks)
+	}
+}
+
+func TestPresignedCompleteRejected(t *testing.T) {
+	setupTestDB(t)
+	router := presignRouter()
+	resp := createTestPresign(t, router, 10, 1024)
+	if w := presignedRequest(t, router, http.MethodPut, resp.Chunks[0].URL, make([]byte, 10), nil); w.Code >= 300 {
+		t.Fatalf("chunk: %d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name string
+		edit func(u *url.URL, q url.Values)
+	}{
+		{"no signature", func(u *url.URL, q url.Values) {
+			q.Del("signature")
+			q.Del("expires")
+		}},
+		{"tampered signature", func(u *url.URL, q url.Values) {
+			q.Set("signature", tamperSignature(q.Get("signature")))
+		}},
+		{"expiry extended", func(u *url.URL, q url.Values) {
+			exp, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+			q.Set("expires", strconv.FormatInt(exp+3600, 10))
+		}},
+		{"chunk signature", func(u *url.URL, q url.Values) {
+			q.Set("signature", signValue(presignChunkPurpose, resp.UploadID, "0", "10", q.Get("expires")))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := presignedRequest(t, router, http.MethodPost, resp.Complete.URL, nil, tt.edit)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d (%s), want 403", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	file, err := uploadStore.GetUpload(context.Background(), resp.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Status != StatusInProgress {
+		t.Fatalf("status = %s, want in_progress", file.Status)
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 处理状态
+const (
+	ProcessPending = "pending" // 等待处理
+	ProcessRunning = "running" // 处理中
+	ProcessDone    = "done"    // 处理完成
+	ProcessFailed  = "failed"  // 处理失败
+	ProcessSkipped = "skipped" // 不适用，已跳过
+
+	processQueueSize = 256              // 处理队列长度
+	processTimeout   = 10 * time.Minute // 单个处理器超时
+
+	processRecoverBatch = 500 // 启动时恢复处理任务每批扫描的文件数
+)
+
+// Processor 上传完成后的文件处理器
+// 处理结果会写入 uploads.extra 的 processing.<Name> 字段
+type Processor interface {
+	Name() string                                                      // 处理器名称
+	Applies(job *ProcessJob) bool                                      // 是否适用于该文件
+	Process(ctx context.Context, job *ProcessJob) (interface{}, error) // 执行处理并返回结果
+}
+
+// ProcessJob 单个文件的处理任务
+type ProcessJob struct {
+	File *FileRecord // 文件记录
+	Path string      // 文件在存储目录中的路径
+	Halt bool        // 处理器置为true后跳过后续处理器（如文件被隔离）
+
+	contentType string // 缓存的MIME类型
+}
+
+// ProcessorResult 处理器执行结果
+type ProcessorResult struct {
+	Status     string      `json:"status"`                // 状态
+	Result     interface{} `json:"result,omitempty"`      // 处理结果
+	Error      string      `json:"error,omitempty"`       // 错误信息
+	StartedAt  *time.Time  `json:"started_at,omitempty"`  // 开始时间
+	FinishedAt *time.Time  `json:"finished_at,omitempty"` // 结束时间
+}
+
+// processTask 队列中的处理任务
+type processTask struct {
+	uploadID string     // 上传任务ID
+	names    []string   // 要执行的处理器，为空表示全部
+	link     trace.Link // 触发处理的请求 span，处理链路与其关联
+}
+
+var (
+	processWorkers = 2 // 处理协程数
+
+	processors   []Processor                                // 已注册的处理器（按注册顺序执行）
+	processorsMu sync.RWMutex                               // 保护processors
+	processQueue = make(chan processTask, processQueueSize) // 处理队列
+)
+
+// registerProcessor 注册处理器
+func registerProcessor(p Processor) {
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+	processors = append(processors, p)
+}
+
+// getProcessor 按名称查找处理器
+func getProcessor(name string) Processor {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+	for _, p := range processors {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// selectProcessors 返回要执行的处理器，names为空时返回全部
+func selectProcessors(names []string) []Processor {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+	if len(names) == 0 {
+		return append([]Processor(nil), processors...)
+	}
+	var selected []Processor
+	for _, p := range processors {
+		for _, n := range names {
+			if p.Name() == n {
+				selected = append(selected, p)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// startProcessWorkers 注册内置处理器并启动处理协程
+func startProcessWorkers() error {
+	// 病毒扫描最先执行，感染文件被隔离后不再进行其他处理
+	if clamdAddress != "" {
+		client, err := newClamdClient(clamdAddress)
+		if err != nil {
+			return err
+		}
+		registerProcessor(clamavProcessor{client: client})
+	}
+	registerProcessor(mimeProcessor{})
+	registerProcessor(metadataProcessor{})
+	registerProcessor(thumbnailProcessor{})
+	registerProcessor(archiveProcessor{})
+
+	for i := 0; i < processWorkers; i++ {
+		goBackground(func() {
+			processWorkersAlive.Add(1)
+			defer processWorkersAlive.Add(-1)
+			for {
+				select {
+				case task := <-processQueue:
+					runProcessTask(task)
+				case <-backgroundCtx.Done():
+					return
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// abandonQueuedProcessing 停机时将队列中尚未执行的任务标记为失败，重启后由 recoverProcessing 重新入队
+func abandonQueuedProcessing() {
+	for {
+		select {
+		case task := <-processQueue:
+			abandonProcessTask(task)
+		default:
+			return
+		}
+	}
+}
+
+// abandonProcessTask 将未执行的任务涉及的处理器标记为因停机失败
+func abandonProcessTask(task processTask) {
+	for _, p := range selectProcessors(task.names) {
+		setProcessorResult(context.Background(), task.uploadID, p.Name(), &ProcessorResult{Status: ProcessFailed, Error: errShutdown.Error()})
+	}
+}
+
+// enqueueProcessing 将文件加入处理队列，并将对应处理器状态标记为 pending
+func enqueueProcessing(ctx context.Context, uploadID string, names ...string) {
+	if !markProcessingPending(ctx, uploadID, names) {
+		return
+	}
+
+	task := processTask{uploadID: uploadID, names: names, link: trace.LinkFromContext(ctx)}
+	select {
+	case processQueue <- task:
+	default:
+		// 队列已满时异步等待，避免阻塞请求；停机时仍未入队的任务标记为失败
+		goBackground(func() {
+			select {
+			case processQueue <- task:
+			case <-backgroundCtx.Done():
+				abandonProcessTask(task)
+			}
+		})
+	}
+}
+
+// markProcessingPending 将要执行的处理器状态标记为 pending，没有适用的处理器时返回false
+func markProcessingPending(ctx context.Context, uploadID string, names []string) bool {
+	selected := selectProcessors(names)
+	if len(selected) == 0 {
+		return false
+	}
+	err := updateUploadExtra(ctx, uploadID, func(extra map[string]interface{}) {
+		status := processingStatus(extra)
+		for _, p := range selected {
+			status[p.Name()] = &ProcessorResult{Status: ProcessPending}
+		}
+	})
+	if err != nil {
+		ctxLogger(ctx).Error("Mark processing pending error", "upload_id", uploadID, "err", err)
+	}
+	return true
+}
+
+// recoverProcessing 启动时重建处理队列
+// 队列只保存在内存中，进程崩溃或重启后按 uploads.extra 中的处理状态恢复：
+// 待处理、处理中以及因停机中断的处理器重新入队；病毒扫描未完成（scan_status 为 scanning）的文件
+// 即使 extra 中没有记录也重新扫描，否则会一直禁止下载。集群模式下只恢复本节点的文件
+func recoverProcessing() {
+	ctx := backgroundCtx
+	last := ""
+	recovered := 0
+	for {
+		rows, err := db.QueryContext(ctx, `
+			SELECT upload_id, COALESCE(owner_node, ''), COALESCE(scan_status, ''), extra
+			FROM uploads
+			WHERE status = ? AND upload_id > ? AND (extra IS NOT NULL OR scan_status = ?)
+			ORDER BY upload_id
+			LIMIT ?
+		`, StatusCompleted, last, ScanScanning, processRecoverBatch)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Query interrupted processing error", "err", err)
+			}
+			return
+		}
+		var tasks []processTask
+		n := 0
+		for rows.Next() {
+			var id, owner, scanStatus string
+			var raw []byte
+			if err := rows.Scan(&id, &owner, &scanStatus, &raw); err != nil {
+				slog.Error("Scan interrupted processing error", "err", err)
+				continue
+			}
+			n++
+			last = id
+			if clusterNodeID != "" && owner != clusterNodeID {
+				continue
+			}
+			names := interruptedProcessors(raw)
+			if scanStatus == ScanScanning && !containsString(names, clamavName) {
+				if getProcessor(clamavName) == nil {
+					slog.Warn("File is still marked as scanning but virus scanning is disabled", "upload_id", id)
+				} else {
+					names = append(names, clamavName)
+				}
+			}
+			if len(names) > 0 {
+				tasks = append(tasks, processTask{uploadID: id, names: names})
+			}
+		}
+		rows.Close()
+
+		for _, task := range tasks {
+			if !markProcessingPending(ctx, task.uploadID, task.names) {
+				continue
+			}
+			select {
+			case processQueue <- task:
+				recovered++
+			case <-ctx.Done():
+				abandonProcessTask(task)
+				return
+			}
+		}
+		if n < processRecoverBatch {
+			break
+		}
+	}
+	if recovered > 0 {
+		slog.Info("Recovered interrupted processing", "files", recovered)
+	}
+}
+
+// interruptedProcessors 从 extra 中找出需要重新执行的处理器
+func interruptedProcessors(raw []byte) []string {
+	if raw == nil {
+		return nil
+	}
+	var extra struct {
+		Processing map[string]*ProcessorResult `json:"processing"`
+	}
+	if err := json.Unmarshal(raw, &extra); err != nil {
+		return nil
+	}
+	var names []string
+	for name, res := range extra.Processing {
+		if res == nil {
+			continue
+		}
+		if res.Status == ProcessPending || res.Status == ProcessRunning ||
+			(res.Status == ProcessFailed && res.Error == errShutdown.Error()) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// runProcessTask 依次执行处理器并记录结果
+func runProcessTask(task processTask) {
+	// 后处理在请求结束后异步执行，单独作为根 span，并通过 link 关联触发它的请求
+	ctx, span := tracer.Start(backgroundCtx, "process_file",
+		trace.WithNewRoot(),
+		trace.WithLinks(task.link),
+		trace.WithAttributes(attribute.String("upload.id", task.uploadID)),
+	)
+	defer span.End()
+
+	file, err := getFileByUploadID(ctx, task.uploadID)
+	if err != nil {
+		slog.Error("Load file for processing error", "upload_id", task.uploadID, "err", err)
+		failSpan(span, err)
+		return
+	}
+	if file.Status != StatusCompleted {
+		return
+	}
+	job := &ProcessJob{File: file, Path: storedFilePath(file)}
+
+	for _, p := range selectProcessors(task.names) {
+		name := p.Name()
+		// 停机时剩余处理器标记为失败，可通过重新处理接口重试
+		if backgroundCtx.Err() != nil {
+			setProcessorResult(ctx, task.uploadID, name, &ProcessorResult{Status: ProcessFailed, Error: errShutdown.Error()})
+			continue
+		}
+		// 客户端加密文件只执行可处理密文的处理器
+		if job.Halt || (file.ClientEncryption != nil && !acceptsCiphertext(p)) || !p.Applies(job) {
+			setProcessorResult(ctx, task.uploadID, name, &ProcessorResult{Status: ProcessSkipped})
+			continue
+		}
+
+		started := time.Now().UTC()
+		setProcessorResult(ctx, task.uploadID, name, &ProcessorResult{Status: ProcessRunning, StartedAt: &started})
+
+		pctx, pspan := tracer.Start(ctx, "processor."+name)
+		pctx, cancel := context.WithTimeout(pctx, processTimeout)
+		result, err := p.Process(pctx, job)
+		cancel()
+
+		finished := time.Now().UTC()
+		res := &ProcessorResult{Status: ProcessDone, Result: result, StartedAt: &started, FinishedAt: &finished}
+		if err != nil {
+			if backgroundCtx.Err() != nil {
+				err = errShutdown
+			}
+			slog.Warn("Processor failed", "upload_id", task.uploadID, "processor", name, "err", err)
+			res.Status = ProcessFailed
+			res.Error = err.Error()
+			failSpan(pspan, err)
+		}
+		pspan.End()
+		setProcessorResult(ctx, task.uploadID, name, res)
+	}
+}
+
+// setProcessorResult 写入单个处理器结果
+func setProcessorResult(ctx context.Context, uploadID, name string, res *ProcessorResult) {
+	err := updateUploadExtra(ctx, uploadID, func(extra map[string]interface{}) {
+		processingStatus(extra)[name] = res
+	})
+	if err != nil {
+		slog.Error("Save processor result error", "upload_id", uploadID, "processor", name, "err", err)
+	}
+}
+
+// processingStatus 获取（必要时创建）extra 中的 processing 对象
+func processingStatus(extra map[string]interface{}) map[string]interface{} {
+	status, ok := extra["processing"].(map[string]interface{})
+	if !ok {
+		status = map[string]interface{}{}
+		extra["processing"] = status
+	}
+	return status
+}
+
+// loadProcessingStatus 读取文件的处理状态
+func loadProcessingStatus(ctx context.Context, uploadID string) (map[string]*ProcessorResult, error) {
+	raw, err := loadUploadExtraRaw(ctx, uploadID)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	var extra struct {
+		Processing map[string]*ProcessorResult `json:"processing"`
+	}
+	if err := json.Unmarshal(raw, &extra); err != nil {
+		return nil, err
+	}
+	return extra.Processing, nil
+}
+
+// loadUploadExtraRaw 读取 uploads.extra 原始JSON
+func loadUploadExtraRaw(ctx context.Context, uploadID string) ([]byte, error) {
+	var raw []byte
+	err := db.QueryRowContext(ctx, "SELECT extra FROM uploads WHERE upload_id = ?", uploadID).Scan(&raw)
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// updateUploadExtra 在事务中读取、修改并写回 uploads.extra
+func updateUploadExtra(ctx context.Context, uploadID string, fn func(extra map[string]interface{})) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var raw []byte
+	if err := tx.QueryRowContext(ctx, "SELECT extra FROM uploads WHERE upload_id = ?"+dialect.forUpdate, uploadID).Scan(&raw); err != nil {
+		return err
+	}
+	extra := map[string]interface{}{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &extra); err != nil {
+			return err
+		}
+	}
+
+	fn(extra)
+
+	data, err := json.Marshal(extra)
+	if err != nil {
+		return err
+	}
+	// 保持 updated_at 不变：已完成文件以其作为完成时间
+	if _, err := tx.ExecContext(ctx, "UPDATE uploads SET extra = ?, updated_at = updated_at WHERE upload_id = ?", string(data), uploadID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RerunProcessor 重新执行文件的处理器
+// POST /api/v1/files/{upload_id}/process
+// POST /api/v1/files/{upload_id}/process/{processor}
+func RerunProcessor(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadID := vars["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
+	ctx := dbCtx(r)
+
+	file, err := getFileByUploadID(ctx, uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		logger.Error("Database query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if file.UserID != "" && file.UserID != getUserID(r) && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Not the owner of this file")
+		return
+	}
+	if file.Status != StatusCompleted {
+		writeError(w, http.StatusConflict, "File is not completed")
+		return
+	}
+	// 感染文件已移入隔离区，重新处理只会把 infected 覆盖为 scanning/error 并丢失扫描结论
+	if file.ScanStatus == ScanInfected {
+		writeError(w, http.StatusConflict, "File is infected and has been quarantined")
+		return
+	}
+
+	var names []string
+	if name := vars["processor"]; name != "" {
+		if getProcessor(name) == nil {
+			writeError(w, http.StatusNotFound, "Unknown processor: "+name)
+			return
+		}
+		names = []string{name}
+	}
+
+	enqueueProcessing(ctx, uploadID, names...)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"upload_id":  uploadID,
+		"processors": processorNames(selectProcessors(names)),
+		"status":     ProcessPending,
+	})
+}
+
+// processorNames 返回处理器名称列表
+func processorNames(ps []Processor) []string {
+	names := make([]string, 0, len(ps))
+	for _, p := range ps {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// Open 打开文件内容，压缩、加密存储的文件透明解码
+func (job *ProcessJob) Open() (*storedFile, error) {
+	sp, err := fileStoreParams(job.File)
+	if err != nil {
+		return nil, err
+	}
+	return openStoredPath(job.Path, sp)
+}
+
+// ContentType 嗅探文件的MIME类型（结果会缓存）
+func (job *ProcessJob) ContentType() (string, error) {
+	if job.contentType != "" {
+		return job.contentType, nil
+	}
+	f, err := job.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	ct := http.DetectContentType(buf[:n])
+	// 嗅探结果过于笼统时参考扩展名
+	if ct == "application/octet-stream" || strings.HasPrefix(ct, "text/plain") {
+		if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(job.File.FileName))); byExt != "" {
+			ct = byExt
+		}
+	}
+	job.contentType = ct
+	return ct, nil
+}
+
+// 内置处理器
+
+// mimeProcessor MIME类型嗅探
+type mimeProcessor struct{}
+
+func (mimeProcessor) Name() string                 { return "mime" }
+func (mimeProcessor) Applies(job *ProcessJob) bool { return true }
+
+func (mimeProcessor) Process(ctx context.Context, job *ProcessJob) (interface{}, error) {
+	ct, err := job.ContentType()
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(ct)
+	return map[string]interface{}{
+		"content_type": ct,
+		"media_type":   mediaType,
+		"extension":    strings.ToLower(filepath.Ext(job.File.FileName)),
+	}, nil
+}
+
+// metadataProcessor 基础元数据提取：大小、修改时间与SHA-256
+type metadataProcessor struct{}
+
+func (metadataProcessor) Name() string                 { return "metadata" }
+func (metadataProcessor) Applies(job *ProcessJob) bool { return true }
+
+// AcceptsCiphertext 客户端加密文件同样记录密文的大小与SHA-256
+func (metadataProcessor) AcceptsCiphertext() bool { return true }
+
+func (metadataProcessor) Process(ctx context.Context, job *ProcessJob) (interface{}, error) {
+	f, err := job.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := os.Stat(job.Path)
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, contextReader{ctx, f}); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"size":        f.Size(),
+		"modified_at": info.ModTime().UTC(),
+		"sha256":      hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
+
+// contextReader 在上下文取消后中止读取
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// stubProcessor 测试用处理器
+type stubProcessor struct{ name string }
+
+func (p stubProcessor) Name() string               { return p.name }
+func (stubProcessor) Applies(job *ProcessJob) bool { return true }
+func (stubProcessor) Process(ctx context.Context, job *ProcessJob) (interface{}, error) {
+	return nil, nil
+}
+
+// useProcessors 替换已注册的处理器，测试结束后恢复
+func useProcessors(t *testing.T, names ...string) {
+	t.Helper()
+	processorsMu.Lock()
+	old := processors
+	processors = nil
+	for _, n := range names {
+		processors = append(processors, stubProcessor{name: n})
+	}
+	processorsMu.Unlock()
+	t.Cleanup(func() {
+		processorsMu.Lock()
+		processors = old
+		processorsMu.Unlock()
+	})
+}
+
+// drainProcessQueue 取出队列中的全部任务
+func drainProcessQueue() map[string][]string {
+	tasks := map[string][]string{}
+	for {
+		select {
+		case task := <-processQueue:
+			sort.Strings(task.names)
+			tasks[task.uploadID] = task.names
+		default:
+			return tasks
+		}
+	}
+}
+
+// setProcessing 直接写入 extra.processing
+func setProcessing(t *testing.T, uploadID string, status map[string]*ProcessorResult) {
+	t.Helper()
+	raw, _ := json.Marshal(map[string]interface{}{"processing": status})
+	if _, err := db.Exec("UPDATE uploads SET extra = ? WHERE upload_id = ?", string(raw), uploadID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverProcessing(t *testing.T) {
+	setupTestDB(t)
+	useProcessors(t, "mime", "metadata", "thumbnail")
+	drainProcessQueue()
+	t.Cleanup(func() { drainProcessQueue() })
+
+	ctx := context.Background()
+	for _, id := range []string{"u-pending", "u-running", "u-shutdown", "u-done", "u-failed", "u-progress"} {
+		f := newTestUpload(id, "alice", "", id+".txt", 10)
+		if err := uploadStore.CreateUpload(ctx, f, nil); err != nil {
+			t.Fatal(err)
+		}
+		if id != "u-progress" {
+			if err := uploadStore.CompleteUpload(ctx, id, "", 10, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	done := &ProcessorResult{Status: ProcessDone}
+	setProcessing(t, "u-pending", map[string]*ProcessorResult{"mime": {Status: ProcessPending}, "metadata": {Status: ProcessPending}, "thumbnail": done})
+	setProcessing(t, "u-running", map[string]*ProcessorResult{"mime": done, "metadata": {Status: ProcessRunning}, "thumbnail": {Status: ProcessPending}})
+	setProcessing(t, "u-shutdown", map[string]*ProcessorResult{"mime": done, "thumbnail": {Status: ProcessFailed, Error: errShutdown.Error()}})
+	setProcessing(t, "u-done", map[string]*ProcessorResult{"mime": done, "metadata": done, "thumbnail": {Status: ProcessSkipped}})
+	setProcessing(t, "u-failed", map[string]*ProcessorResult{"thumbnail": {Status: ProcessFailed, Error: "decode error"}})
+	setProcessing(t, "u-progress", map[string]*ProcessorResult{"mime": {Status: ProcessPending}}) // 未完成的上传不处理
+
+	recoverProcessing()
+
+	got := drainProcessQueue()
+	want := map[string][]string{
+		"u-pending":  {"metadata", "mime"},
+		"u-running":  {"metadata", "thumbnail"},
+		"u-shutdown": {"thumbnail"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("recovered tasks = %v, want %v", got, want)
+	}
+
+	// 恢复时重新标记为 pending
+	status, err := loadProcessingStatus(ctx, "u-shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status["thumbnail"].Status != ProcessPending || status["mime"].Status != ProcessDone {
+		t.Fatalf("u-shutdown status = thumbnail:%s mime:%s", status["thumbnail"].Status, status["mime"].Status)
+	}
+}
+
+func TestAbandonQueuedProcessing(t *testing.T) {
+	setupTestDB(t)
+	useProcessors(t, "mime", "thumbnail")
+	drainProcessQueue()
+
+	ctx := context.Background()
+	f := newTestUpload("u1", "alice", "", "a.txt", 10)
+	if err := uploadStore.CreateUpload(ctx, f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadStore.CompleteUpload(ctx, "u1", "", 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	enqueueProcessing(ctx, "u1")
+	abandonQueuedProcessing()
+
+	status, err := loadProcessingStatus(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"mime", "thumbnail"} {
+		if res := status[name]; res == nil || res.Status != ProcessFailed || res.Error != errShutdown.Error() {
+			t.Fatalf("%s result = %+v, want failed by shutdown", name, res)
+		}
+	}
+	if len(drainProcessQueue()) != 0 {
+		t.Fatal("queue not drained")
+	}
+}
+
+func TestRecoverProcessingScanning(t *testing.T) {
+	setupTestDB(t)
+	useProcessors(t, clamavName, "mime")
+	drainProcessQueue()
+	t.Cleanup(func() { drainProcessQueue() })
+
+	// 合并后、入队前进程退出：scan_status 为 scanning 但 extra 中没有处理记录
+	ctx := context.Background()
+	for _, id := range []string{"u-scanning", "u-clean"} {
+		if err := uploadStore.CreateUpload(ctx, newTestUpload(id, "alice", "", id+".txt", 10), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := uploadStore.CompleteUpload(ctx, "u-scanning", ScanScanning, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadStore.CompleteUpload(ctx, "u-clean", ScanClean, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	recoverProcessing()
+
+	got := drainProcessQueue()
+	want := map[string][]string{"u-scanning": {clamavName}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("recovered tasks = %v, want %v", got, want)
+	}
+	status, err := loadProcessingStatus(ctx, "u-scanning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := status[clamavName]; res == nil || res.Status != ProcessPending {
+		t.Fatalf("clamav status = %+v, want pending", res)
+	}
+}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 副本复制：文件完成后为每个副本目录写入一条 file_replicas 记录，复制协程轮询到期的记录，
+// 将存储文件（保持压缩、加密后的格式）复制到副本目录并校验内容MD5。读取文件时主副本缺失、
+// 大小与记录不符或无法解码时自动改读副本，并在后台从副本恢复主副本。
+// 修复任务定期检查：补齐缺失的副本记录、重新复制失败或丢失的副本、恢复损坏的主副本。
+// 集群模式下各节点只处理自己所属的文件。
+
+// 副本状态与重试策略
+const (
+	ReplicaPending = "pending" // 待复制
+	ReplicaSynced  = "synced"  // 已复制并校验
+	ReplicaFailed  = "failed"  // 重试耗尽，等待修复任务重试
+	ReplicaSkipped = "skipped" // 文件被隔离或扫描失败，不复制
+
+	replicationPollInterval = 5 * time.Second  // 队列轮询间隔
+	replicationBatchSize    = 20               // 每轮最多处理的记录数
+	replicationLease        = 30 * time.Minute // 领取复制任务后的租约时长（大文件复制耗时较长）
+	replicaBaseBackoff      = 30 * time.Second // 首次重试间隔，之后指数增长
+	replicaMaxBackoff       = 6 * time.Hour    // 最大重试间隔
+	replicaScanWait         = time.Minute      // 文件扫描中时推迟复制的时间
+	repairBatchSize         = 500              // 修复任务每批检查的文件数
+)
+
+var (
+	replicaTargets        []string                 // 副本目录，为空时不复制
+	replicaMaxAttempts    = 10                     // 单个副本最大尝试次数
+	replicaRepairInterval = time.Hour              // 修复任务间隔，0表示只在手动触发时运行
+	replicationWake       = make(chan struct{}, 1) // 唤醒复制协程
+	repairWake            = make(chan struct{}, 1) // 手动触发修复任务
+	restoring             sync.Map                 // 正在从副本恢复主副本的上传ID
+
+	errChecksumMismatch  = errors.New("content MD5 mismatch")
+	errStoredSizeChanged = errors.New("stored file size does not match the record")
+
+	replicationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "replications_total",
+		Help: "Replica copy attempts, by result.",
+	}, []string{"result"})
+	replicaFailovers = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "replica_failovers_total",
+		Help: "Reads served from a replica because the primary copy was missing or damaged.",
+	})
+)
+
+// FileReplica 文件副本状态
+type FileReplica struct {
+	Target       string     `json:"target"`                  // 副本目录
+	Status       string     `json:"status"`                  // pending / synced / failed / skipped
+	Attempts     int        `json:"attempts"`                // 已尝试次数
+	LastError    string     `json:"last_error,omitempty"`    // 最近一次失败原因
+	ReplicatedAt *time.Time `json:"replicated_at,omitempty"` // 复制完成时间
+}
+
+// replicaPath 文件在副本目录中的路径，与存储目录中的文件名相同
+func replicaPath(target string, file *FileRecord) string {
+	return filepath.Join(target, filepath.Base(finalFilePath(file.UploadID, file.FileName)))
+}
+
+// startReplication 配置了副本目录时启动复制与修复协程
+func startReplication() {
+	if len(replicaTargets) == 0 {
+		return
+	}
+	slog.Info("Replication enabled", "targets", replicaTargets, "repair_interval", replicaRepairInterval)
+	goBackground(runReplicationWorker)
+	goBackground(runReplicaRepair)
+}
+
+// wakeReplication 唤醒复制协程
+func wakeReplication() {
+	select {
+	case replicationWake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueReplication 为已完成的文件创建各副本目录的复制记录
+func enqueueReplication(ctx context.Context, uploadID string) {
+	if len(replicaTargets) == 0 {
+		return
+	}
+	for _, target := range replicaTargets {
+		if _, err := db.ExecContext(ctx, "INSERT INTO file_replicas (upload_id, target, status) VALUES (?, ?, ?)",
+			uploadID, target, ReplicaPending); err != nil {
+			ctxLogger(ctx).Error("Enqueue replication error", "upload_id", uploadID, "target", target, "err", err)
+		}
+	}
+	wakeReplication()
+}
+
+// loadReplicas 文件的副本状态，按目标排序
+func loadReplicas(ctx context.Context, uploadID string) ([]*FileReplica, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT target, status, attempts, COALESCE(last_error, ''), replicated_at
+		FROM file_replicas
+		WHERE upload_id = ?
+		ORDER BY target
+	`, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replicas []*FileReplica
+	for rows.Next() {
+		r := &FileReplica{}
+		var replicatedAt sql.NullTime
+		if err := rows.Scan(&r.Target, &r.Status, &r.Attempts, &r.LastError, &replicatedAt); err != nil {
+			return nil, err
+		}
+		if replicatedAt.Valid {
+			r.ReplicatedAt = &replicatedAt.Time
+		}
+		replicas = append(replicas, r)
+	}
+	return replicas, rows.Err()
+}
+
+// removeReplicas 删除文件在各副本目录中的副本
+func removeReplicas(file *FileRecord, logger *slog.Logger) {
+	for _, target := range replicaTargets {
+		path := replicaPath(target, file)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Error("Remove replica error", "path", path, "err", err)
+		}
+	}
+}
+
+// replicaTargetFilter 限定当前配置的副本目录与本节点所属文件的查询条件
+func replicaTargetFilter(alias string) (string, []interface{}) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(replicaTargets)), ", ")
+	clause := " AND " + alias + ".target IN (" + placeholders + ")"
+	args := make([]interface{}, 0, len(replicaTargets)+1)
+	for _, t := range replicaTargets {
+		args = append(args, t)
+	}
+	if clusterNodeID != "" {
+		clause += " AND u.owner_node = ?"
+		args = append(args, clusterNodeID)
+	}
+	return clause, args
+}
+
+// runReplicationWorker 复制协程：轮询到期的复制记录
+func runReplicationWorker() {
+	ticker := time.NewTicker(replicationPollInterval)
+	defer ticker.Stop()
+	for {
+		processReplicationQueue()
+		select {
+		case <-ticker.C:
+		case <-replicationWake:
+		case <-backgroundCtx.Done():
+			return
+		}
+	}
+}
+
+// replicationJob 待复制的记录
+type replicationJob struct {
+	uploadID, target string
+	attempts         int
+}
+
+// processReplicationQueue 处理一批到期的复制记录
+func processReplicationQueue() {
+	ctx := context.Background()
+	filter, args := replicaTargetFilter("r")
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.upload_id, r.target, r.attempts
+		FROM file_replicas r
+		JOIN uploads u ON u.upload_id = r.upload_id
+		WHERE r.status = ? AND r.next_attempt_at <= CURRENT_TIMESTAMP`+filter+`
+		ORDER BY r.next_attempt_at
+		LIMIT ?
+	`, append(append([]interface{}{ReplicaPending}, args...), replicationBatchSize)...)
+	if err != nil {
+		slog.Error("Query replication queue error", "err", err)
+		return
+	}
+
+	var jobs []replicationJob
+	for rows.Next() {
+		var j replicationJob
+		if err := rows.Scan(&j.uploadID, &j.target, &j.attempts); err != nil {
+			slog.Error("Scan replication queue error", "err", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		if backgroundCtx.Err() != nil {
+			return
+		}
+		// 领取任务：推迟下次尝试时间作为租约，防止多实例重复复制
+		res, err := db.ExecContext(ctx, `
+			UPDATE file_replicas SET next_attempt_at = ?
+			WHERE upload_id = ? AND target = ? AND status = ? AND next_attempt_at <= CURRENT_TIMESTAMP
+		`, time.Now().Add(replicationLease), j.uploadID, j.target, ReplicaPending)
+		if err != nil {
+			slog.Error("Claim replication error", "upload_id", j.uploadID, "target", j.target, "err", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		replicateFile(j)
+	}
+}
+
+// replicateFile 复制一条已领取的记录并写回结果
+func replicateFile(j replicationJob) {
+	logger := slog.With("upload_id", j.uploadID, "target", j.target)
+	ctx, span := tracer.Start(withLogger(backgroundCtx, logger), "replicate_file", trace.WithAttributes(
+		attribute.String("upload.id", j.uploadID),
+		attribute.String("replica.target", j.target),
+	))
+	defer span.End()
+
+	file, err := uploadStore.GetUpload(ctx, j.uploadID)
+	if err == sql.ErrNoRows {
+		return // 文件已删除，记录随之级联删除
+	}
+	if err == nil {
+		switch file.ScanStatus {
+		case ScanScanning:
+			// 扫描完成前不复制，也不计入尝试次数
+			setReplicaState(ctx, j, ReplicaPending, j.attempts, "waiting for virus scan", time.Now().Add(replicaScanWait))
+			return
+		case ScanInfected, ScanError:
+			setReplicaState(ctx, j, ReplicaSkipped, j.attempts, "file is quarantined or failed scanning", time.Now())
+			return
+		}
+		var sp storeParams
+		if sp, err = fileStoreParams(file); err == nil {
+			err = copyStoredFile(ctx, storedFilePath(file), replicaPath(j.target, file), sp, file.ContentMD5)
+		}
+	}
+
+	attempts := j.attempts + 1
+	span.SetAttributes(attribute.Int("replica.attempt", attempts))
+	if err == nil {
+		replicationsTotal.WithLabelValues("ok").Inc()
+		_, err = db.ExecContext(ctx, `
+			UPDATE file_replicas
+			SET status = ?, attempts = ?, last_error = NULL, replicated_at = CURRENT_TIMESTAMP
+			WHERE upload_id = ? AND target = ?
+		`, ReplicaSynced, attempts, j.uploadID, j.target)
+		if err != nil {
+			logger.Error("Update replica error", "err", err)
+		}
+		logger.Info("File replicated")
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return // 停机中断，租约到期后重试
+	}
+	failSpan(span, err)
+	replicationsTotal.WithLabelValues("error").Inc()
+
+	status := ReplicaPending
+	if attempts >= replicaMaxAttempts {
+		status = ReplicaFailed
+	}
+	logger.Warn("Replication failed", "attempt", attempts, "err", err)
+	setReplicaState(ctx, j, status, attempts, truncateString(err.Error(), 500), time.Now().Add(replicaBackoff(attempts)))
+}
+
+// setReplicaState 更新复制记录的状态与下次尝试时间
+func setReplicaState(ctx context.Context, j replicationJob, status string, attempts int, lastError string, next time.Time) {
+	_, err := db.ExecContext(ctx, `
+		UPDATE file_replicas SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?
+		WHERE upload_id = ? AND target = ?
+	`, status, attempts, lastError, next, j.uploadID, j.target)
+	if err != nil {
+		ctxLogger(ctx).Error("Update replica error", "err", err)
+	}
+}
+
+// replicaBackoff 第 attempts 次失败后的重试间隔
+func replicaBackoff(attempts int) time.Duration {
+	d := replicaBaseBackoff
+	for i := 1; i < attempts && d < replicaMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > replicaMaxBackoff {
+		d = replicaMaxBackoff
+	}
+	return d
+}
+
+// copyStoredFile 复制存储文件：写入同目录临时文件并同步到磁盘，
+// wantMD5 不为空时解码校验内容MD5，通过后原子重命名为目标路径
+func copyStoredFile(ctx context.Context, src, dst string, sp storeParams, wantMD5 string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.part")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, contextReader{ctx: ctx, r: in})
+	if err == nil {
+		err = tmp.Chmod(0644) // 与存储目录中的文件权限一致
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && wantMD5 != "" {
+		err = verifyStoredMD5(ctx, tmp.Name(), sp, wantMD5, nil)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// verifyStoredMD5 解码存储文件并校验内容MD5，limit 不为空时经其读取以限制速率
+func verifyStoredMD5(ctx context.Context, path string, sp storeParams, want string, limit func(io.Reader) io.Reader) error {
+	f, err := openStoredPath(path, sp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = contextReader{ctx: ctx, r: f}
+	if limit != nil {
+		r = limit(r)
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%w: got %s, want %s", errChecksumMismatch, got, want)
+	}
+	return nil
+}
+
+// checkStoredSize 检查已打开的存储文件的物理大小与记录是否一致
+func checkStoredSize(sf *storedFile, file *FileRecord) error {
+	if file.StoredSize <= 0 {
+		return nil
+	}
+	info, err := sf.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != file.StoredSize {
+		return errStoredSizeChanged
+	}
+	return nil
+}
+
+// openReplica 主副本不可用时打开第一个完好的副本，并在后台恢复主副本；
+// 没有可用副本时返回主副本的错误
+func openReplica(file *FileRecord, sp storeParams, primaryErr error) (*storedFile, error) {
+	for _, target := range replicaTargets {
+		sf, err := openStoredPath(replicaPath(target, file), sp)
+		if err != nil {
+			continue
+		}
+		if checkStoredSize(sf, file) != nil {
+			sf.Close()
+			continue
+		}
+		replicaFailovers.Inc()
+		slog.Warn("Primary copy unavailable, serving replica", "upload_id", file.UploadID, "target", target, "err", primaryErr)
+		scheduleRestore(file)
+		return sf, nil
+	}
+	return nil, primaryErr
+}
+
+// scheduleRestore 在后台从副本恢复主副本，同一文件同时只恢复一次
+func scheduleRestore(file *FileRecord) {
+	if _, loaded := restoring.LoadOrStore(file.UploadID, true); loaded {
+		return
+	}
+	goBackground(func() {
+		defer restoring.Delete(file.UploadID)
+		logger := slog.With("upload_id", file.UploadID)
+		if _, err := restorePrimary(withLogger(backgroundCtx, logger), file); err != nil {
+			logger.Error("Restore primary copy error", "err", err)
+		}
+	})
+}
+
+// restorePrimary 持有上传任务锁，主副本仍丢失或大小不符时从第一个可用的副本恢复；
+// 返回是否实际恢复了主副本
+func restorePrimary(ctx context.Context, file *FileRecord) (bool, error) {
+	_, unlock, err := lockUpload(ctx, file.UploadID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	// 加锁期间文件可能已被删除或移动到其他存储层级，此时主副本已完好，不能覆盖
+	file, err = uploadStore.GetUpload(ctx, file.UploadID)
+	if err != nil {
+		return false, err
+	}
+	if checkPrimary(file) == nil {
+		ctxLogger(ctx).Info("Primary copy intact, restore skipped")
+		return false, nil
+	}
+	if err := restorePrimaryLocked(ctx, file); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// restorePrimaryLocked 从第一个可用的副本恢复主副本，调用方需持有上传任务锁并已重新读取记录
+func restorePrimaryLocked(ctx context.Context, file *FileRecord) error {
+	sp, err := fileStoreParams(file)
+	if err != nil {
+		return err
+	}
+	err = errors.New("no usable replica")
+	for _, target := range replicaTargets {
+		src := replicaPath(target, file)
+		if err = copyStoredFile(ctx, src, storedFilePath(file), sp, file.ContentMD5); err == nil {
+			ctxLogger(ctx).Info("Primary copy restored from replica", "target", target)
+			markRepaired(ctx, file.UploadID)
+			return nil
+		}
+		ctxLogger(ctx).Warn("Restore from replica failed", "target", target, "err", err)
+	}
+	return err
+}
+
+// checkPrimary 检查主副本存在且大小与记录一致
+func checkPrimary(file *FileRecord) error {
+	info, err := os.Stat(storedFilePath(file))
+	if err != nil {
+		return err
+	}
+	if file.StoredSize > 0 && info.Size() != file.StoredSize {
+		return errStoredSizeChanged
+	}
+	return nil
+}
+
+// runReplicaRepair 修复协程：按间隔或手动触发运行修复任务
+func runReplicaRepair() {
+	var tick <-chan time.Time
+	if replicaRepairInterval > 0 {
+		ticker := time.NewTicker(replicaRepairInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-repairWake:
+		case <-backgroundCtx.Done():
+			return
+		}
+		if _, err := repairReplicas(backgroundCtx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("Replica repair error", "err", err)
+		}
+	}
+}
+
+// RepairStats 修复任务结果
+type RepairStats struct {
+	Checked  int `json:"checked"`  // 检查的文件数
+	Enqueued int `json:"enqueued"` // 补齐的副本记录数
+	Requeued int `json:"requeued"` // 重新复制的副本数（失败、丢失或大小不符）
+	Restored int `json:"restored"` // 从副本恢复的主副本数
+	Removed  int `json:"removed"`  // 删除的已不再配置的目标记录数
+}
+
+// repairReplicas 检查本节点所属的全部已完成文件并修复副本
+func repairReplicas(ctx context.Context) (*RepairStats, error) {
+	stats := &RepairStats{}
+	start := time.Now()
+
+	// 删除不再配置的副本目录的记录
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(replicaTargets)), ", ")
+	targetArgs := make([]interface{}, len(replicaTargets))
+	for i, t := range replicaTargets {
+		targetArgs[i] = t
+	}
+	n, err := rowsAffected(db.ExecContext(ctx, "DELETE FROM file_replicas WHERE target NOT IN ("+placeholders+")", targetArgs...))
+	if err != nil {
+		return stats, err
+	}
+	stats.Removed = int(n)
+
+	after := ""
+	for {
+		files, err := listCompletedBatch(ctx, after, repairBatchSize)
+		if err != nil {
+			return stats, err
+		}
+		if len(files) == 0 {
+			break
+		}
+		after = files[len(files)-1].UploadID
+		if err := repairBatch(ctx, files, stats); err != nil {
+			return stats, err
+		}
+	}
+
+	if stats.Enqueued+stats.Requeued > 0 {
+		wakeReplication()
+	}
+	slog.Info("Replica repair finished", "checked", stats.Checked, "enqueued", stats.Enqueued,
+		"requeued", stats.Requeued, "restored", stats.Restored, "removed", stats.Removed, "duration", time.Since(start))
+	return stats, nil
+}
+
+// listCompletedBatch 按上传ID顺序列出本节点所属的下一批已完成文件
+func listCompletedBatch(ctx context.Context, after string, limit int) ([]*FileRecord, error) {
+	query := "SELECT " + fileColumns + " FROM uploads WHERE status = ? AND upload_id > ?"
+	args := []interface{}{StatusCompleted, after}
+	if clusterNodeID != "" {
+		query += " AND owner_node = ?"
+		args = append(args, clusterNodeID)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY upload_id LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*FileRecord
+	for rows.Next() {
+		file, err := scanFileRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// repairBatch 修复一批文件：恢复主副本、补齐记录、重新复制失败或丢失的副本
+func repairBatch(ctx context.Context, files []*FileRecord, stats *RepairStats) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(files)), ", ")
+	args := make([]interface{}, len(files))
+	for i, f := range files {
+		args[i] = f.UploadID
+	}
+	rows, err := db.QueryContext(ctx, "SELECT upload_id, target, status FROM file_replicas WHERE upload_id IN ("+placeholders+")", args...)
+	if err != nil {
+		return err
+	}
+	status := map[string]string{} // upload_id + "\x00" + target -> status
+	for rows.Next() {
+		var id, target, s string
+		if err := rows.Scan(&id, &target, &s); err != nil {
+			rows.Close()
+			return err
+		}
+		status[id+"\x00"+target] = s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// 扫描中、已隔离或扫描失败的文件不在存储目录中，不修复
+		if file.ScanStatus == ScanScanning || file.ScanStatus == ScanInfected || file.ScanStatus == ScanError {
+			continue
+		}
+		stats.Checked++
+		logger := slog.With("upload_id", file.UploadID)
+
+		if err := checkPrimary(file); err != nil {
+			logger.Warn("Primary copy damaged, restoring from replica", "err", err)
+			if restored, err := restorePrimary(withLogger(ctx, logger), file); err != nil {
+				logger.Error("Restore primary copy error", "err", err)
+			} else if restored {
+				stats.Restored++
+			}
+		}
+
+		for _, target := range replicaTargets {
+			var err error
+			switch status[file.UploadID+"\x00"+target] {
+			case "":
+				_, err = db.ExecContext(ctx, "INSERT INTO file_replicas (upload_id, target, status) VALUES (?, ?, ?)",
+					file.UploadID, target, ReplicaPending)
+				stats.Enqueued++
+			case ReplicaSynced:
+				info, serr := os.Stat(replicaPath(target, file))
+				if serr == nil && (file.StoredSize <= 0 || info.Size() == file.StoredSize) {
+					continue
+				}
+				logger.Warn("Replica missing or damaged, replicating again", "target", target)
+				err = requeueReplica(ctx, file.UploadID, target)
+				stats.Requeued++
+			case ReplicaFailed:
+				err = requeueReplica(ctx, file.UploadID, target)
+				stats.Requeued++
+			}
+			if err != nil {
+				logger.Error("Repair replica record error", "target", target, "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+// requeueReplica 将副本重新放入复制队列，尝试次数清零
+func requeueReplica(ctx context.Context, uploadID, target string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE file_replicas SET status = ?, attempts = 0, last_error = NULL, next_attempt_at = CURRENT_TIMESTAMP
+		WHERE upload_id = ? AND target = ?
+	`, ReplicaPending, uploadID, target)
+	return err
+}
+
+// RepairReplicas 手动触发副本修复任务（异步执行）
+// POST /api/v1/admin/replication/repair
+func RepairReplicas(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+	if len(replicaTargets) == 0 {
+		writeError(w, http.StatusNotFound, "Replication is not configured")
+		return
+	}
+	select {
+	case repairWake <- struct{}{}:
+	default: // 已有待运行的修复任务
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"message": "Replica repair scheduled",
+	})
+}
+
+// GetReplicationStatus 各副本目录按状态统计的副本数
+// GET /api/v1/admin/replication
+func GetReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Admin only")
+		return
+	}
+	rows, err := db.QueryContext(dbCtx(r), "SELECT target, status, COUNT(*) FROM file_replicas GROUP BY target, status")
+	if err != nil {
+		reqLogger(r).Error("Query replication status error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	counts := map[string]map[string]int64{}
+	for _, t := range replicaTargets {
+		counts[t] = map[string]int64{}
+	}
+	for rows.Next() {
+		var target, status string
+		var n int64
+		if err := rows.Scan(&target, &status, &n); err != nil {
+			reqLogger(r).Error("Scan replication status error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if counts[target] == nil {
+			counts[target] = map[string]int64{}
+		}
+		counts[target][status] = n
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"targets":      replicaTargets,
+		"data":         counts,
+		"max_attempts": replicaMaxAttempts,
+	})
+}
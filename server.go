@@ -2,25 +2,32 @@
 package main
 
 import (
+	"context"         // 上下文（停机取消）
 	"crypto/md5"      // MD5哈希计算
+	"crypto/rand"     // 随机数生成
 	"database/sql"     // 数据库操作
 	"encoding/hex"     // 十六进制编码
 	"encoding/json"    // JSON编解码
+	"errors"           // 错误处理
 	"fmt"              // 格式化IO
 	"io"               // IO操作
-	"log"              // 日志
+	"log/slog"         // 结构化日志
+	"mime"             // MIME类型处理
+	"net"              // 网络监听
 	"net/http"         // HTTP服务
 	"os"               // 操作系统功能
 	"path/filepath"    // 文件路径处理
 	"strconv"          // 字符串转换
 	"strings"          // 字符串处理
-	"sync"             // 同步原语
 	"time"             // 时间处理
 
-	_ "github.com/go-sql-driver/mysql" // MySQL驱动
-	"github.com/google/uuid"           // UUID生成
-	"github.com/gorilla/mux"           // HTTP路由
-	"github.com/rs/cors"               // CORS处理
+	"github.com/google/uuid"                                                     // UUID生成
+	"github.com/gorilla/mux"                                                     // HTTP路由
+	"github.com/prometheus/client_golang/prometheus/promhttp"                    // Prometheus指标输出
+	"github.com/rs/cors"                                                         // CORS处理
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux" // 路由链路追踪
+	"go.opentelemetry.io/otel/attribute"                                         // span属性
+	"go.opentelemetry.io/otel/trace"                                             // 链路追踪
 )
 
 // 全局变量
@@ -28,8 +35,12 @@ var (
 	db            *sql.DB           // 数据库连接
 	tmpDir        = "./tmp_uploads" // 临时上传目录
 	finalDir      = "./store"       // 最终文件存储目录
-	uploadLocks   = make(map[string]*sync.Mutex) // 上传任务锁映射
-	uploadLocksMu sync.Mutex         // 保护uploadLocks的互斥锁
+	quarantineDir = "./quarantine"  // 感染文件隔离目录（不对下载路由开放）
+	clamdAddress  string            // clamd地址，为空时不进行病毒扫描
+	thumbnailSizes = []int{128, 256, 512} // 缩略图尺寸（最长边像素）
+	keepExifGPS   bool              // 是否在记录的EXIF信息中保留GPS位置
+	compressionDefault string        // 默认压缩算法（none/gzip/zstd），为空时不压缩
+	signingKey    []byte             // 分享链接等签名使用的HMAC密钥
 )
 
 // 请求/响应模型定义
@@ -40,6 +51,10 @@ type UploadRequest struct {
 	TotalSize int64  `json:"total_size" binding:"required,min=1"` // 文件总大小
 	ChunkSize int    `json:"chunk_size" binding:"required,min=1"` // 分片大小
 	MD5       string `json:"md5,omitempty"` // 文件MD5（可选）
+	Folder    string `json:"folder,omitempty"` // 所属文件夹（可选）
+	Compression string `json:"compression,omitempty"` // 存储压缩算法（可选，none/gzip/zstd，默认按全局策略）
+	ClientEncryption *ClientEncryption `json:"client_encryption,omitempty"` // 客户端加密信息（可选，声明文件已在客户端加密）
+	Tags      []string `json:"tags,omitempty"` // 标签（可选，供生命周期规则匹配）
 }
 
 // UploadResponse 创建上传任务响应
@@ -102,6 +117,25 @@ type FileRecord struct {
 	CreatedAt   time.Time `json:"created_at"`   // 创建时间
 	UpdatedAt   time.Time `json:"updated_at"`   // 更新时间
 	CompletedAt *time.Time `json:"completed_at,omitempty"` // 完成时间
+	UserID      string    `json:"user_id,omitempty"`      // 所属用户ID
+	Folder      string    `json:"folder,omitempty"`       // 所属文件夹
+	ScanStatus  string    `json:"scan_status,omitempty"`  // 病毒扫描状态
+	Compression string    `json:"compression,omitempty"`  // 存储压缩算法
+	StoredSize  int64     `json:"stored_size,omitempty"`  // 实际占用的存储空间
+	Encrypted   bool      `json:"encrypted,omitempty"`    // 是否静态加密
+	ClientEncryption *ClientEncryption `json:"client_encryption,omitempty"` // 客户端加密信息
+	OwnerNode   string    `json:"owner_node,omitempty"`   // 集群模式下存放分片与文件的节点
+	ContentMD5  string    `json:"md5,omitempty"`          // 文件内容的MD5（解压、解密后）
+	IntegrityStatus string `json:"integrity_status,omitempty"` // 最近一次完整性校验结果
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`  // 最近一次完整性校验时间
+	Tier        string    `json:"tier,omitempty"`         // 所在存储层级
+	Tags        []string  `json:"tags,omitempty"`         // 标签
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"` // 最近一次读取文件内容的时间（按小时记录）
+	Presigned   bool      `json:"presigned,omitempty"`    // 是否为预签名上传（分片与完成请求必须携带有效签名）
+	KeyID       string    `json:"-"`                      // 包装数据密钥的主密钥ID
+	WrappedKey  []byte    `json:"-"`                      // 包装后的数据密钥
+	Processing  map[string]*ProcessorResult `json:"processing,omitempty"` // 处理状态（仅文件详情返回）
+	Replicas    []*FileReplica `json:"replicas,omitempty"` // 副本状态（仅文件详情返回）
 }
 
 // FileHistoryQuery 文件历史查询参数
@@ -122,6 +156,9 @@ type FileStatsResponse struct {
 	TodayUploadCount int64   `json:"today_upload_count"` // 今日上传数量
 	SuccessRate      float64 `json:"success_rate"`       // 成功率
 	AverageFileSize  float64 `json:"average_file_size"`  // 平均文件大小
+	StoredSize       int64   `json:"stored_size"`        // 实际占用的存储空间
+	CompressionRatio float64 `json:"compression_ratio"`  // 压缩率（存储大小/文件大小）
+	SavedSize        int64   `json:"saved_size"`         // 压缩节省的空间
 }
 
 // TodayUploadStatsResponse 今日上传统计响应
@@ -159,38 +196,10 @@ const (
 // GetFileStats 获取文件统计信息
 // GET /api/v1/files/stats
 func GetFileStats(w http.ResponseWriter, r *http.Request) {
-	var stats FileStatsResponse
-
-	// 获取总文件数
-	err := db.QueryRow("SELECT COUNT(*) FROM uploads").Scan(&stats.TotalCount)
-	if err != nil {
-		log.Printf("Get total count error: %v", err)
-		writeError(w, http.StatusInternalServerError, "获取总文件数失败")
-		return
-	}
-
-	// 获取已完成文件数
-	err = db.QueryRow("SELECT COUNT(*) FROM uploads WHERE status = ?", StatusCompleted).Scan(&stats.CompletedCount)
-	if err != nil {
-		log.Printf("Get completed count error: %v", err)
-		writeError(w, http.StatusInternalServerError, "获取已完成文件数失败")
-		return
-	}
-
-	// 获取总文件大小
-	err = db.QueryRow("SELECT COALESCE(SUM(total_size), 0) FROM uploads WHERE status = ?", StatusCompleted).Scan(&stats.TotalSize)
+	stats, err := uploadStore.FileStats(dbCtx(r))
 	if err != nil {
-		log.Printf("Get total size error: %v", err)
-		writeError(w, http.StatusInternalServerError, "获取总文件大小失败")
-		return
-	}
-
-	// 获取今日上传数量
-	today := time.Now().Format("2006-01-02")
-	err = db.QueryRow("SELECT COUNT(*) FROM uploads WHERE DATE(created_at) = ?", today).Scan(&stats.TodayUploadCount)
-	if err != nil {
-		log.Printf("Get today count error: %v", err)
-		writeError(w, http.StatusInternalServerError, "获取今日上传数量失败")
+		reqLogger(r).Error("Get file stats error", "err", err)
+		writeError(w, http.StatusInternalServerError, "获取文件统计失败")
 		return
 	}
 
@@ -208,6 +217,12 @@ func GetFileStats(w http.ResponseWriter, r *http.Request) {
 		stats.AverageFileSize = 0
 	}
 
+	// 计算压缩率
+	if stats.TotalSize > 0 {
+		stats.CompressionRatio = float64(stats.StoredSize) / float64(stats.TotalSize)
+		stats.SavedSize = stats.TotalSize - stats.StoredSize
+	}
+
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"data": stats,
 	})
@@ -216,21 +231,10 @@ func GetFileStats(w http.ResponseWriter, r *http.Request) {
 // GetTodayUploadStats 获取今日上传统计
 // GET /api/v1/files/today-stats
 func GetTodayUploadStats(w http.ResponseWriter, r *http.Request) {
-	var stats TodayUploadStatsResponse
-
 	// 获取今日上传的文件数量和总大小
-	today := time.Now().Format("2006-01-02")
-	query := `
-		SELECT 
-			COUNT(*) as count,
-			COALESCE(SUM(total_size), 0) as total_size
-		FROM uploads 
-		WHERE DATE(created_at) = ? AND status = ?
-	`
-
-	err := db.QueryRow(query, today, StatusCompleted).Scan(&stats.Count, &stats.TotalSize)
+	stats, err := uploadStore.TodayStats(dbCtx(r))
 	if err != nil {
-		log.Printf("Get today stats error: %v", err)
+		reqLogger(r).Error("Get today stats error", "err", err)
 		writeError(w, http.StatusInternalServerError, "获取今日上传统计失败")
 		return
 	}
@@ -243,6 +247,7 @@ func GetTodayUploadStats(w http.ResponseWriter, r *http.Request) {
 // GetRecentFiles 获取最近上传的文件
 // GET /api/v1/files/recent
 func GetRecentFiles(w http.ResponseWriter, r *http.Request) {
+	logger := reqLogger(r)
 	// 获取查询参数
 	limitStr := r.URL.Query().Get("limit")
 	limit := 5 // 默认值
@@ -257,45 +262,12 @@ func GetRecentFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 查询最近的文件
-	query := `
-		SELECT 
-			upload_id, file_name, total_size, status, created_at, updated_at
-		FROM uploads 
-		ORDER BY created_at DESC 
-		LIMIT ?
-	`
-
-	rows, err := db.Query(query, limit)
+	files, err := uploadStore.RecentFiles(dbCtx(r), limit)
 	if err != nil {
-		log.Printf("Get recent files error: %v", err)
+		logger.Error("Get recent files error", "err", err)
 		writeError(w, http.StatusInternalServerError, "获取最近文件失败")
 		return
 	}
-	defer rows.Close()
-
-	var files []*RecentFile
-	for rows.Next() {
-		file := &RecentFile{}
-		err := rows.Scan(
-			&file.UploadID,
-			&file.FileName,
-			&file.FileSize,
-			&file.Status,
-			&file.CreatedAt,
-			&file.UpdatedAt,
-		)
-		if err != nil {
-			log.Printf("Scan recent file error: %v", err)
-			continue
-		}
-		files = append(files, file)
-	}
-
-	if err = rows.Err(); err != nil {
-		log.Printf("Rows error: %v", err)
-		writeError(w, http.StatusInternalServerError, "处理文件数据失败")
-		return
-	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"data": files,
@@ -304,16 +276,49 @@ func GetRecentFiles(w http.ResponseWriter, r *http.Request) {
 
 // 辅助函数
 
-// getUploadLock 获取或创建上传任务的互斥锁
-func getUploadLock(uploadID string) *sync.Mutex {
-	uploadLocksMu.Lock()
-	defer uploadLocksMu.Unlock()
-	if m, ok := uploadLocks[uploadID]; ok {
-		return m
+// getUserID 获取当前请求的用户ID
+// 用户身份由上游网关认证后通过 X-User-ID 请求头注入
+func getUserID(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("X-User-ID"))
+}
+
+// isAdmin 判断当前请求用户是否为管理员（由网关通过 X-User-Role 注入）
+func isAdmin(r *http.Request) bool {
+	return getUserID(r) != "" && r.Header.Get("X-User-Role") == "admin"
+}
+
+// normalizeFolder 规范化文件夹路径，去除首尾斜杠并拒绝路径穿越
+func normalizeFolder(folder string) (string, error) {
+	folder = strings.Trim(strings.ReplaceAll(folder, "\\", "/"), "/")
+	if folder == "" {
+		return "", nil
+	}
+	for _, part := range strings.Split(folder, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid folder: %s", folder)
+		}
+	}
+	return folder, nil
+}
+
+// finalFilePath 计算已完成文件在存储目录中的路径
+func finalFilePath(uploadID, fileName string) string {
+	return filepath.Join(finalDir, fmt.Sprintf("%s_%s", uploadID, filepath.Base(fileName)))
+}
+
+// initSigningKey 初始化签名密钥
+// 未配置 security.signing_key 时生成随机密钥（重启后已签发的链接失效）
+func initSigningKey(key string) error {
+	if key != "" {
+		signingKey = []byte(key)
+		return nil
+	}
+	signingKey = make([]byte, 32)
+	if _, err := rand.Read(signingKey); err != nil {
+		return err
 	}
-	m := &sync.Mutex{}
-	uploadLocks[uploadID] = m
-	return m
+	slog.Warn("Signing key not configured, using a random signing key")
+	return nil
 }
 
 // writeJSON 写入JSON响应
@@ -378,16 +383,22 @@ func parseQueryParams(r *http.Request) *FileHistoryQuery {
 }
 
 // initDB 初始化数据库连接
-func initDB(dsn string) error {
-	var err error
-	db, err = sql.Open("mysql", dsn)
+func initDB(cfg DatabaseConfig) error {
+	drv, ok := storeDrivers[cfg.Driver]
+	if !ok {
+		return fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+	connector, err := drv.connector(cfg.DSN)
 	if err != nil {
 		return err
 	}
-	db.SetConnMaxLifetime(time.Minute * 3) // 连接最大生命周期
-	db.SetMaxOpenConns(10)                 // 最大打开连接数
-	db.SetMaxIdleConns(10)                 // 最大空闲连接数
-	return db.Ping()                       // 测试连接
+	dialect = drv.dialect
+	db = sql.OpenDB(instrumentedConnector{connector}) // 统计语句耗时
+	uploadStore = drv.newStore(sqlStore{db: db, d: dialect})
+	db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime)) // 连接最大生命周期
+	db.SetMaxOpenConns(cfg.MaxOpenConns)                      // 最大打开连接数
+	db.SetMaxIdleConns(cfg.MaxIdleConns)                      // 最大空闲连接数
+	return db.Ping()                                          // 测试连接
 }
 
 // 处理器函数
@@ -401,36 +412,105 @@ func CreateUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validateUploadRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := insertUpload(dbCtx(r), &req, getUserID(r), false)
+	if err != nil {
+		reqLogger(r).Error("Database insert upload error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create upload task")
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// validateUploadRequest 校验创建上传任务请求，并规范化文件夹路径
+func validateUploadRequest(req *UploadRequest) error {
 	// 验证必需字段
 	if req.FileName == "" || req.TotalSize <= 0 || req.ChunkSize <= 0 {
-		writeError(w, http.StatusBadRequest, "Missing or invalid required fields: file_name, total_size, chunk_size")
-		return
+		return fmt.Errorf("Missing or invalid required fields: file_name, total_size, chunk_size")
+	}
+	if max := config.Limits.MaxFileSize; max > 0 && req.TotalSize > max {
+		return fmt.Errorf("File too large, at most %d bytes", max)
+	}
+	if max := config.Limits.MaxChunkSize; max > 0 && req.ChunkSize > max {
+		return fmt.Errorf("Chunk size too large, at most %d bytes", max)
 	}
 
+	folder, err := normalizeFolder(req.Folder)
+	if err != nil {
+		return fmt.Errorf("Invalid folder")
+	}
+	req.Folder = folder
+
+	if !validCompression(req.Compression) {
+		return fmt.Errorf("Invalid compression, must be one of none, gzip, zstd")
+	}
+	if req.Tags, err = normalizeTags(req.Tags); err != nil {
+		return err
+	}
+	if req.ClientEncryption != nil {
+		return validateClientEncryption(req)
+	}
+	return nil
+}
+
+// insertUpload 写入上传任务记录并创建分片临时目录
+// presigned 为 true 时该任务只接受携带有效签名的分片与完成请求
+func insertUpload(ctx context.Context, req *UploadRequest, userID string, presigned bool) (*UploadResponse, error) {
 	// 计算总分片数
 	totalChunks := int((req.TotalSize + int64(req.ChunkSize) - 1) / int64(req.ChunkSize))
 	uploadID := uuid.New().String() // 生成唯一上传ID
 
-	// 插入数据库记录
-	_, err := db.Exec(
-		"INSERT INTO uploads (upload_id, file_name, total_size, chunk_size, total_chunks, status) VALUES (?, ?, ?, ?, ?, ?)",
-		uploadID, req.FileName, req.TotalSize, req.ChunkSize, totalChunks, StatusInProgress,
-	)
+	compression := chooseCompression(req.Compression, req.FileName)
+
+	// 启用静态加密时为文件生成数据密钥
+	keyID, wrappedKey, err := newDataKey()
 	if err != nil {
-		log.Println("Database insert upload error:", err)
-		writeError(w, http.StatusInternalServerError, "Failed to create upload task")
-		return
+		return nil, err
+	}
+
+	var clientEncryption []byte
+	if req.ClientEncryption != nil {
+		if clientEncryption, err = json.Marshal(req.ClientEncryption); err != nil {
+			return nil, err
+		}
+	}
+
+	// 插入数据库记录
+	file := &FileRecord{
+		UploadID:    uploadID,
+		FileName:    req.FileName,
+		FileSize:    req.TotalSize,
+		ChunkSize:   req.ChunkSize,
+		TotalChunks: totalChunks,
+		Status:      StatusInProgress,
+		UserID:      userID,
+		Folder:      req.Folder,
+		Compression: compression,
+		KeyID:       keyID,
+		WrappedKey:  wrappedKey,
+		OwnerNode:   clusterNodeID,
+		Tags:        req.Tags,
+		Presigned:   presigned,
+	}
+	if err := uploadStore.CreateUpload(ctx, file, clientEncryption); err != nil {
+		return nil, err
 	}
 
 	// 创建临时目录存储分片
 	_ = os.MkdirAll(filepath.Join(tmpDir, uploadID), 0755)
 
-	resp := UploadResponse{
+	uploadsCreated.Inc()
+	publishUploadEvent(ctx, EventUploadCreated, file, nil, nil)
+
+	return &UploadResponse{
 		UploadID:    uploadID,
 		ChunkSize:   req.ChunkSize,
 		TotalChunks: totalChunks,
-	}
-	writeJSON(w, http.StatusCreated, resp)
+	}, nil
 }
 
 // UploadChunk 上传文件分片
@@ -438,7 +518,9 @@ func CreateUpload(w http.ResponseWriter, r *http.Request) {
 func UploadChunk(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uploadID := vars["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
 	indexStr := vars["index"]
+	ctx := dbCtx(r)
 
 	// 解析分片索引
 	index, err := strconv.Atoi(indexStr)
@@ -447,80 +529,130 @@ func UploadChunk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 预签名请求：校验签名并限制分片大小
+	body := r.Body
+	if isPresignedRequest(r) {
+		maxSize, err := verifyPresignedChunk(r, uploadID, index)
+		if err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
 	// 获取上传任务信息
-	var chunkSize, totalChunks int
-	var status string
-	err = db.QueryRow(
-		"SELECT chunk_size, total_chunks, status FROM uploads WHERE upload_id = ?",
-		uploadID,
-	).Scan(&chunkSize, &totalChunks, &status)
+	file, err := uploadStore.GetUpload(ctx, uploadID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			writeError(w, http.StatusNotFound, "Upload task not found")
 			return
 		}
-		log.Println("Database query error:", err)
+		logger.Error("Database query error", "err", err)
 		writeError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
+	// 预签名上传任务只接受携带签名的分片，避免绕过大小限制与过期时间
+	if file.Presigned && !isPresignedRequest(r) {
+		writeError(w, http.StatusForbidden, "Signature required for presigned upload")
+		return
+	}
+
 	// 验证状态
-	if status != StatusInProgress {
+	if file.Status != StatusInProgress {
 		writeError(w, http.StatusBadRequest, "Upload is not in progress")
 		return
 	}
 
 	// 验证分片索引范围
-	if index >= totalChunks {
+	if index >= file.TotalChunks {
 		writeError(w, http.StatusBadRequest, "Chunk index out of range")
 		return
 	}
 
+	// 分片不得超过上传任务的分片大小；客户端加密文件的分片大小必须与密文布局一致
+	body = http.MaxBytesReader(w, body, int64(file.ChunkSize))
+	expectedSize := int64(-1)
+	if file.ClientEncryption != nil {
+		expectedSize = chunkExpectedSize(file.FileSize, file.ChunkSize, index)
+		body = http.MaxBytesReader(w, body, expectedSize)
+	}
+
 	// 创建临时目录
 	tmpPath := filepath.Join(tmpDir, uploadID)
 	_ = os.MkdirAll(tmpPath, 0755)
 	chunkPath := filepath.Join(tmpPath, fmt.Sprintf("chunk_%06d", index))
 
+	// 分片与最终文件使用相同的压缩算法与数据密钥
+	sp, err := fileStoreParams(file)
+	if err != nil {
+		logger.Error("Load data key error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
 	// 创建临时文件
-	tmpFile, err := os.Create(chunkPath + ".part")
+	ctx, span := tracer.Start(ctx, "chunk.write", trace.WithAttributes(
+		attribute.String("upload.id", uploadID),
+		attribute.Int("chunk.index", index),
+	))
+	defer span.End()
+	writeStart := time.Now()
+	tmpFile, err := createStoredFile(chunkPath, sp.forChunk(index))
 	if err != nil {
-		log.Println("Create chunk file error:", err)
+		failSpan(span, err)
+		logger.Error("Create chunk file error", "err", err)
 		writeError(w, http.StatusInternalServerError, "Server error")
 		return
 	}
-	defer tmpFile.Close()
 
-	// 读取并写入分片数据，同时计算MD5
+	// 读取并写入分片数据，同时计算MD5（按原始数据计算）
+	// 停机排空超时后请求上下文被取消，写入中止并删除临时文件
 	hasher := md5.New()
 	mw := io.MultiWriter(tmpFile, hasher) // 多写器：同时写入文件和计算哈希
-	n, err := io.Copy(mw, r.Body)
+	n, err := io.Copy(mw, contextReader{ctx: r.Context(), r: body})
+	span.SetAttributes(attribute.Int64("chunk.bytes", n))
 	if err != nil {
-		log.Println("Write chunk error:", err)
+		tmpFile.Abort()
+		failSpan(span, err)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, "Chunk exceeds allowed size")
+			return
+		}
+		if serverCtx.Err() != nil {
+			logger.Warn("Chunk write interrupted by shutdown", "index", index)
+			writeError(w, http.StatusServiceUnavailable, "Server is shutting down")
+			return
+		}
+		logger.Error("Write chunk error", "err", err)
 		writeError(w, http.StatusInternalServerError, "Write error")
 		return
 	}
-	tmpFile.Close()
+
+	if expectedSize >= 0 && n != expectedSize {
+		tmpFile.Abort()
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Chunk size %d does not match expected %d", n, expectedSize))
+		return
+	}
 
 	chunkMD5 := hex.EncodeToString(hasher.Sum(nil))
 
 	// 原子性重命名临时文件
-	if err := os.Rename(chunkPath+".part", chunkPath); err != nil {
-		log.Println("Rename chunk file error:", err)
+	if _, err := tmpFile.Commit(); err != nil {
+		failSpan(span, err)
+		logger.Error("Rename chunk file error", "err", err)
 		writeError(w, http.StatusInternalServerError, "Server error")
 		return
 	}
+	chunkWriteDuration.Observe(time.Since(writeStart).Seconds())
+	chunksReceived.Inc()
+	bytesReceived.Add(float64(n))
 
 	// 保存分片元数据到数据库
-	_, err = db.Exec(`
-		INSERT INTO upload_chunks (upload_id, chunk_index, chunk_size, chunk_md5)
-		VALUES (?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE 
-			chunk_size = VALUES(chunk_size), 
-			chunk_md5 = VALUES(chunk_md5), 
-			received_at = CURRENT_TIMESTAMP
-	`, uploadID, index, n, chunkMD5)
+	err = uploadStore.SaveChunk(ctx, uploadID, index, n, chunkMD5)
 	if err != nil {
-		log.Println("Database insert chunk error:", err)
+		logger.Error("Database insert chunk error", "err", err)
 		writeError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
@@ -536,53 +668,40 @@ func UploadChunk(w http.ResponseWriter, r *http.Request) {
 // GetUploadStatus 获取上传状态
 // GET /api/v1/uploads/{upload_id}
 func GetUploadStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := dbCtx(r)
 	uploadID := mux.Vars(r)["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
 
 	// 获取上传基本信息
-	var fileName string
-	var totalSize int64
-	var chunkSize, totalChunks int
-	var status string
-	err := db.QueryRow(
-		"SELECT file_name, total_size, chunk_size, total_chunks, status FROM uploads WHERE upload_id = ?",
-		uploadID,
-	).Scan(&fileName, &totalSize, &chunkSize, &totalChunks, &status)
+	file, err := uploadStore.GetUpload(ctx, uploadID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			writeError(w, http.StatusNotFound, "Upload task not found")
 			return
 		}
-		log.Println("Database query error:", err)
+		logger.Error("Database query error", "err", err)
 		writeError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	// 获取已上传的分片
-	rows, err := db.Query("SELECT chunk_index FROM upload_chunks WHERE upload_id = ? ORDER BY chunk_index", uploadID)
+	chunks, err := uploadStore.ListChunks(ctx, uploadID)
 	if err != nil {
-		log.Println("Database query chunks error:", err)
+		logger.Error("Database query chunks error", "err", err)
 		writeError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
-	defer rows.Close()
-
-	var chunks []int
-	for rows.Next() {
-		var idx int
-		rows.Scan(&idx)
-		chunks = append(chunks, idx)
-	}
 
 	// 构建响应
 	resp := UploadStatusResponse{
 		UploadID: uploadID,
-		Status:   status,
+		Status:   file.Status,
 		Chunks:   chunks,
 	}
 	resp.Progress.Completed = len(chunks)
-	resp.Progress.Total = totalChunks
-	if totalChunks > 0 {
-		resp.Progress.Percent = int(float64(len(chunks)) / float64(totalChunks) * 100)
+	resp.Progress.Total = file.TotalChunks
+	if file.TotalChunks > 0 {
+		resp.Progress.Percent = int(float64(len(chunks)) / float64(file.TotalChunks) * 100)
 	}
 
 	writeJSON(w, http.StatusOK, resp)
@@ -592,28 +711,41 @@ func GetUploadStatus(w http.ResponseWriter, r *http.Request) {
 // POST /api/v1/uploads/{upload_id}/complete
 func CompleteUpload(w http.ResponseWriter, r *http.Request) {
 	uploadID := mux.Vars(r)["upload_id"]
-	lock := getUploadLock(uploadID)
-	lock.Lock()         // 加锁防止并发完成
-	defer lock.Unlock() // 确保解锁
+	logger := reqLogger(r).With("upload_id", uploadID)
+	ctx := dbCtx(r)
+
+	// 预签名请求：校验完成链接签名
+	if isPresignedRequest(r) {
+		if err := verifyPresignedComplete(r, uploadID); err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	// 加锁防止并发完成（多实例部署时跨实例互斥）
+	lease, unlock, err := lockUpload(r.Context(), uploadID)
+	if err != nil {
+		writeLockError(w, r, err)
+		return
+	}
+	defer unlock()
 
 	// 获取上传元数据
-	var fileName string
-	var totalSize int64
-	var chunkSize, totalChunks int
-	var status string
-	err := db.QueryRow(
-		"SELECT file_name, total_size, chunk_size, total_chunks, status FROM uploads WHERE upload_id = ?",
-		uploadID,
-	).Scan(&fileName, &totalSize, &chunkSize, &totalChunks, &status)
+	file, err := getFileByUploadID(ctx, uploadID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			writeError(w, http.StatusNotFound, "Upload task not found")
 			return
 		}
-		log.Println("Database read upload error:", err)
+		logger.Error("Database read upload error", "err", err)
 		writeError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
+	if file.Presigned && !isPresignedRequest(r) {
+		writeError(w, http.StatusForbidden, "Signature required for presigned upload")
+		return
+	}
+	totalChunks, status := file.TotalChunks, file.Status
 
 	// 检查是否已完成
 	if status == StatusCompleted {
@@ -635,28 +767,76 @@ func CompleteUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 合并分片
-	finalPath, fileSize, fileMD5, err := mergeChunks(uploadID, fileName, chunkFiles)
+	// 合并分片；停机排空超时或跨实例锁丢失时合并被取消，分片保留且任务保持 in_progress，客户端可重试
+	mergeCtx, cancelMerge := shutdownContext(withLogger(ctx, logger))
+	defer cancelMerge()
+	stopLease := context.AfterFunc(lease, cancelMerge)
+	defer stopLease()
+	mergeCtx, span := tracer.Start(mergeCtx, "merge_chunks", trace.WithAttributes(
+		attribute.String("upload.id", uploadID),
+		attribute.Int("chunk.count", len(chunkFiles)),
+	))
+	mergeStart := time.Now()
+	finalPath, fileSize, storedSize, fileMD5, err := mergeChunks(mergeCtx, file, chunkFiles)
+	mergeDuration.Observe(time.Since(mergeStart).Seconds())
+	span.SetAttributes(attribute.Int64("file.size", fileSize), attribute.Int64("file.stored_size", storedSize))
+	failSpan(span, err)
+	span.End()
 	if err != nil {
-		log.Println("Merge chunks error:", err)
+		if leaseLost(lease) {
+			writeLockError(w, r, errLockLost)
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			logger.Warn("Merge interrupted by shutdown")
+			w.Header().Set("Retry-After", "30")
+			writeError(w, http.StatusServiceUnavailable, "Server is shutting down, retry later")
+			return
+		}
+		logger.Error("Merge chunks error", "err", err)
+		uploadsFailed.WithLabelValues("merge").Inc()
+		publishUploadEvent(ctx, EventUploadFailed, file, nil, err)
 		writeError(w, http.StatusInternalServerError, "Failed to merge chunks")
 		return
 	}
 
-	// 更新数据库状态
-	_, err = db.Exec(
-		"UPDATE uploads SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE upload_id = ?",
-		StatusCompleted, uploadID,
-	)
+	// 合并期间锁已被其他实例取得时不再修改文件与记录，由持有锁的实例完成
+	if leaseLost(lease) {
+		writeLockError(w, r, errLockLost)
+		return
+	}
+
+	// 客户端加密文件：校验密文大小与MD5，失败时保留分片以便重传
+	if ce := file.ClientEncryption; ce != nil && (fileSize != file.FileSize || fileMD5 != ce.CiphertextMD5) {
+		os.Remove(finalPath)
+		err := fmt.Errorf("ciphertext mismatch: size %d/%d, md5 %s/%s", fileSize, file.FileSize, fileMD5, ce.CiphertextMD5)
+		logger.Warn("Upload integrity check failed", "err", err)
+		uploadsFailed.WithLabelValues("integrity").Inc()
+		publishUploadEvent(ctx, EventUploadFailed, file, nil, err)
+		writeError(w, http.StatusUnprocessableEntity, "Ciphertext size or MD5 does not match")
+		return
+	}
+
+	// 更新数据库状态；启用病毒扫描时在扫描完成前禁止下载
+	// 客户端加密文件无法扫描
+	var scanStatus string
+	if clamdAddress != "" && file.ClientEncryption == nil {
+		scanStatus = ScanScanning
+	}
+	err = uploadStore.CompleteUpload(ctx, uploadID, scanStatus, storedSize, fileMD5)
 	if err != nil {
-		log.Println("Database update upload error:", err)
+		logger.Error("Database update upload error", "err", err)
 		// 非致命错误：不影响客户端响应
 	}
 
 	// 异步清理临时分片
-	go cleanupChunks(uploadID)
+	goBackground(func() { cleanupChunks(withLogger(ctx, logger), uploadID) })
 
-	log.Printf("Upload %s completed successfully -> %s (size: %d bytes)\n", uploadID, finalPath, fileSize)
+	// 异步执行后处理与副本复制
+	enqueueProcessing(ctx, uploadID)
+	enqueueReplication(ctx, uploadID)
+
+	logger.Info("Upload completed", "path", finalPath, "size", fileSize, "stored_size", storedSize)
 
 	resp := CompleteResponse{
 		Status:    StatusCompleted,
@@ -664,6 +844,8 @@ func CompleteUpload(w http.ResponseWriter, r *http.Request) {
 		FileSize:  fileSize,
 		MD5:       fileMD5,
 	}
+	uploadsCompleted.Inc()
+	publishUploadEvent(ctx, EventUploadCompleted, file, &resp, nil)
 	writeJSON(w, http.StatusOK, resp)
 }
 
@@ -672,182 +854,199 @@ func CompleteUpload(w http.ResponseWriter, r *http.Request) {
 func GetFileHistory(w http.ResponseWriter, r *http.Request) {
 	query := parseQueryParams(r)
 
-	// 构建WHERE条件
-	whereClause := "WHERE 1=1"
-	args := []interface{}{}
-
-	// 添加过滤器
-	if query.Status != "" {
-		whereClause += " AND status = ?"
-		args = append(args, query.Status)
+	files, total, err := uploadStore.FileHistory(dbCtx(r), query)
+	if err != nil {
+		reqLogger(r).Error("Database history query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database query error")
+		return
 	}
 
-	if query.Keyword != "" {
-		whereClause += " AND file_name LIKE ?"
-		args = append(args, "%"+query.Keyword+"%")
+	// 构建响应
+	resp := FileHistoryResponse{
+		Total:   total,
+		Page:    query.Page,
+		PerPage: query.PerPage,
+		Files:   files,
 	}
 
-	// 构建排序
-	allowedSortFields := map[string]bool{
-		"created_at":  true,
-		"updated_at":  true,
-		"file_name":   true,
-		"total_size":  true,
-		"total_chunks": true,
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetFileDetail 获取文件详情
+// GET /api/v1/files/{upload_id}
+func GetFileDetail(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
+	ctx := dbCtx(r)
+
+	file, err := getFileByUploadID(ctx, uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		logger.Error("Database query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
 	}
-	
-	sortBy := query.SortBy
-	if !allowedSortFields[sortBy] {
-		sortBy = "created_at"
+
+	// 附加后处理与副本状态
+	if file.Processing, err = loadProcessingStatus(ctx, uploadID); err != nil {
+		logger.Error("Load processing status error", "err", err)
 	}
-	
-	order := strings.ToUpper(query.Order)
-	if order != "ASC" && order != "DESC" {
-		order = "DESC"
+	if file.Replicas, err = loadReplicas(ctx, uploadID); err != nil {
+		logger.Error("Load replicas error", "err", err)
 	}
-	orderBy := fmt.Sprintf("ORDER BY %s %s", sortBy, order)
 
-	// 构建分页
-	offset := (query.Page - 1) * query.PerPage
-	limitClause := "LIMIT ? OFFSET ?"
+	writeJSON(w, http.StatusOK, file)
+}
+
+// DownloadFile 下载已完成的文件，支持Range断点续传
+// GET /api/v1/files/{upload_id}/download
+func DownloadFile(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
 
-	// 获取总数
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM uploads %s", whereClause)
-	log.Printf("Count query: %s, args: %v", countQuery, args)
-	
-	var total int
-	err := db.QueryRow(countQuery, args...).Scan(&total)
+	file, err := getFileByUploadID(dbCtx(r), uploadID)
 	if err != nil {
-		log.Printf("Database count query error: %v", err)
-		writeError(w, http.StatusInternalServerError, "Database count error")
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		logger.Error("Database query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
-
-	// 如果总数是0，直接返回空结果
-	if total == 0 {
-		resp := FileHistoryResponse{
-			Total:   0,
-			Page:    query.Page,
-			PerPage: query.PerPage,
-			Files:   []*FileRecord{},
-		}
-		writeJSON(w, http.StatusOK, resp)
+	if file.UserID != "" && file.UserID != getUserID(r) && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Not the owner of this file")
 		return
 	}
 
-	// 构建数据查询
-	selectQuery := fmt.Sprintf(`
-		SELECT 
-			upload_id, file_name, total_size, chunk_size, total_chunks, 
-			status, created_at, updated_at
-		FROM uploads 
-		%s 
-		%s 
-		%s
-	`, whereClause, orderBy, limitClause)
-
-	// 为数据查询准备参数（需要添加分页参数）
-	queryArgs := make([]interface{}, len(args))
-	copy(queryArgs, args)
-	queryArgs = append(queryArgs, query.PerPage, offset)
-
-	log.Printf("Select query: %s, args: %v", selectQuery, queryArgs)
+	serveStoredFile(w, r, file, false)
+}
 
-	// 执行查询
-	rows, err := db.Query(selectQuery, queryArgs...)
+// DeleteFile 删除文件及其分片、分享记录
+// DELETE /api/v1/files/{upload_id}
+func DeleteFile(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
+	ctx := dbCtx(r)
+	lease, unlock, err := lockUpload(r.Context(), uploadID)
 	if err != nil {
-		log.Printf("Database select query error: %v", err)
-		writeError(w, http.StatusInternalServerError, "Database query error")
+		writeLockError(w, r, err)
 		return
 	}
-	defer rows.Close()
-
-	// 处理结果
-	var files []*FileRecord
-	for rows.Next() {
-		file := &FileRecord{}
-		
-		err := rows.Scan(
-			&file.UploadID, &file.FileName, &file.FileSize, &file.ChunkSize, &file.TotalChunks,
-			&file.Status, &file.CreatedAt, &file.UpdatedAt,
-		)
-		if err != nil {
-			log.Printf("Database scan error: %v", err)
-			continue
-		}
+	defer unlock()
 
-		// 为已完成文件设置完成时间
-		if file.Status == StatusCompleted {
-			file.CompletedAt = &file.UpdatedAt
+	file, err := getFileByUploadID(ctx, uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "File not found")
+			return
 		}
-
-		files = append(files, file)
+		logger.Error("Database query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if file.UserID != "" && file.UserID != getUserID(r) && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Not the owner of this file")
+		return
+	}
+	if leaseLost(lease) {
+		writeLockError(w, r, errLockLost)
+		return
 	}
 
-	if err = rows.Err(); err != nil {
-		log.Printf("Database rows error: %v", err)
-		writeError(w, http.StatusInternalServerError, "Database rows error")
+	if err := removeFile(ctx, logger, file); err != nil {
+		logger.Error("Database delete upload error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	// 构建响应
-	resp := FileHistoryResponse{
-		Total:   total,
-		Page:    query.Page,
-		PerPage: query.PerPage,
-		Files:   files,
+	logger.Info("File deleted")
+	publishUploadEvent(ctx, EventFileDeleted, file, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeFile 删除文件记录及其存储文件、副本、分片与缩略图，调用方需持有上传任务锁
+func removeFile(ctx context.Context, logger *slog.Logger, file *FileRecord) error {
+	// 分片与分享记录通过外键级联删除
+	if err := uploadStore.DeleteUpload(ctx, file.UploadID); err != nil {
+		return err
 	}
 
-	writeJSON(w, http.StatusOK, resp)
+	for _, path := range []string{storedFilePath(file), quarantinePath(file.UploadID, file.FileName)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Error("Remove stored file error", "path", path, "err", err)
+		}
+	}
+	removeReplicas(file, logger)
+	goBackground(func() { cleanupChunks(withLogger(ctx, logger), file.UploadID) })
+	os.RemoveAll(thumbnailDir(file.UploadID))
+	return nil
 }
 
-// GetFileDetail 获取文件详情
-// GET /api/v1/files/{upload_id}
-func GetFileDetail(w http.ResponseWriter, r *http.Request) {
-	uploadID := mux.Vars(r)["upload_id"]
+// checkFileAccessible 检查文件内容是否可被读取：已完成且未处于扫描中或被隔离
+// 不可读取时已写入错误响应并返回false
+func checkFileAccessible(w http.ResponseWriter, file *FileRecord) bool {
+	if file.Status != StatusCompleted {
+		writeError(w, http.StatusConflict, "File is not completed")
+		return false
+	}
+	switch file.ScanStatus {
+	case ScanInfected:
+		writeError(w, http.StatusForbidden, "File is infected and has been quarantined")
+		return false
+	case ScanScanning, ScanError:
+		writeError(w, http.StatusConflict, "File has not passed virus scanning")
+		return false
+	}
+	return true
+}
+
+// serveStoredFile 流式输出已完成文件内容
+// inline为true时浏览器内联预览，否则作为附件下载
+func serveStoredFile(w http.ResponseWriter, r *http.Request, file *FileRecord, inline bool) {
+	logger := reqLogger(r).With("upload_id", file.UploadID)
+	if !checkFileAccessible(w, file) {
+		return
+	}
 
-	file, err := getFileByUploadID(uploadID)
+	// 压缩文件按块透明解压，Range 请求只解压涉及的块
+	f, err := openStoredFile(file)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			writeError(w, http.StatusNotFound, "File not found")
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, "File content not found")
 			return
 		}
-		log.Println("Database query error:", err)
-		writeError(w, http.StatusInternalServerError, "Database error")
+		if errors.Is(err, errFileCorrupt) {
+			writeError(w, http.StatusConflict, "File failed integrity verification")
+			return
+		}
+		logger.Error("Open stored file error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Server error")
 		return
 	}
+	defer f.Close()
+	recordAccess(file)
 
-	writeJSON(w, http.StatusOK, file)
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": filepath.Base(file.FileName),
+	}))
+	setClientEncryptionHeader(w, file)
+	// ServeContent 负责处理 Range、If-Modified-Since 等条件请求
+	http.ServeContent(servedWriter{w}, r, file.FileName, file.UpdatedAt, f)
 }
 
 // 辅助函数
 
 // getFileByUploadID 根据上传ID获取文件记录
-func getFileByUploadID(uploadID string) (*FileRecord, error) {
-	file := &FileRecord{}
-
-	query := `
-		SELECT 
-			upload_id, file_name, total_size, chunk_size, total_chunks, 
-			status, created_at, updated_at
-		FROM uploads 
-		WHERE upload_id = ?
-	`
-
-	err := db.QueryRow(query, uploadID).Scan(
-		&file.UploadID, &file.FileName, &file.FileSize, &file.ChunkSize, &file.TotalChunks,
-		&file.Status, &file.CreatedAt, &file.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	// 为已完成文件设置完成时间
-	if file.Status == StatusCompleted {
-		file.CompletedAt = &file.UpdatedAt
-	}
-
-	return file, nil
+func getFileByUploadID(ctx context.Context, uploadID string) (*FileRecord, error) {
+	return uploadStore.GetUpload(ctx, uploadID)
 }
 
 // findAndValidateChunks 查找并验证分片
@@ -883,7 +1082,7 @@ func findAndValidateChunks(uploadID string, totalChunks int) ([]string, []int, e
 			// 尝试不修剪零的解析
 			index, err = strconv.Atoi(strings.TrimPrefix(base, "chunk_"))
 			if err != nil {
-				log.Printf("Parse chunk index failed for %s: %v\n", file, err)
+				slog.Warn("Parse chunk index failed", "upload_id", uploadID, "file", file, "err", err)
 				continue
 			}
 		}
@@ -910,103 +1109,177 @@ func findAndValidateChunks(uploadID string, totalChunks int) ([]string, []int, e
 }
 
 // mergeChunks 合并分片
-func mergeChunks(uploadID, fileName string, chunkFiles []string) (string, int64, string, error) {
+// 分片解码后按文件的压缩算法与数据密钥重新分块写入，返回逻辑大小与实际存储大小
+// ctx 取消时中止合并并删除未完成的最终文件，日志使用 ctx 中的日志器
+func mergeChunks(ctx context.Context, file *FileRecord, chunkFiles []string) (string, int64, int64, string, error) {
+	uploadID := file.UploadID
+	logger := ctxLogger(ctx)
+
 	// 构建最终文件路径
-	finalPath := filepath.Join(finalDir, fmt.Sprintf("%s_%s", uploadID, filepath.Base(fileName)))
+	finalPath := finalFilePath(uploadID, file.FileName)
 	_ = os.MkdirAll(finalDir, 0755)
-	
-	tmpFinalPath := finalPath + ".part"
-	out, err := os.Create(tmpFinalPath)
+
+	sp, err := fileStoreParams(file)
+	if err != nil {
+		return "", 0, 0, "", err
+	}
+	out, err := createStoredFile(finalPath, sp)
 	if err != nil {
-		return "", 0, "", err
+		return "", 0, 0, "", err
 	}
-	defer out.Close()
 
 	hasher := md5.New()
 	totalWritten := int64(0)
 
-	// 按顺序合并所有分片
+	// 按顺序合并所有分片（chunkFiles 按分片序号排列且无缺失）
 	for i, chunkFile := range chunkFiles {
-		f, err := os.Open(chunkFile)
-		if err != nil {
-			return "", 0, "", fmt.Errorf("open chunk %s: %v", chunkFile, err)
-		}
-
-		n, err := io.Copy(io.MultiWriter(out, hasher), f)
-		f.Close()
+		n, err := copyChunk(ctx, io.MultiWriter(out, hasher), chunkFile, sp.forChunk(i))
 		if err != nil {
-			return "", 0, "", fmt.Errorf("copy chunk %s: %v", chunkFile, err)
+			out.Abort()
+			return "", 0, 0, "", err
 		}
 
 		totalWritten += n
 
 		// 为大文件记录进度
 		if i%50 == 0 {
-			log.Printf("Merging upload %s: chunk %d/%d, written %d bytes\n", 
-				uploadID, i+1, len(chunkFiles), totalWritten)
+			logger.Info("Merging chunks", "chunk", i+1, "total", len(chunkFiles), "written", totalWritten)
 		}
 	}
 
-	if err := out.Close(); err != nil {
-		return "", 0, "", err
+	// 写入索引并重命名为最终路径；合并已被取消（停机或锁丢失）时不落盘
+	if err := ctx.Err(); err != nil {
+		out.Abort()
+		return "", 0, 0, "", err
 	}
-
-	// 重命名为最终路径
-	if err := os.Rename(tmpFinalPath, finalPath); err != nil {
-		return "", 0, "", err
+	storedSize, err := out.Commit()
+	if err != nil {
+		return "", 0, 0, "", err
 	}
 
 	fileMD5 := hex.EncodeToString(hasher.Sum(nil))
-	return finalPath, totalWritten, fileMD5, nil
+	return finalPath, totalWritten, storedSize, fileMD5, nil
 }
 
-// cleanupChunks 清理临时分片
-func cleanupChunks(uploadID string) {
-	tmpPath := filepath.Join(tmpDir, uploadID)
-	if err := os.RemoveAll(tmpPath); err != nil {
-		log.Printf("Cleanup chunks error for %s: %v\n", uploadID, err)
-	} else {
-		log.Printf("Cleaned up chunks for upload %s\n", uploadID)
+// copyChunk 解码单个分片并写入目标
+func copyChunk(ctx context.Context, dst io.Writer, chunkFile string, sp storeParams) (int64, error) {
+	f, err := openStoredPath(chunkFile, sp)
+	if err != nil {
+		return 0, fmt.Errorf("open chunk %s: %v", chunkFile, err)
 	}
+	defer f.Close()
+
+	n, err := io.Copy(dst, contextReader{ctx: ctx, r: f})
+	if err != nil {
+		return n, fmt.Errorf("copy chunk %s: %w", chunkFile, err)
+	}
+	return n, nil
 }
 
-// HealthCheck 健康检查
-// GET /api/v1/health
-func HealthCheck(w http.ResponseWriter, r *http.Request) {
-	healthInfo := map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": time.Now().UTC(),
-		"version":   "1.0.0",
+// cleanupChunks 清理临时分片，ctx 携带调用方带 upload_id 的日志器与链路信息
+func cleanupChunks(ctx context.Context, uploadID string) {
+	logger := ctxLogger(ctx)
+	_, span := tracer.Start(ctx, "cleanup_chunks", trace.WithAttributes(attribute.String("upload.id", uploadID)))
+	defer span.End()
+
+	tmpPath := filepath.Join(tmpDir, uploadID)
+	if err := os.RemoveAll(tmpPath); err != nil {
+		failSpan(span, err)
+		logger.Error("Cleanup chunks error", "err", err)
+	} else {
+		logger.Info("Cleaned up chunks")
 	}
-	writeJSON(w, http.StatusOK, healthInfo)
 }
 
 // main 主函数
 func main() {
+	// 子命令：数据库迁移、元数据导入导出
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrateCommand(os.Args[2:]))
+		case "metadata":
+			os.Exit(runMetadataCommand(os.Args[2:]))
+		}
+	}
+
+	// 加载配置：默认值 < 配置文件 < 环境变量 < 命令行参数
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fatal("Configuration error", err)
+	}
+	applyConfig(cfg)
+	initLogger(cfg.Log)
+	if err := initTracing(cfg.Tracing); err != nil {
+		fatal("Tracing initialization failed", err)
+	}
+
 	// 确保目录存在
 	_ = os.MkdirAll(tmpDir, 0755)
 	_ = os.MkdirAll(finalDir, 0755)
+	removeStaleParts(tmpDir, finalDir)
+
+	// 初始化签名密钥
+	if err := initSigningKey(cfg.Security.SigningKey); err != nil {
+		fatal("Signing key initialization failed", err)
+	}
+	if err := initKeyring(cfg.Security.MasterKeys, cfg.Security.Keyring); err != nil {
+		fatal("Master key initialization failed", err)
+	}
 
 	// 连接数据库
-	dsn := "root:root@tcp(127.0.0.1:3306)/filedb?parseTime=true"
-	if err := initDB(dsn); err != nil {
-		log.Fatal("Database initialization failed:", err)
+	if err := initDB(cfg.Database); err != nil {
+		fatal("Database initialization failed", err)
+	}
+	if err := migrateOnStartup(context.Background(), cfg.Database); err != nil {
+		fatal("Database migration failed", err)
+	}
+	if err := initLocker(cfg.Lock); err != nil {
+		fatal("Upload lock initialization failed", err)
+	}
+	if err := initCluster(context.Background()); err != nil {
+		fatal("Cluster registration failed", err)
 	}
 
+	// 启动Webhook投递协程
+	goBackground(runWebhookWorker)
+
+	// 启动文件后处理协程
+	if err := startProcessWorkers(); err != nil {
+		fatal("Processor initialization failed", err)
+	}
+	goBackground(recoverProcessing) // 重新入队上次退出时未完成的处理
+
+	// 启动副本复制与修复协程、完整性巡检协程、生命周期规则协程
+	startReplication()
+	startScrubber()
+	startLifecycle()
+
+	// 注册状态指标并启动目录占用统计
+	startMetrics()
+
 	// 初始化路由器
 	r := mux.NewRouter()
+	r.Use(otelmux.Middleware(cfg.Tracing.ServiceName, otelmux.WithFilter(func(r *http.Request) bool {
+		// 指标抓取与健康探针不生成链路
+		return r.URL.Path != "/metrics" && !strings.HasPrefix(r.URL.Path, "/api/v1/health")
+	}))) // 按路由模板生成请求span，沿用请求头中的 traceparent
+	r.Use(withTraceID)     // 日志附加 trace_id
+	r.Use(instrumentRoute) // 按路由模板记录HTTP指标
 
 	// API路由
 	api := r.PathPrefix("/api/v1").Subrouter()
 	
 	// 上传路由
 	uploads := api.PathPrefix("/uploads").Subrouter()
+	uploads.Use(rejectWhileDraining) // 停机期间拒绝新的上传写请求
 	uploads.HandleFunc("", CreateUpload).Methods("POST")
+	uploads.HandleFunc("/presign", CreatePresignedUpload).Methods("POST")
 	uploads.HandleFunc("/{upload_id}", GetUploadStatus).Methods("GET")
-	uploads.HandleFunc("/{upload_id}/complete", CompleteUpload).Methods("POST")
-	uploads.HandleFunc("/{upload_id}/chunks/{index}", UploadChunk).Methods("PUT", "POST")
+	uploads.HandleFunc("/{upload_id}/complete", withUploadOwner(CompleteUpload)).Methods("POST")
+	uploads.HandleFunc("/{upload_id}/chunks/{index}", withUploadOwner(UploadChunk)).Methods("PUT", "POST")
 
-	// 文件历史路由
+	// 文件历史路由（集群模式下涉及本地文件的请求由 withUploadOwner 转发给所属节点）
 	files := api.PathPrefix("/files").Subrouter()
 	files.HandleFunc("/history", GetFileHistory).Methods("GET")
 
@@ -1014,41 +1287,90 @@ func main() {
 	files.HandleFunc("/stats", GetFileStats).Methods("GET")
 	files.HandleFunc("/today-stats", GetTodayUploadStats).Methods("GET")
 	files.HandleFunc("/recent", GetRecentFiles).Methods("GET")
+	files.HandleFunc("/archive", DownloadZip).Methods("POST")
 
 	// 系统路由
-	api.HandleFunc("/health", HealthCheck).Methods("GET")
+	api.HandleFunc("/health", LivenessCheck).Methods("GET")
+	api.HandleFunc("/health/live", LivenessCheck).Methods("GET")
+	api.HandleFunc("/health/ready", ReadinessCheck).Methods("GET")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	files.HandleFunc("/{upload_id}", GetFileDetail).Methods("GET")
-
-	// 配置CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"}, // 允许所有源
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: true,
-		MaxAge:           86400, // 预检请求缓存时间
-	})
+	files.HandleFunc("/{upload_id}", withUploadOwner(DeleteFile)).Methods("DELETE")
+	files.HandleFunc("/{upload_id}/download", withUploadOwner(DownloadFile)).Methods("GET")
+	files.HandleFunc("/{upload_id}/thumbnail", withUploadOwner(GetThumbnail)).Methods("GET")
+	files.HandleFunc("/{upload_id}/archive/entries", withUploadOwner(ListArchiveEntries)).Methods("GET")
+	files.HandleFunc("/{upload_id}/archive/entry", withUploadOwner(DownloadArchiveEntry)).Methods("GET")
+	files.HandleFunc("/{upload_id}/archive/extract", withUploadOwner(ExtractArchive)).Methods("POST")
+	files.HandleFunc("/{upload_id}/process", withUploadOwner(RerunProcessor)).Methods("POST")
+	files.HandleFunc("/{upload_id}/process/{processor}", withUploadOwner(RerunProcessor)).Methods("POST")
+	files.HandleFunc("/{upload_id}/verify", withUploadOwner(VerifyFile)).Methods("POST")
+	files.HandleFunc("/{upload_id}/tags", UpdateFileTags).Methods("PUT")
+
+	// 分享链接路由
+	shares := api.PathPrefix("/shares").Subrouter()
+	shares.HandleFunc("", CreateShare).Methods("POST")
+	shares.HandleFunc("", ListShares).Methods("GET")
+	shares.HandleFunc("/{share_id}", RevokeShare).Methods("DELETE")
+
+	// Webhook路由
+	webhooks := api.PathPrefix("/webhooks").Subrouter()
+	webhooks.HandleFunc("", CreateWebhook).Methods("POST")
+	webhooks.HandleFunc("", ListWebhooks).Methods("GET")
+	webhooks.HandleFunc("/{webhook_id}", DeleteWebhook).Methods("DELETE")
+	webhooks.HandleFunc("/{webhook_id}/deliveries", ListWebhookDeliveries).Methods("GET")
+	webhooks.HandleFunc("/{webhook_id}/deliveries/{delivery_id}/redeliver", RedeliverWebhook).Methods("POST")
+
+	// 管理路由（需管理员）
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/config", GetConfig).Methods("GET")
+	admin.HandleFunc("/keys/rotate", RotateMasterKey).Methods("POST")
+	admin.HandleFunc("/cluster/nodes", ListClusterNodes).Methods("GET")
+	admin.HandleFunc("/replication", GetReplicationStatus).Methods("GET")
+	admin.HandleFunc("/replication/repair", RepairReplicas).Methods("POST")
+	admin.HandleFunc("/integrity", GetIntegrityReport).Methods("GET")
+	admin.HandleFunc("/lifecycle", GetLifecycleStatus).Methods("GET")
+	admin.HandleFunc("/lifecycle/plan", PlanLifecycle).Methods("GET")
+	admin.HandleFunc("/lifecycle/run", RunLifecycle).Methods("POST")
+
+	// 公开分享访问路由（无需登录）
+	r.HandleFunc("/s/{token}", ServeShare).Methods("GET")
+	r.HandleFunc("/s/{token}/files/{upload_id}", withUploadOwner(ServeShareFile)).Methods("GET")
+
+	// 配置CORS，未配置允许的源时不处理跨域请求（cors 库对空列表默认允许所有源）
+	handler := http.Handler(r)
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		handler = cors.New(cors.Options{
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   cfg.CORS.AllowedMethods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   []string{clientEncryptionHdr, requestIDHeader},
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           cfg.CORS.MaxAge, // 预检请求缓存时间
+		}).Handler(r)
+	}
 
 	// 启动服务器
 	srv := &http.Server{
-		Addr:         ":8080",
-		Handler:      c.Handler(r),
-		ReadTimeout:  30 * time.Minute, // 长超时以适应大文件上传
-		WriteTimeout: 30 * time.Minute,
-	}
-
-	log.Println("Server starting on :8080")
-	log.Println("Available endpoints:")
-	log.Println("  POST   /api/v1/uploads")
-	log.Println("  GET    /api/v1/uploads/{upload_id}")
-	log.Println("  POST   /api/v1/uploads/{upload_id}/complete")
-	log.Println("  PUT    /api/v1/uploads/{upload_id}/chunks/{index}")
-	log.Println("  GET    /api/v1/files/history")
-	log.Println("  GET    /api/v1/files/{upload_id}")
-	log.Println("  GET    /api/v1/files/stats")           // 新增
-	log.Println("  GET    /api/v1/files/today-stats")     // 新增
-	log.Println("  GET    /api/v1/files/recent")          // 新增
-	log.Println("  GET    /api/v1/health")
-
-	log.Fatal(srv.ListenAndServe())
+		Addr:         cfg.Server.Addr,
+		Handler:      trackInFlight(withRequestID(handler)),
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout), // 长超时以适应大文件上传
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout),
+		BaseContext:  func(net.Listener) context.Context { return serverCtx }, // 排空超时后取消进行中的请求
+		ErrorLog:     slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	slog.Info("Server starting", "addr", cfg.Server.Addr)
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if tpl, err := route.GetPathTemplate(); err == nil && route.GetHandler() != nil {
+			methods, _ := route.GetMethods()
+			slog.Debug("Route registered", "path", tpl, "methods", methods)
+		}
+		return nil
+	})
+
+	if err := runServer(srv, time.Duration(cfg.Server.ShutdownTimeout)); err != nil {
+		fatal("Server error", err)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 分享链接相关常量
+const (
+	ShareScopeDownload = "download" // 允许下载（附件形式）
+	ShareScopeView     = "view"     // 仅允许在线查看（内联形式）
+
+	DefaultShareTTL = 7 * 24 * time.Hour  // 默认有效期
+	MaxShareTTL     = 30 * 24 * time.Hour // 最长有效期
+
+	maxSharePasswordLength = 72 // 访问密码最大字节数（bcrypt 限制）
+
+	shareTokenPurpose = "share" // 分享令牌签名用途
+)
+
+// ShareRequest 创建分享链接请求
+type ShareRequest struct {
+	UploadID     string `json:"upload_id,omitempty"`     // 分享的文件ID（与folder二选一）
+	Folder       string `json:"folder,omitempty"`        // 分享的文件夹（与upload_id二选一）
+	ExpiresIn    int64  `json:"expires_in,omitempty"`    // 有效期（秒）
+	Password     string `json:"password,omitempty"`      // 访问密码（可选）
+	MaxDownloads int    `json:"max_downloads,omitempty"` // 最大下载次数（可选，0表示不限）
+	Scope        string `json:"scope,omitempty"`         // 权限范围：download/view
+}
+
+// ShareRecord 分享链接记录
+type ShareRecord struct {
+	ShareID       string    `json:"share_id"`                // 分享ID
+	UserID        string    `json:"user_id"`                 // 创建者
+	UploadID      string    `json:"upload_id,omitempty"`     // 分享的文件ID
+	Folder        string    `json:"folder,omitempty"`        // 分享的文件夹
+	Scope         string    `json:"scope"`                   // 权限范围
+	HasPassword   bool      `json:"has_password"`            // 是否设置密码
+	MaxDownloads  *int      `json:"max_downloads,omitempty"` // 最大下载次数
+	DownloadCount int       `json:"download_count"`          // 已下载次数
+	ExpiresAt     time.Time `json:"expires_at"`              // 过期时间
+	Revoked       bool      `json:"revoked"`                 // 是否已撤销
+	CreatedAt     time.Time `json:"created_at"`              // 创建时间
+	Token         string    `json:"token,omitempty"`         // 访问令牌
+	URL           string    `json:"url,omitempty"`           // 访问链接
+
+	passwordHash string // 密码哈希（不对外输出）
+}
+
+// CreateShare 创建分享链接
+// POST /api/v1/shares
+func CreateShare(w http.ResponseWriter, r *http.Request) {
+	logger := reqLogger(r)
+	userID := getUserID(r)
+	ctx := dbCtx(r)
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, "Login required")
+		return
+	}
+
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	folder, err := normalizeFolder(req.Folder)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid folder")
+		return
+	}
+	if (req.UploadID == "") == (folder == "") {
+		writeError(w, http.StatusBadRequest, "Exactly one of upload_id or folder is required")
+		return
+	}
+
+	if req.Scope == "" {
+		req.Scope = ShareScopeDownload
+	}
+	if req.Scope != ShareScopeDownload && req.Scope != ShareScopeView {
+		writeError(w, http.StatusBadRequest, "Invalid scope, must be download or view")
+		return
+	}
+	if req.MaxDownloads < 0 || req.ExpiresIn < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid max_downloads or expires_in")
+		return
+	}
+	if len(req.Password) > maxSharePasswordLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("password exceeds maximum of %d bytes", maxSharePasswordLength))
+		return
+	}
+
+	ttl := DefaultShareTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > MaxShareTTL {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("expires_in exceeds maximum of %d seconds", int64(MaxShareTTL/time.Second)))
+		return
+	}
+
+	// 校验分享的文件归属
+	if req.UploadID != "" {
+		file, err := getFileByUploadID(ctx, req.UploadID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusNotFound, "File not found")
+				return
+			}
+			logger.Error("Database query error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if file.UserID != userID {
+			writeError(w, http.StatusForbidden, "Not the owner of this file")
+			return
+		}
+		if file.Status != StatusCompleted {
+			writeError(w, http.StatusConflict, "File is not completed")
+			return
+		}
+	}
+
+	share := &ShareRecord{
+		ShareID:   uuid.New().String(),
+		UserID:    userID,
+		UploadID:  req.UploadID,
+		Folder:    folder,
+		Scope:     req.Scope,
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+	if req.MaxDownloads > 0 {
+		share.MaxDownloads = &req.MaxDownloads
+	}
+	if req.Password != "" {
+		share.passwordHash, err = hashSharePassword(req.Password)
+		if err != nil {
+			logger.Error("Hash share password error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Server error")
+			return
+		}
+		share.HasPassword = true
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO file_shares
+			(share_id, user_id, upload_id, folder, scope, password_hash, max_downloads, expires_at)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, NULLIF(?, ''), ?, ?)
+	`, share.ShareID, share.UserID, share.UploadID, share.Folder, share.Scope,
+		share.passwordHash, share.MaxDownloads, share.ExpiresAt)
+	if err != nil {
+		logger.Error("Database insert share error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create share")
+		return
+	}
+
+	share.Token = makeShareToken(share.ShareID, share.ExpiresAt)
+	share.URL = requestBaseURL(r) + "/s/" + share.Token
+	writeJSON(w, http.StatusCreated, share)
+}
+
+// ListShares 列出当前用户的分享链接
+// GET /api/v1/shares
+func ListShares(w http.ResponseWriter, r *http.Request) {
+	logger := reqLogger(r)
+	userID := getUserID(r)
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, "Login required")
+		return
+	}
+
+	rows, err := db.QueryContext(dbCtx(r), `
+		SELECT `+shareColumns+`
+		FROM file_shares
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		logger.Error("Database query shares error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	shares := []*ShareRecord{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			logger.Error("Scan share error", "err", err)
+			continue
+		}
+		// 仅对仍有效的分享返回访问链接
+		if !share.Revoked && time.Now().Before(share.ExpiresAt) {
+			share.Token = makeShareToken(share.ShareID, share.ExpiresAt)
+			share.URL = requestBaseURL(r) + "/s/" + share.Token
+		}
+		shares = append(shares, share)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("Rows error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": shares,
+	})
+}
+
+// RevokeShare 撤销分享链接
+// DELETE /api/v1/shares/{share_id}
+func RevokeShare(w http.ResponseWriter, r *http.Request) {
+	ctx := dbCtx(r)
+	userID := getUserID(r)
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, "Login required")
+		return
+	}
+	shareID := mux.Vars(r)["share_id"]
+
+	res, err := db.ExecContext(ctx, "UPDATE file_shares SET revoked = TRUE WHERE share_id = ? AND user_id = ?", shareID, userID)
+	if err != nil {
+		reqLogger(r).Error("Database revoke share error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// MySQL 对未变化的行返回0，需区分"不存在"与"已撤销"
+		var exists int
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM file_shares WHERE share_id = ? AND user_id = ?", shareID, userID).Scan(&exists)
+		if err != nil || exists == 0 {
+			writeError(w, http.StatusNotFound, "Share not found")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"share_id": shareID,
+		"revoked":  true,
+	})
+}
+
+// ServeShare 通过分享令牌访问文件或文件夹（无需登录）
+// GET /s/{token}
+func ServeShare(w http.ResponseWriter, r *http.Request) {
+	ctx := dbCtx(r)
+	share, ok := loadShareFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if share.UploadID != "" {
+		file, err := getFileByUploadID(ctx, share.UploadID)
+		if err != nil {
+			writeShareFileError(w, r, err)
+			return
+		}
+		// 集群模式下由文件所属节点计数并输出
+		if forwardToOwner(w, r, file) {
+			return
+		}
+		serveSharedFile(w, r, share, file)
+		return
+	}
+
+	// 文件夹分享：返回文件夹内已完成文件列表
+	files, err := uploadStore.ListFolderFiles(ctx, share.UserID, share.Folder)
+	if err != nil {
+		reqLogger(r).Error("Database query folder files error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"folder":     share.Folder,
+		"scope":      share.Scope,
+		"expires_at": share.ExpiresAt,
+		"files":      files,
+	})
+}
+
+// ServeShareFile 下载文件夹分享中的单个文件
+// GET /s/{token}/files/{upload_id}
+func ServeShareFile(w http.ResponseWriter, r *http.Request) {
+	share, ok := loadShareFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if share.Folder == "" {
+		writeError(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	file, err := getFileByUploadID(dbCtx(r), mux.Vars(r)["upload_id"])
+	if err != nil {
+		writeShareFileError(w, r, err)
+		return
+	}
+	if file.UserID != share.UserID || !folderContains(share.Folder, file.Folder) {
+		writeError(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	serveSharedFile(w, r, share, file)
+}
+
+// 辅助函数
+
+// shareColumns 分享记录查询列，与 scanShare 顺序一致
+const shareColumns = `share_id, user_id, COALESCE(upload_id, ''), COALESCE(folder, ''), scope,
+	COALESCE(password_hash, ''), max_downloads, download_count, expires_at, revoked, created_at`
+
+// rowScanner 兼容 *sql.Row 与 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanShare 扫描一条分享记录
+func scanShare(row rowScanner) (*ShareRecord, error) {
+	share := &ShareRecord{}
+	var maxDownloads sql.NullInt64
+	err := row.Scan(
+		&share.ShareID, &share.UserID, &share.UploadID, &share.Folder, &share.Scope,
+		&share.passwordHash, &maxDownloads, &share.DownloadCount, &share.ExpiresAt, &share.Revoked, &share.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if maxDownloads.Valid {
+		n := int(maxDownloads.Int64)
+		share.MaxDownloads = &n
+	}
+	share.HasPassword = share.passwordHash != ""
+	return share, nil
+}
+
+// makeShareToken 生成分享令牌：<share_id>.<过期时间戳>.<签名>
+func makeShareToken(shareID string, expiresAt time.Time) string {
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	return shareID + "." + exp + "." + signValue(shareTokenPurpose, shareID, exp)
+}
+
+// parseShareToken 校验分享令牌签名与有效期，返回分享ID
+func parseShareToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+	shareID, exp, sig := parts[0], parts[1], parts[2]
+	if !verifySignature(sig, shareTokenPurpose, shareID, exp) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed expiry")
+	}
+	if time.Now().Unix() > expUnix {
+		return "", errShareExpired
+	}
+	return shareID, nil
+}
+
+var errShareExpired = fmt.Errorf("share expired")
+
+// loadShareFromRequest 解析令牌并加载分享记录，校验撤销状态与访问密码
+// 校验失败时已写入错误响应并返回false
+func loadShareFromRequest(w http.ResponseWriter, r *http.Request) (*ShareRecord, bool) {
+	shareID, err := parseShareToken(mux.Vars(r)["token"])
+	if err != nil {
+		if err == errShareExpired {
+			writeError(w, http.StatusGone, "Share link expired")
+			return nil, false
+		}
+		writeError(w, http.StatusNotFound, "Share link not found")
+		return nil, false
+	}
+
+	share, err := scanShare(db.QueryRowContext(dbCtx(r), "SELECT "+shareColumns+" FROM file_shares WHERE share_id = ?", shareID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Share link not found")
+			return nil, false
+		}
+		reqLogger(r).Error("Database query share error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+
+	if share.Revoked {
+		writeError(w, http.StatusGone, "Share link revoked")
+		return nil, false
+	}
+	if time.Now().After(share.ExpiresAt) {
+		writeError(w, http.StatusGone, "Share link expired")
+		return nil, false
+	}
+	if share.HasPassword {
+		// 密码只从请求头读取，避免出现在访问日志、代理与 Referer 中
+		password := r.Header.Get("X-Share-Password")
+		if !checkSharePassword(share.passwordHash, password) {
+			writeError(w, http.StatusUnauthorized, "Share password required or incorrect")
+			return nil, false
+		}
+	}
+
+	return share, true
+}
+
+// serveSharedFile 计数并输出分享文件
+// 每个会输出文件内容的请求（含任意 Range 请求）都计为一次下载，避免通过分段请求绕过次数限制；
+// 文件不可下载或 Range 无法满足时不计数
+func serveSharedFile(w http.ResponseWriter, r *http.Request, share *ShareRecord, file *FileRecord) {
+	logger := reqLogger(r).With("upload_id", file.UploadID)
+	if !checkFileAccessible(w, file) {
+		return
+	}
+
+	if rangeServesBytes(r.Header.Get("Range"), file.FileSize) {
+		ok, err := consumeShareDownload(dbCtx(r), share)
+		if err != nil {
+			logger.Error("Database update share download count error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusGone, "Share download limit reached")
+			return
+		}
+	}
+
+	serveStoredFile(w, r, file, share.Scope == ShareScopeView)
+}
+
+// rangeServesBytes 判断请求是否会输出文件内容：无 Range 时输出全部内容；
+// Range 格式错误或全部区间超出文件大小时 http.ServeContent 返回 416，不输出内容
+func rangeServesBytes(rng string, size int64) bool {
+	if rng == "" {
+		return true
+	}
+	specs, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok {
+		return false
+	}
+	satisfiable := false
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(spec, "-")
+		if !ok {
+			return false
+		}
+		start, end = strings.TrimSpace(start), strings.TrimSpace(end)
+		if start == "" {
+			// 后缀区间 bytes=-n：最后 n 个字节
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return false
+			}
+			if n > 0 && size > 0 {
+				satisfiable = true
+			}
+			continue
+		}
+		i, err := strconv.ParseInt(start, 10, 64)
+		if err != nil || i < 0 {
+			return false
+		}
+		if end != "" {
+			j, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || j < i {
+				return false
+			}
+		}
+		if i < size {
+			satisfiable = true
+		}
+	}
+	return satisfiable
+}
+
+// consumeShareDownload 原子地增加下载次数，超过上限时返回false
+func consumeShareDownload(ctx context.Context, share *ShareRecord) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE file_shares SET download_count = download_count + 1
+		WHERE share_id = ? AND revoked = FALSE AND (max_downloads IS NULL OR download_count < max_downloads)
+	`, share.ShareID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// writeShareFileError 输出分享文件查询错误
+func writeShareFileError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	reqLogger(r).Error("Database query error", "err", err)
+	writeError(w, http.StatusInternalServerError, "Database error")
+}
+
+// folderContains 判断 child 是否为 parent 本身或其子文件夹
+func folderContains(parent, child string) bool {
+	return child == parent || strings.HasPrefix(child, parent+"/")
+}
+
+// hashSharePassword 使用 bcrypt 生成密码哈希
+func hashSharePassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkSharePassword 校验分享密码
+func checkSharePassword(stored, password string) bool {
+	if password == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// shareRouter 分享相关路由
+func shareRouter() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/shares", CreateShare).Methods("POST")
+	r.HandleFunc("/api/v1/shares/{share_id}", RevokeShare).Methods("DELETE")
+	r.HandleFunc("/s/{token}", ServeShare).Methods("GET")
+	r.HandleFunc("/s/{token}/files/{upload_id}", ServeShareFile).Methods("GET")
+	return r
+}
+
+// createTestShare 以 alice 身份创建分享链接
+func createTestShare(t *testing.T, router http.Handler, req ShareRequest) *ShareRecord {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest("POST", "/api/v1/shares", bytes.NewReader(body))
+	r.Header.Set("X-User-ID", "alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create share: %d %s", w.Code, w.Body.String())
+	}
+	var share ShareRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &share); err != nil {
+		t.Fatal(err)
+	}
+	return &share
+}
+
+// getShare 访问分享链接，header 为附加的请求头
+func getShare(router http.Handler, path string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestShareToken(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	token := makeShareToken("s1", exp)
+	parts := strings.Split(token, ".")
+	later := strconv.FormatInt(exp.Add(24*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", token, false},
+		{"tampered share id", "s2." + parts[1] + "." + parts[2], true},
+		{"extended expiry", parts[0] + "." + later + "." + parts[2], true},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])), true},
+		{"missing signature", parts[0] + "." + parts[1], true},
+		{"extra part", token + ".x", true},
+		{"empty", "", true},
+		{"expired", makeShareToken("s1", time.Now().Add(-time.Minute)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := parseShareToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseShareToken() = %q, %v; wantErr %v", id, err, tt.wantErr)
+			}
+			if err == nil && id != "s1" {
+				t.Fatalf("share id = %q, want s1", id)
+			}
+		})
+	}
+	if _, err := parseShareToken(makeShareToken("s1", time.Now().Add(-time.Minute))); err != errShareExpired {
+		t.Fatalf("expired token: err = %v, want errShareExpired", err)
+	}
+}
+
+func TestShareAccess(t *testing.T) {
+	setupTestDB(t)
+	router := shareRouter()
+	content := []byte("shared file content")
+	storeTestFile(t, "u1", content)
+
+	share := createTestShare(t, router, ShareRequest{UploadID: "u1", Password: "s3cret"})
+	if share.Token == "" || !strings.HasSuffix(share.URL, "/s/"+share.Token) {
+		t.Fatalf("share token/url = %q %q", share.Token, share.URL)
+	}
+	path := "/s/" + share.Token
+	parts := strings.Split(share.Token, ".")
+	forgedSig := "/s/" + parts[0] + "." + parts[1] + "." + tamperSignature(parts[2])
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		want   int
+	}{
+		{"no password", path, nil, http.StatusUnauthorized},
+		{"wrong password", path, map[string]string{"X-Share-Password": "guess"}, http.StatusUnauthorized},
+		{"password in query", path + "?password=s3cret", nil, http.StatusUnauthorized},
+		{"correct password", path, map[string]string{"X-Share-Password": "s3cret"}, http.StatusOK},
+		{"forged token", "/s/" + makeShareToken("00000000-0000-0000-0000-000000000000", share.ExpiresAt), nil, http.StatusNotFound},
+		{"bad signature", forgedSig, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := getShare(router, tt.path, tt.header)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.want)
+			}
+			if w.Code == http.StatusOK && !bytes.Equal(w.Body.Bytes(), content) {
+				t.Fatalf("body = %q", w.Body.String())
+			}
+		})
+	}
+
+	// 令牌签名有效但记录已过期
+	if _, err := db.Exec("UPDATE file_shares SET expires_at = ? WHERE share_id = ?", time.Now().Add(-time.Minute), share.ShareID); err != nil {
+		t.Fatal(err)
+	}
+	if w := getShare(router, path, map[string]string{"X-Share-Password": "s3cret"}); w.Code != http.StatusGone {
+		t.Fatalf("expired share: status %d, want 410", w.Code)
+	}
+
+	// 撤销后不可访问
+	open := createTestShare(t, router, ShareRequest{UploadID: "u1"})
+	r := httptest.NewRequest("DELETE", "/api/v1/shares/"+open.ShareID, nil)
+	r.Header.Set("X-User-ID", "alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body.String())
+	}
+	if w := getShare(router, "/s/"+open.Token, nil); w.Code != http.StatusGone {
+		t.Fatalf("revoked share: status %d, want 410", w.Code)
+	}
+}
+
+func TestShareDownloadLimit(t *testing.T) {
+	setupTestDB(t)
+	router := shareRouter()
+	content := []byte("0123456789abcdefghij")
+	storeTestFile(t, "u1", content)
+
+	// 每个输出内容的请求都计数，分段与后缀区间不能绕过次数限制
+	share := createTestShare(t, router, ShareRequest{UploadID: "u1", MaxDownloads: 3})
+	path := "/s/" + share.Token
+	steps := []struct {
+		rng  string
+		want int
+	}{
+		{"bytes=1000-", http.StatusRequestedRangeNotSatisfiable}, // 不输出内容，不计数
+		{"bytes=-5", http.StatusPartialContent},
+		{"bytes=0-9", http.StatusPartialContent},
+		{"bytes=10-", http.StatusPartialContent},
+		{"bytes=15-19", http.StatusGone},
+		{"", http.StatusGone},
+	}
+	for i, s := range steps {
+		w := getShare(router, path, map[string]string{"Range": s.rng})
+		if w.Code != s.want {
+			t.Fatalf("step %d (Range %q): status %d, want %d", i, s.rng, w.Code, s.want)
+		}
+	}
+	var count int
+	if err := db.QueryRow("SELECT download_count FROM file_shares WHERE share_id = ?", share.ShareID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("download_count = %d, want 3", count)
+	}
+
+	// 扫描中的文件不可下载，也不消耗次数
+	storeTestFile(t, "u2", content)
+	limited := createTestShare(t, router, ShareRequest{UploadID: "u2", MaxDownloads: 1})
+	if _, err := db.Exec("UPDATE uploads SET scan_status = ? WHERE upload_id = ?", ScanScanning, "u2"); err != nil {
+		t.Fatal(err)
+	}
+	if w := getShare(router, "/s/"+limited.Token, nil); w.Code != http.StatusConflict {
+		t.Fatalf("scanning file: status %d, want 409", w.Code)
+	}
+	if _, err := db.Exec("UPDATE uploads SET scan_status = ? WHERE upload_id = ?", ScanClean, "u2"); err != nil {
+		t.Fatal(err)
+	}
+	if w := getShare(router, "/s/"+limited.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("after scan: status %d, want 200", w.Code)
+	}
+}
+
+func TestShareFolderFile(t *testing.T) {
+	setupTestDB(t)
+	router := shareRouter()
+	storeTestFile(t, "u1", []byte("in folder"))
+	storeTestFile(t, "u2", []byte("outside"))
+	if _, err := db.Exec("UPDATE uploads SET folder = ? WHERE upload_id = ?", "docs/a", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE uploads SET folder = ? WHERE upload_id = ?", "docs-private", "u2"); err != nil {
+		t.Fatal(err)
+	}
+
+	share := createTestShare(t, router, ShareRequest{Folder: "docs"})
+	if w := getShare(router, "/s/"+share.Token+"/files/u1", nil); w.Code != http.StatusOK {
+		t.Fatalf("file in shared folder: status %d", w.Code)
+	}
+	// 前缀相同的其他文件夹不在分享范围内
+	if w := getShare(router, "/s/"+share.Token+"/files/u2", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("file outside shared folder: status %d, want 404", w.Code)
+	}
+}
+
+func TestRangeServesBytes(t *testing.T) {
+	tests := []struct {
+		rng  string
+		size int64
+		want bool
+	}{
+		{"", 100, true},
+		{"bytes=0-", 100, true},
+		{"bytes=0-0", 100, true},
+		{"bytes=1-", 100, true},
+		{"bytes=-1", 100, true},
+		{"bytes=-100", 100, true},
+		{"bytes=-1000", 100, true},
+		{"bytes=0-49, 50-99", 100, true},
+		{"bytes=200-, 10-20", 100, true},
+		{"bytes=99-", 100, true},
+		{"bytes=100-", 100, false},
+		{"bytes=-0", 100, false},
+		{"bytes=-1", 0, false},
+		{"bytes=0-", 0, false},
+		{"bytes=5-1", 100, false},
+		{"bytes=a-b", 100, false},
+		{"bytes=5", 100, false},
+		{"bytes=", 100, false},
+		{"items=0-10", 100, false},
+	}
+	for _, tt := range tests {
+		if got := rangeServesBytes(tt.rng, tt.size); got != tt.want {
+			t.Errorf("rangeServesBytes(%q, %d) = %v, want %v", tt.rng, tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 优雅停机：收到 SIGINT/SIGTERM 后停止接受新的上传写请求，等待进行中的分片写入与合并
+// 在 server.shutdown_timeout 内完成；超时后取消剩余请求（删除未完成的 .part 文件，
+// 上传任务保持 in_progress 以便客户端重试），随后停止后台协程并关闭数据库。
+const (
+	shutdownGrace = 10 * time.Second // 取消请求或停止后台协程后等待其退出的时间
+	stalePartAge  = time.Hour        // 启动时清理早于该时间的残留 .part 文件
+)
+
+var errShutdown = errors.New("interrupted by shutdown")
+
+var (
+	serverCtx, cancelServer         = context.WithCancel(context.Background()) // 请求与合并的基础上下文，排空超时后取消
+	backgroundCtx, cancelBackground = context.WithCancel(context.Background()) // 后台协程上下文，服务器停止后取消
+
+	draining   atomic.Bool    // 是否正在停机
+	inflight   sync.WaitGroup // 进行中的HTTP请求
+	background sync.WaitGroup // 后台协程
+)
+
+// goBackground 启动受停机流程跟踪的后台协程
+func goBackground(fn func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		fn()
+	}()
+}
+
+// shutdownContext 保留 parent 中的日志器与链路信息，但只在排空超时（serverCtx 取消）时取消
+func shutdownContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	stop := context.AfterFunc(serverCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// trackInFlight 记录进行中的请求，排空超时后等待被取消的请求完成清理
+func trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight.Add(1)
+		defer inflight.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectWhileDraining 停机期间拒绝新的上传写请求（查询仍然允许）
+func rejectWhileDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() && r.Method != http.MethodGet {
+			w.Header().Set("Retry-After", "30")
+			writeError(w, http.StatusServiceUnavailable, "Server is shutting down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runServer 启动服务器并在收到停机信号后按顺序停止
+func runServer(srv *http.Server, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	stop() // 再次收到信号时直接退出
+
+	slog.Info("Shutting down, draining in-flight requests", "timeout", timeout)
+	draining.Store(true)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("Drain timed out, cancelling remaining requests", "err", err)
+		cancelServer()
+		srv.Close()
+		if !waitTimeout(&inflight, shutdownGrace) {
+			slog.Warn("Some requests did not finish cleanup in time")
+		}
+	}
+
+	// 停止Webhook投递与文件后处理协程
+	cancelBackground()
+	if !waitTimeout(&background, shutdownGrace) {
+		slog.Warn("Background workers did not stop in time")
+	}
+	abandonQueuedProcessing()
+
+	// 导出剩余的 span
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("Flush traces error", "err", err)
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("Close database error", "err", err)
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+// waitTimeout 等待WaitGroup完成，超时返回false
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+// removeStaleParts 清理上次异常退出残留的 .part 文件
+// 仅删除较旧的文件，避免误删其他实例正在写入的文件
+func removeStaleParts(dirs ...string) {
+	cutoff := time.Now().Add(-stalePartAge)
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".part") {
+				return nil
+			}
+			if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+				if err := os.Remove(path); err == nil {
+					slog.Info("Removed stale partial file", "path", path)
+				}
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 存储层：上传任务、分片与统计的数据库访问集中在 UploadStore 中，按 database.driver 选择
+// MySQL、PostgreSQL 或 SQLite 实现。三种实现共用 sqlStore 中的标准 SQL，仅分片 upsert
+// 各自实现；行锁、LIKE 转义等差异由 sqlDialect 描述。分享、Webhook 等模块仍直接使用全局 db，
+// 语句统一使用 ? 占位符，PostgreSQL 下由驱动包装层改写为 $n。
+
+// UploadStore 上传任务、分片与统计的存储接口
+type UploadStore interface {
+	// CreateUpload 写入上传任务记录，clientEncryption 为客户端加密参数的JSON
+	CreateUpload(ctx context.Context, file *FileRecord, clientEncryption []byte) error
+	// GetUpload 获取上传任务，不存在时返回 sql.ErrNoRows
+	GetUpload(ctx context.Context, uploadID string) (*FileRecord, error)
+	// CompleteUpload 将任务标记为已完成，scanStatus 为空表示不扫描，contentMD5 为文件内容的MD5
+	CompleteUpload(ctx context.Context, uploadID, scanStatus string, storedSize int64, contentMD5 string) error
+	// DeleteUpload 删除任务，分片与分享记录级联删除
+	DeleteUpload(ctx context.Context, uploadID string) error
+
+	// SaveChunk 写入或覆盖分片元数据
+	SaveChunk(ctx context.Context, uploadID string, index int, size int64, md5 string) error
+	// ListChunks 已接收的分片序号（升序）
+	ListChunks(ctx context.Context, uploadID string) ([]int, error)
+
+	// ListFolderFiles 用户文件夹（含子文件夹）下已完成的文件
+	ListFolderFiles(ctx context.Context, userID, folder string) ([]*FileRecord, error)
+	// FileHistory 分页查询上传历史，返回当前页记录与总数
+	FileHistory(ctx context.Context, q *FileHistoryQuery) ([]*FileRecord, int, error)
+	// RecentFiles 最近创建的上传任务
+	RecentFiles(ctx context.Context, limit int) ([]*RecentFile, error)
+
+	// FileStats 文件数量与大小统计（不含派生的比率）
+	FileStats(ctx context.Context) (*FileStatsResponse, error)
+	// TodayStats 今日完成上传的数量与大小
+	TodayStats(ctx context.Context) (*TodayUploadStatsResponse, error)
+	// CountByStatus 指定状态的任务数
+	CountByStatus(ctx context.Context, status string) (int64, error)
+}
+
+// sqlDialect 数据库方言
+type sqlDialect struct {
+	system      string // OpenTelemetry db.system 取值
+	dollarArgs  bool   // 占位符为 $1、$2…（PostgreSQL）
+	forUpdate   string // 行锁子句，SQLite 写事务本身串行，无需行锁
+	likeEscape  string // LIKE 转义子句，SQLite 没有默认转义符
+	keywordLike string // 文件名关键字匹配运算符，与 MySQL 默认排序规则一样不区分大小写
+	utcTimes    bool   // 时间参数转为 UTC，与 CURRENT_TIMESTAMP 写入的值可直接比较
+	tryLock     string // 尝试获取会话级命名锁的查询，返回是否成功；为空表示不支持
+	unlock      string // 释放会话级命名锁
+}
+
+// dialect 当前数据库方言
+var dialect = mysqlDialect
+
+// rebind 将 ? 占位符改写为方言的形式，跳过字符串与标识符中的问号
+func (d *sqlDialect) rebind(query string) string {
+	if !d.dollarArgs || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// storeDriver 数据库驱动：方言、连接器与上传存储实现
+type storeDriver struct {
+	dialect    *sqlDialect
+	defaultDSN string                                     // database.dsn 为空时使用
+	connector  func(dsn string) (driver.Connector, error) // 解析DSN并创建连接器（不建立连接）
+	redactDSN  func(dsn string) string                    // 隐藏DSN中的密码
+	newStore   func(base sqlStore) UploadStore
+}
+
+// storeDrivers 支持的数据库驱动
+var storeDrivers = map[string]*storeDriver{
+	"mysql":    mysqlDriver,
+	"postgres": postgresDriver,
+	"sqlite":   sqliteDriver,
+}
+
+// dsnConnector 将只实现 driver.Driver 的驱动适配为连接器
+type dsnConnector struct {
+	dsn string
+	drv driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.drv.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.drv }
+
+var uploadStore UploadStore // 上传存储
+
+// sqlStore 各数据库共用的标准 SQL 实现
+type sqlStore struct {
+	db *sql.DB
+	d  *sqlDialect
+}
+
+// fileColumns 完整文件记录的查询列，与 scanFileRecord 对应
+const fileColumns = `upload_id, file_name, total_size, chunk_size, total_chunks,
+	status, created_at, updated_at, COALESCE(user_id, ''), folder, COALESCE(scan_status, ''),
+	COALESCE(compression, ''), COALESCE(stored_size, total_size), COALESCE(key_id, ''), wrapped_key,
+	client_encryption, COALESCE(owner_node, ''), COALESCE(content_md5, ''), COALESCE(integrity_status, ''), verified_at,
+	COALESCE(tier, ''), COALESCE(tags, ''), last_accessed_at, presigned`
+
+// scanFileRecord 扫描 fileColumns 对应的一行
+func scanFileRecord(row interface{ Scan(...interface{}) error }) (*FileRecord, error) {
+	file := &FileRecord{}
+	var clientEncryption []byte
+	var tags string
+	err := row.Scan(
+		&file.UploadID, &file.FileName, &file.FileSize, &file.ChunkSize, &file.TotalChunks,
+		&file.Status, &file.CreatedAt, &file.UpdatedAt, &file.UserID, &file.Folder, &file.ScanStatus,
+		&file.Compression, &file.StoredSize, &file.KeyID, &file.WrappedKey,
+		&clientEncryption, &file.OwnerNode, &file.ContentMD5, &file.IntegrityStatus, &file.VerifiedAt,
+		&file.Tier, &tags, &file.LastAccessedAt, &file.Presigned,
+	)
+	if err != nil {
+		return nil, err
+	}
+	file.Encrypted = file.KeyID != ""
+	file.Tags = splitTags(tags)
+	if file.Tier == "" {
+		file.Tier = TierStandard
+	}
+	if err := parseClientEncryption(file, clientEncryption); err != nil {
+		return nil, err
+	}
+	// 为已完成文件设置完成时间
+	if file.Status == StatusCompleted {
+		file.CompletedAt = &file.UpdatedAt
+	}
+	return file, nil
+}
+
+func (s sqlStore) CreateUpload(ctx context.Context, file *FileRecord, clientEncryption []byte) error {
+	// 未知的存储大小记为 NULL，查询时按原始大小计
+	storedSize := sql.NullInt64{Int64: file.StoredSize, Valid: file.StoredSize > 0}
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO uploads (upload_id, file_name, total_size, chunk_size, total_chunks, status, user_id, folder, scan_status, compression, stored_size, key_id, wrapped_key, client_encryption, owner_node, content_md5, tags, presigned) VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), ?, NULLIF(?, ''), ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?)",
+		file.UploadID, file.FileName, file.FileSize, file.ChunkSize, file.TotalChunks, file.Status, file.UserID, file.Folder,
+		file.ScanStatus, file.Compression, storedSize, file.KeyID, file.WrappedKey, clientEncryption, file.OwnerNode, file.ContentMD5, joinTags(file.Tags), file.Presigned,
+	)
+	return err
+}
+
+func (s sqlStore) GetUpload(ctx context.Context, uploadID string) (*FileRecord, error) {
+	return scanFileRecord(s.db.QueryRowContext(ctx, "SELECT "+fileColumns+" FROM uploads WHERE upload_id = ?", uploadID))
+}
+
+func (s sqlStore) CompleteUpload(ctx context.Context, uploadID, scanStatus string, storedSize int64, contentMD5 string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE uploads SET status = ?, scan_status = NULLIF(?, ''), stored_size = ?, content_md5 = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP WHERE upload_id = ?",
+		StatusCompleted, scanStatus, storedSize, contentMD5, uploadID,
+	)
+	return err
+}
+
+func (s sqlStore) DeleteUpload(ctx context.Context, uploadID string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM uploads WHERE upload_id = ?", uploadID)
+	return err
+}
+
+func (s sqlStore) ListChunks(ctx context.Context, uploadID string) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT chunk_index FROM upload_chunks WHERE upload_id = ? ORDER BY chunk_index", uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []int
+	for rows.Next() {
+		var idx int
+		if err := rows.Scan(&idx); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, idx)
+	}
+	return chunks, rows.Err()
+}
+
+func (s sqlStore) ListFolderFiles(ctx context.Context, userID, folder string) ([]*FileRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+fileColumns+`
+		FROM uploads
+		WHERE user_id = ? AND status = ? AND (folder = ? OR folder LIKE ?`+s.d.likeEscape+`)
+		ORDER BY folder, file_name
+	`, userID, StatusCompleted, folder, escapeLike(folder)+"/%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []*FileRecord{}
+	for rows.Next() {
+		file, err := scanFileRecord(rows)
+		if err != nil {
+			ctxLogger(ctx).Error("Scan folder file error", "folder", folder, "err", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+func (s sqlStore) FileHistory(ctx context.Context, q *FileHistoryQuery) ([]*FileRecord, int, error) {
+	logger := ctxLogger(ctx)
+
+	// 构建WHERE条件
+	whereClause := "WHERE 1=1"
+	args := []interface{}{}
+
+	// 添加过滤器
+	if q.Status != "" {
+		whereClause += " AND status = ?"
+		args = append(args, q.Status)
+	}
+	if q.Keyword != "" {
+		whereClause += " AND file_name " + s.d.keywordLike + " ?"
+		args = append(args, "%"+q.Keyword+"%")
+	}
+
+	// 构建排序
+	allowedSortFields := map[string]bool{
+		"created_at":   true,
+		"updated_at":   true,
+		"file_name":    true,
+		"total_size":   true,
+		"total_chunks": true,
+	}
+	sortBy := q.SortBy
+	if !allowedSortFields[sortBy] {
+		sortBy = "created_at"
+	}
+	order := strings.ToUpper(q.Order)
+	if order != "ASC" && order != "DESC" {
+		order = "DESC"
+	}
+
+	// 获取总数
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM uploads %s", whereClause)
+	logger.Debug("History count query", "query", countQuery, "args", args)
+	var total int
+	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return []*FileRecord{}, 0, nil
+	}
+
+	// 数据查询（追加分页参数）
+	selectQuery := fmt.Sprintf(`
+		SELECT
+			upload_id, file_name, total_size, chunk_size, total_chunks,
+			status, created_at, updated_at
+		FROM uploads
+		%s
+		ORDER BY %s %s
+		LIMIT ? OFFSET ?
+	`, whereClause, sortBy, order)
+	args = append(args, q.PerPage, (q.Page-1)*q.PerPage)
+	logger.Debug("History select query", "query", selectQuery, "args", args)
+
+	rows, err := s.db.QueryContext(ctx, selectQuery, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var files []*FileRecord
+	for rows.Next() {
+		file := &FileRecord{}
+		err := rows.Scan(
+			&file.UploadID, &file.FileName, &file.FileSize, &file.ChunkSize, &file.TotalChunks,
+			&file.Status, &file.CreatedAt, &file.UpdatedAt,
+		)
+		if err != nil {
+			logger.Error("Database scan error", "err", err)
+			continue
+		}
+		// 为已完成文件设置完成时间
+		if file.Status == StatusCompleted {
+			file.CompletedAt = &file.UpdatedAt
+		}
+		files = append(files, file)
+	}
+	return files, total, rows.Err()
+}
+
+func (s sqlStore) RecentFiles(ctx context.Context, limit int) ([]*RecentFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			upload_id, file_name, total_size, status, created_at, updated_at
+		FROM uploads
+		ORDER BY created_at DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*RecentFile
+	for rows.Next() {
+		file := &RecentFile{}
+		err := rows.Scan(&file.UploadID, &file.FileName, &file.FileSize, &file.Status, &file.CreatedAt, &file.UpdatedAt)
+		if err != nil {
+			ctxLogger(ctx).Error("Scan recent file error", "err", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+func (s sqlStore) FileStats(ctx context.Context) (*FileStatsResponse, error) {
+	stats := &FileStatsResponse{}
+	today := time.Now().Format("2006-01-02")
+	// SUM 在 MySQL/PostgreSQL 中返回 DECIMAL/NUMERIC，扫描时转换为整数
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(CASE WHEN status = ? THEN 1 END),
+			COALESCE(SUM(CASE WHEN status = ? THEN total_size END), 0),
+			COALESCE(SUM(CASE WHEN status = ? THEN COALESCE(stored_size, total_size) END), 0),
+			COUNT(CASE WHEN DATE(created_at) = ? THEN 1 END)
+		FROM uploads
+	`, StatusCompleted, StatusCompleted, StatusCompleted, today).Scan(
+		&stats.TotalCount, &stats.CompletedCount, &stats.TotalSize, &stats.StoredSize, &stats.TodayUploadCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s sqlStore) TodayStats(ctx context.Context) (*TodayUploadStatsResponse, error) {
+	stats := &TodayUploadStatsResponse{}
+	today := time.Now().Format("2006-01-02")
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) as count,
+			COALESCE(SUM(total_size), 0) as total_size
+		FROM uploads
+		WHERE DATE(created_at) = ? AND status = ?
+	`, today, StatusCompleted).Scan(&stats.Count, &stats.TotalSize)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s sqlStore) CountByStatus(ctx context.Context, status string) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM uploads WHERE status = ?", status).Scan(&n)
+	return n, err
+}
+
+// onConflictChunkUpsert PostgreSQL 与 SQLite 的分片元数据 upsert
+const onConflictChunkUpsert = `
+	INSERT INTO upload_chunks (upload_id, chunk_index, chunk_size, chunk_md5)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (upload_id, chunk_index) DO UPDATE SET
+		chunk_size = excluded.chunk_size,
+		chunk_md5 = excluded.chunk_md5,
+		received_at = CURRENT_TIMESTAMP
+`
+
+// escapeLike 转义 LIKE 通配符（转义符为反斜杠）
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlDialect MySQL 方言
+var mysqlDialect = &sqlDialect{
+	system:      "mysql",
+	forUpdate:   " FOR UPDATE",
+	keywordLike: "LIKE",
+	tryLock:     "SELECT GET_LOCK(?, 0)",
+	unlock:      "SELECT RELEASE_LOCK(?)",
+}
+
+// mysqlDriver MySQL 驱动（DSN 需包含 parseTime=true）
+var mysqlDriver = &storeDriver{
+	dialect:    mysqlDialect,
+	defaultDSN: "root:root@tcp(127.0.0.1:3306)/filedb?parseTime=true",
+	connector: func(dsn string) (driver.Connector, error) {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return mysql.NewConnector(cfg)
+	},
+	redactDSN: func(dsn string) string {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return redacted
+		}
+		if cfg.Passwd != "" {
+			cfg.Passwd = redacted
+		}
+		return cfg.FormatDSN()
+	},
+	newStore: func(base sqlStore) UploadStore { return mysqlStore{base} },
+}
+
+// mysqlStore MySQL 上传存储
+type mysqlStore struct {
+	sqlStore
+}
+
+func (s mysqlStore) SaveChunk(ctx context.Context, uploadID string, index int, size int64, md5 string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO upload_chunks (upload_id, chunk_index, chunk_size, chunk_md5)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			chunk_size = VALUES(chunk_size),
+			chunk_md5 = VALUES(chunk_md5),
+			received_at = CURRENT_TIMESTAMP
+	`, uploadID, index, size, md5)
+	return err
+}
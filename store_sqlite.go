@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/url"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// sqliteDialect SQLite 方言：无行锁（写事务以 IMMEDIATE 方式开始，本身串行），
+// LIKE 需显式指定转义符，时间以 UTC 写入以便与 CURRENT_TIMESTAMP 比较
+var sqliteDialect = &sqlDialect{
+	system:      "sqlite",
+	likeEscape:  ` ESCAPE '\'`,
+	keywordLike: "LIKE",
+	utcTimes:    true,
+}
+
+// sqliteDefaultParams DSN 未指定时补充的参数：写事务立即加锁，时间以 SQLite 可解析的格式写入
+var sqliteDefaultParams = map[string]string{
+	"_txlock":      "immediate",
+	"_time_format": "sqlite",
+}
+
+// sqliteDefaultPragmas DSN 未设置时补充的 PRAGMA：外键级联删除、并发写入时等待、WAL 日志
+var sqliteDefaultPragmas = []string{"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"}
+
+// sqliteDriver SQLite 驱动（纯 Go 实现，无需 cgo），适合单节点部署
+var sqliteDriver = &storeDriver{
+	dialect:    sqliteDialect,
+	defaultDSN: "file:filedb.sqlite",
+	connector: func(dsn string) (driver.Connector, error) {
+		return dsnConnector{dsn: sqliteDSN(dsn), drv: &sqlite.Driver{}}, nil
+	},
+	redactDSN: func(dsn string) string { return dsn },
+	newStore:  func(base sqlStore) UploadStore { return sqliteStore{base} },
+}
+
+// sqliteDSN 为DSN补充未设置的默认参数与 PRAGMA
+func sqliteDSN(dsn string) string {
+	path, rawQuery, _ := strings.Cut(dsn, "?")
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return dsn // 交由驱动报错
+	}
+	for k, v := range sqliteDefaultParams {
+		if !q.Has(k) {
+			q.Set(k, v)
+		}
+	}
+	pragmas := strings.Join(q["_pragma"], ",")
+	for _, p := range sqliteDefaultPragmas {
+		name, _, _ := strings.Cut(p, "(")
+		if !strings.Contains(pragmas, name) {
+			q.Add("_pragma", p)
+		}
+	}
+	return path + "?" + q.Encode()
+}
+
+// sqliteStore SQLite 上传存储
+type sqliteStore struct {
+	sqlStore
+}
+
+func (s sqliteStore) SaveChunk(ctx context.Context, uploadID string, index int, size int64, md5 string) error {
+	_, err := s.db.ExecContext(ctx, onConflictChunkUpsert, uploadID, index, size, md5)
+	return err
+}
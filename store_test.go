@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM uploads WHERE upload_id = ?", "SELECT * FROM uploads WHERE upload_id = $1"},
+		{"UPDATE uploads SET status = ?, folder = ? WHERE upload_id = ?", "UPDATE uploads SET status = $1, folder = $2 WHERE upload_id = $3"},
+		{"SELECT '?', ? FROM t WHERE a = 'it''s ?' AND b = ?", "SELECT '?', $1 FROM t WHERE a = 'it''s ?' AND b = $2"},
+		{`SELECT "col?" FROM t WHERE x = ?`, `SELECT "col?" FROM t WHERE x = $1`},
+		{"INSERT INTO t VALUES (?,?,?,?,?,?,?,?,?,?,?)", "INSERT INTO t VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)"},
+	}
+	for _, tt := range tests {
+		if got := postgresDialect.rebind(tt.query); got != tt.want {
+			t.Errorf("postgres rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+		for _, d := range []*sqlDialect{mysqlDialect, sqliteDialect} {
+			if got := d.rebind(tt.query); got != tt.query {
+				t.Errorf("%s rebind(%q) = %q, want unchanged", d.system, tt.query, got)
+			}
+		}
+	}
+}
+
+// useDollarArgs 让驱动包装层按 $n 改写占位符（modernc SQLite 同时支持 ? 与 $n），
+// 在 SQLite 上验证改写后的全部语句
+func useDollarArgs(t *testing.T) {
+	d := *sqliteDialect
+	d.dollarArgs = true
+	old := dialect
+	dialect = &d
+	t.Cleanup(func() { dialect = old })
+}
+
+func TestRebindSQLite(t *testing.T) {
+	setupTestDB(t)
+	useDollarArgs(t)
+	ctx := context.Background()
+
+	// 字符串中的问号保持原样
+	var lit, arg string
+	if err := db.QueryRowContext(ctx, "SELECT '?', ?", "value").Scan(&lit, &arg); err != nil {
+		t.Fatal(err)
+	}
+	if lit != "?" || arg != "value" {
+		t.Fatalf("got %q, %q", lit, arg)
+	}
+
+	// 预编译语句与事务内语句同样改写
+	stmt, err := db.PrepareContext(ctx, "SELECT ? + ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	var sum int
+	if err := stmt.QueryRowContext(ctx, 2, 3).Scan(&sum); err != nil || sum != 5 {
+		t.Fatalf("prepared sum = %d, %v", sum, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO webhooks (webhook_id, user_id, url, secret, events) VALUES (?, ?, ?, ?, ?)",
+		"wh1", "alice", "https://example.com/?a=1", "s", EventUploadCreated); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var url string
+	if err := db.QueryRowContext(ctx, "SELECT url FROM webhooks WHERE webhook_id = ? AND user_id = ?", "wh1", "alice").Scan(&url); err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://example.com/?a=1" {
+		t.Fatalf("url = %q", url)
+	}
+}
+
+func TestUploadStore(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		setupTestDB(t)
+		testUploadStore(t)
+	})
+	t.Run("sqlite-dollar-args", func(t *testing.T) {
+		setupTestDB(t)
+		useDollarArgs(t)
+		testUploadStore(t)
+	})
+}
+
+// newTestUpload 构造一个进行中的上传任务
+func newTestUpload(id, userID, folder, name string, size int64) *FileRecord {
+	return &FileRecord{
+		UploadID:    id,
+		FileName:    name,
+		FileSize:    size,
+		ChunkSize:   1024,
+		TotalChunks: int((size + 1023) / 1024),
+		Status:      StatusInProgress,
+		UserID:      userID,
+		Folder:      folder,
+	}
+}
+
+func testUploadStore(t *testing.T) {
+	ctx := context.Background()
+	s := uploadStore
+
+	// CreateUpload / GetUpload
+	file := newTestUpload("u1", "alice", "docs", "Report.pdf", 3000)
+	file.Compression = "zstd"
+	file.Tags = []string{"finance", "q3"}
+	file.Presigned = true
+	if err := s.CreateUpload(ctx, file, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetUpload(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FileName != "Report.pdf" || got.FileSize != 3000 || got.TotalChunks != 3 || got.Status != StatusInProgress ||
+		got.UserID != "alice" || got.Folder != "docs" || got.Compression != "zstd" || !got.Presigned {
+		t.Fatalf("GetUpload = %+v", got)
+	}
+	if !reflect.DeepEqual(got.Tags, []string{"finance", "q3"}) {
+		t.Fatalf("tags = %v", got.Tags)
+	}
+	if got.Tier != TierStandard || got.Encrypted || got.ScanStatus != "" || got.StoredSize != 3000 || got.CompletedAt != nil {
+		t.Fatalf("defaults: tier=%q encrypted=%v scan=%q stored=%d completed=%v",
+			got.Tier, got.Encrypted, got.ScanStatus, got.StoredSize, got.CompletedAt)
+	}
+	if _, err := s.GetUpload(ctx, "missing"); err != sql.ErrNoRows {
+		t.Fatalf("GetUpload(missing) err = %v, want sql.ErrNoRows", err)
+	}
+
+	// SaveChunk / ListChunks：乱序写入，重复写入覆盖
+	for _, i := range []int{2, 0, 1} {
+		if err := s.SaveChunk(ctx, "u1", i, 1024, "md5-old"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveChunk(ctx, "u1", 2, 952, "md5-new"); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := s.ListChunks(ctx, "u1")
+	if err != nil || !reflect.DeepEqual(chunks, []int{0, 1, 2}) {
+		t.Fatalf("ListChunks = %v, %v", chunks, err)
+	}
+	var size int64
+	var md5 string
+	if err := db.QueryRowContext(ctx, "SELECT chunk_size, chunk_md5 FROM upload_chunks WHERE upload_id = ? AND chunk_index = ?", "u1", 2).
+		Scan(&size, &md5); err != nil || size != 952 || md5 != "md5-new" {
+		t.Fatalf("chunk 2 = %d %q, %v", size, md5, err)
+	}
+
+	// CompleteUpload
+	if err := s.CompleteUpload(ctx, "u1", ScanScanning, 1200, "d41d8cd98f00b204e9800998ecf8427e"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.GetUpload(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusCompleted || got.ScanStatus != ScanScanning || got.StoredSize != 1200 ||
+		got.ContentMD5 != "d41d8cd98f00b204e9800998ecf8427e" || got.CompletedAt == nil {
+		t.Fatalf("after CompleteUpload: %+v", got)
+	}
+
+	// ListFolderFiles：包含子文件夹，不含其他用户、未完成任务与仅前缀相同的文件夹
+	others := []*FileRecord{
+		newTestUpload("u2", "alice", "docs/2024", "b.txt", 10),
+		newTestUpload("u3", "alice", "docsx", "c.txt", 10),
+		newTestUpload("u4", "bob", "docs", "d.txt", 10),
+		newTestUpload("u5", "alice", "docs", "e.txt", 10),
+		newTestUpload("u6", "alice", "a_b", "f.txt", 10),
+		newTestUpload("u7", "alice", "axb/c", "g.txt", 10),
+	}
+	for _, f := range others {
+		if err := s.CreateUpload(ctx, f, nil); err != nil {
+			t.Fatal(err)
+		}
+		if f.UploadID != "u5" {
+			if err := s.CompleteUpload(ctx, f.UploadID, "", f.FileSize, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	folderIDs := func(folder string) []string {
+		files, err := s.ListFolderFiles(ctx, "alice", folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, f := range files {
+			ids = append(ids, f.UploadID)
+		}
+		return ids
+	}
+	if ids := folderIDs("docs"); !reflect.DeepEqual(ids, []string{"u1", "u2"}) {
+		t.Fatalf("ListFolderFiles(docs) = %v, want [u1 u2]", ids)
+	}
+	if ids := folderIDs("a_b"); !reflect.DeepEqual(ids, []string{"u6"}) {
+		t.Fatalf("ListFolderFiles(a_b) = %v, want [u6] (LIKE wildcard not escaped)", ids)
+	}
+
+	// FileHistory：过滤、排序与分页（文件名与 MySQL 一样按不区分大小写排序）
+	history := func(q FileHistoryQuery) ([]string, int) {
+		files, total, err := s.FileHistory(ctx, &q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, f := range files {
+			ids = append(ids, f.UploadID)
+		}
+		return ids, total
+	}
+	if ids, total := history(FileHistoryQuery{Page: 1, PerPage: 3, SortBy: "file_name", Order: "asc"}); total != 7 ||
+		!reflect.DeepEqual(ids, []string{"u2", "u3", "u4"}) {
+		t.Fatalf("history page 1 = %v (total %d)", ids, total)
+	}
+	if ids, total := history(FileHistoryQuery{Page: 3, PerPage: 3, SortBy: "file_name", Order: "asc"}); total != 7 ||
+		!reflect.DeepEqual(ids, []string{"u1"}) {
+		t.Fatalf("history page 3 = %v (total %d)", ids, total)
+	}
+	if ids, total := history(FileHistoryQuery{Page: 1, PerPage: 10, Status: StatusInProgress}); total != 1 ||
+		!reflect.DeepEqual(ids, []string{"u5"}) {
+		t.Fatalf("history in_progress = %v (total %d)", ids, total)
+	}
+	if ids, total := history(FileHistoryQuery{Page: 1, PerPage: 10, Keyword: "report"}); total != 1 ||
+		!reflect.DeepEqual(ids, []string{"u1"}) {
+		t.Fatalf("history keyword = %v (total %d), want case-insensitive match", ids, total)
+	}
+	if ids, total := history(FileHistoryQuery{Page: 1, PerPage: 10, Keyword: "nothing"}); total != 0 || len(ids) != 0 {
+		t.Fatalf("history no match = %v (total %d)", ids, total)
+	}
+	// 非法排序字段回退为 created_at，不会拼入SQL
+	if _, total := history(FileHistoryQuery{Page: 1, PerPage: 10, SortBy: "1; DROP TABLE uploads", Order: "sideways"}); total != 7 {
+		t.Fatalf("history with invalid sort total = %d", total)
+	}
+
+	// RecentFiles
+	recent, err := s.RecentFiles(ctx, 4)
+	if err != nil || len(recent) != 4 {
+		t.Fatalf("RecentFiles = %d, %v", len(recent), err)
+	}
+
+	// FileStats / TodayStats / CountByStatus
+	stats, err := s.FileStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalCount != 7 || stats.CompletedCount != 6 || stats.TotalSize != 3050 || stats.StoredSize != 1250 {
+		t.Fatalf("FileStats = %+v", stats)
+	}
+	today, err := s.TodayStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// created_at 以 UTC 写入，而统计按本地日期，仅在两者同一天时比较
+	if now := time.Now(); now.Format("2006-01-02") == now.UTC().Format("2006-01-02") {
+		if stats.TodayUploadCount != 7 || today.Count != 6 || today.TotalSize != 3050 {
+			t.Fatalf("today: FileStats=%d TodayStats=%+v", stats.TodayUploadCount, today)
+		}
+	}
+	for status, want := range map[string]int64{StatusCompleted: 6, StatusInProgress: 1, StatusFailed: 0} {
+		if n, err := s.CountByStatus(ctx, status); err != nil || n != want {
+			t.Fatalf("CountByStatus(%s) = %d, %v; want %d", status, n, err, want)
+		}
+	}
+
+	// DeleteUpload 级联删除分片记录
+	if err := s.DeleteUpload(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUpload(ctx, "u1"); err != sql.ErrNoRows {
+		t.Fatalf("GetUpload after delete err = %v", err)
+	}
+	if chunks, err := s.ListChunks(ctx, "u1"); err != nil || len(chunks) != 0 {
+		t.Fatalf("chunks after delete = %v, %v", chunks, err)
+	}
+}
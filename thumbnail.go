@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	_ "image/gif" // GIF解码（取第一帧）
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // WebP解码
+)
+
+// thumbnailQuality JPEG缩略图质量
+const thumbnailQuality = 85
+
+// maxImagePixels 允许解码的最大像素数，防止解压炸弹
+var maxImagePixels int64 = 100 * 1000 * 1000
+
+// thumbnailTypes 支持生成缩略图的MIME类型
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// thumbnailDir 缩略图目录，与文件一同存放在存储目录下
+func thumbnailDir(uploadID string) string {
+	return filepath.Join(finalDir, ".thumbnails", uploadID)
+}
+
+// thumbnailProcessor 为图片生成缩略图并记录EXIF信息
+type thumbnailProcessor struct{}
+
+func (thumbnailProcessor) Name() string { return "thumbnail" }
+
+func (thumbnailProcessor) Applies(job *ProcessJob) bool {
+	ct, err := job.ContentType()
+	return err == nil && thumbnailTypes[strings.Split(ct, ";")[0]]
+}
+
+func (thumbnailProcessor) Process(ctx context.Context, job *ProcessJob) (interface{}, error) {
+	f, err := job.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// 先读取尺寸，拒绝过大的图片
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode image config: %v", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return nil, fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	exif, err := readExif(f)
+	if err != nil {
+		slog.Warn("Read exif error", "upload_id", job.File.UploadID, "err", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	orientation := 1
+	if exif != nil && exif.Orientation >= 1 && exif.Orientation <= 8 {
+		orientation = exif.Orientation
+	}
+	width, height := cfg.Width, cfg.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+
+	// PNG/GIF 可能带透明通道，保持PNG格式；其余输出JPEG
+	ext := "jpg"
+	if format == "png" || format == "gif" {
+		ext = "png"
+	}
+
+	// 缩略图与原文件使用相同的数据密钥加密，图片已压缩故不再压缩
+	sp, err := thumbnailStoreParams(job.File)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := thumbnailDir(job.File.UploadID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	generated := []int{}
+	for _, size := range thumbnailSizes {
+		thumb := orientImage(resizeToFit(src, size), orientation)
+		if err := writeThumbnail(filepath.Join(dir, fmt.Sprintf("%d.%s", size, ext)), thumb, ext, sp); err != nil {
+			return nil, err
+		}
+		generated = append(generated, size)
+	}
+
+	// 按策略丢弃GPS位置
+	if exif != nil && !keepExifGPS {
+		exif.GPS = nil
+	}
+	return map[string]interface{}{
+		"format":     format,
+		"width":      width,
+		"height":     height,
+		"thumbnails": generated,
+		"exif":       exif,
+	}, nil
+}
+
+// resizeToFit 等比缩放到不超过 size×size，不放大
+func resizeToFit(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+		return dst
+	}
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+// orientImage 按EXIF方向旋转/翻转图片
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 水平翻转
+				dx, dy = w-1-x, y
+			case 3: // 旋转180度
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				dx, dy = x, h-1-y
+			case 5: // 沿左上-右下对角线翻转
+				dx, dy = y, x
+			case 6: // 顺时针旋转90度
+				dx, dy = h-1-y, x
+			case 7: // 沿右上-左下对角线翻转
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆时针旋转90度
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// thumbnailStoreParams 缩略图的存储参数
+func thumbnailStoreParams(file *FileRecord) (storeParams, error) {
+	sp, err := fileStoreParams(file)
+	sp.codec = ""
+	sp.aad = file.UploadID + "/thumbnail"
+	return sp, err
+}
+
+// writeThumbnail 编码并原子写入缩略图
+func writeThumbnail(path string, img image.Image, ext string, sp storeParams) error {
+	out, err := createStoredFile(path, sp)
+	if err != nil {
+		return err
+	}
+	if ext == "png" {
+		err = png.Encode(out, img)
+	} else {
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: thumbnailQuality})
+	}
+	if err != nil {
+		out.Abort()
+		return err
+	}
+	_, err = out.Commit()
+	return err
+}
+
+// GetThumbnail 获取图片缩略图
+// 返回不小于请求尺寸的最小缩略图，未指定尺寸时返回最小缩略图
+// GET /api/v1/files/{upload_id}/thumbnail?size=256
+func GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["upload_id"]
+	logger := reqLogger(r).With("upload_id", uploadID)
+
+	file, err := getFileByUploadID(dbCtx(r), uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		logger.Error("Database query error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if file.UserID != "" && file.UserID != getUserID(r) && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Not the owner of this file")
+		return
+	}
+	if file.ScanStatus == ScanInfected {
+		writeError(w, http.StatusForbidden, "File is infected and has been quarantined")
+		return
+	}
+
+	size := thumbnailSizes[0]
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+		requested, err := strconv.Atoi(sizeStr)
+		if err != nil || requested <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid size")
+			return
+		}
+		size = thumbnailSizes[len(thumbnailSizes)-1]
+		for _, s := range thumbnailSizes {
+			if s >= requested {
+				size = s
+				break
+			}
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(thumbnailDir(uploadID), fmt.Sprintf("%d.*", size)))
+	var path string
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".part") {
+			path = m
+			break
+		}
+	}
+	if path == "" {
+		writeError(w, http.StatusNotFound, "Thumbnail not available")
+		return
+	}
+
+	sp, err := thumbnailStoreParams(file)
+	if err != nil {
+		logger.Error("Load data key error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	f, err := openStoredPath(path, sp)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Thumbnail not available")
+		return
+	}
+	defer f.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(servedWriter{w}, r, filepath.Base(path), info.ModTime(), f)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 链路追踪：HTTP 请求（按路由模板命名）、数据库语句、分片写入、合并、清理、后处理与 Webhook 投递
+// 均生成 OpenTelemetry span。请求头中的 W3C traceparent/baggage 会被沿用；
+// 未配置导出器时使用空实现，仍会传递上游的追踪上下文。
+var (
+	tracer         = otel.Tracer("go-upload") // 全局 tracer，初始化后自动使用配置的 TracerProvider
+	tracerProvider *sdktrace.TracerProvider   // 为nil时未启用导出
+)
+
+// initTracing 按配置初始化 TracerProvider 与 W3C 上下文传播
+func initTracing(cfg TracingConfig) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			attribute.String("service.name", cfg.ServiceName),
+			attribute.String("service.version", version),
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// shutdownTracing 导出剩余的 span
+func shutdownTracing(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	return tracerProvider.Shutdown(ctx)
+}
+
+// withTraceID 为请求日志器附加 trace_id，便于从日志跳转到链路
+func withTraceID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logger := reqLogger(r).With("trace_id", sc.TraceID().String())
+			r = r.WithContext(withLogger(r.Context(), logger))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// dbCtx 请求的数据库上下文：携带追踪与日志信息，但不随客户端断开而取消，避免中断已开始的写入
+func dbCtx(r *http.Request) context.Context {
+	return context.WithoutCancel(r.Context())
+}
+
+// failSpan 记录错误并将 span 标记为失败
+func failSpan(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spanExporterOnce sync.Once
+	spanExporter     *tracetest.InMemoryExporter
+)
+
+// testSpanExporter 将全局 TracerProvider 设为同步导出到内存的实现（全局只能设置一次）
+func testSpanExporter(t *testing.T) *tracetest.InMemoryExporter {
+	spanExporterOnce.Do(func() {
+		spanExporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	spanExporter.Reset()
+	t.Cleanup(spanExporter.Reset)
+	return spanExporter
+}
+
+// tracedUploadRouter 与 main 中相同的请求span中间件及上传路由
+func tracedUploadRouter() http.Handler {
+	r := mux.NewRouter()
+	r.Use(otelmux.Middleware("go-upload"))
+	r.Use(withTraceID)
+	uploads := r.PathPrefix("/api/v1/uploads").Subrouter()
+	uploads.HandleFunc("", CreateUpload).Methods("POST")
+	uploads.HandleFunc("/{upload_id}/complete", withUploadOwner(CompleteUpload)).Methods("POST")
+	uploads.HandleFunc("/{upload_id}/chunks/{index}", withUploadOwner(UploadChunk)).Methods("PUT", "POST")
+	return r
+}
+
+// spanTree 按名称与 SpanID 索引导出的 span
+type spanTree struct {
+	spans tracetest.SpanStubs
+	byID  map[trace.SpanID]tracetest.SpanStub
+}
+
+func newSpanTree(spans tracetest.SpanStubs) *spanTree {
+	st := &spanTree{spans: spans, byID: map[trace.SpanID]tracetest.SpanStub{}}
+	for _, s := range spans {
+		st.byID[s.SpanContext.SpanID()] = s
+	}
+	return st
+}
+
+// named 返回指定名称的全部 span
+func (st *spanTree) named(name string) []tracetest.SpanStub {
+	var out []tracetest.SpanStub
+	for _, s := range st.spans {
+		if s.Name == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// one 返回指定名称的唯一 span
+func (st *spanTree) one(t *testing.T, name string) tracetest.SpanStub {
+	t.Helper()
+	spans := st.named(name)
+	if len(spans) != 1 {
+		t.Fatalf("got %d %q spans, want 1 (all: %v)", len(spans), name, st.names())
+	}
+	return spans[0]
+}
+
+// root 沿父 span 上溯到该 trace 中导出的根 span
+func (st *spanTree) root(s tracetest.SpanStub) tracetest.SpanStub {
+	for {
+		parent, ok := st.byID[s.Parent.SpanID()]
+		if !ok {
+			return s
+		}
+		s = parent
+	}
+}
+
+func (st *spanTree) names() []string {
+	var names []string
+	for _, s := range st.spans {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func spanAttr(s tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// assertChild 校验 child 是 parent 的直接子 span
+func assertChild(t *testing.T, parent, child tracetest.SpanStub) {
+	t.Helper()
+	if child.SpanContext.TraceID() != parent.SpanContext.TraceID() || child.Parent.SpanID() != parent.SpanContext.SpanID() {
+		t.Fatalf("span %q is not a child of %q", child.Name, parent.Name)
+	}
+}
+
+func TestUploadSpanTree(t *testing.T) {
+	setupTestDB(t)
+	exporter := testSpanExporter(t)
+	router := tracedUploadRouter()
+
+	do := func(method, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code >= 300 {
+			t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	data := bytes.Repeat([]byte("go-upload "), 1000)
+	chunkSize := 6000
+	reqBody, _ := json.Marshal(UploadRequest{FileName: "trace.txt", TotalSize: int64(len(data)), ChunkSize: chunkSize})
+	var created UploadResponse
+	json.Unmarshal(do("POST", "/api/v1/uploads", reqBody, nil).Body.Bytes(), &created)
+	if created.TotalChunks != 2 {
+		t.Fatalf("total_chunks = %d, want 2", created.TotalChunks)
+	}
+
+	// 分片请求沿用客户端传入的 traceparent
+	const remoteTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const remoteSpan = "00f067aa0ba902b7"
+	parentHeader := http.Header{"Traceparent": {"00-" + remoteTrace + "-" + remoteSpan + "-01"}}
+	for i := 0; i < created.TotalChunks; i++ {
+		end := min((i+1)*chunkSize, len(data))
+		do("PUT", "/api/v1/uploads/"+created.UploadID+"/chunks/"+strconv.Itoa(i), data[i*chunkSize:end], parentHeader)
+	}
+	do("POST", "/api/v1/uploads/"+created.UploadID+"/complete", nil, nil)
+	background.Wait() // 等待异步清理分片完成
+
+	st := newSpanTree(exporter.GetSpans())
+	chunkRoute := "/api/v1/uploads/{upload_id}/chunks/{index}"
+	completeRoute := "/api/v1/uploads/{upload_id}/complete"
+
+	// 分片上传：请求span -> chunk.write -> 数据库语句
+	requests := st.named(chunkRoute)
+	writes := st.named("chunk.write")
+	if len(requests) != 2 || len(writes) != 2 {
+		t.Fatalf("got %d chunk requests and %d chunk.write spans, want 2 each (all: %v)", len(requests), len(writes), st.names())
+	}
+	for _, req := range requests {
+		if req.SpanKind != trace.SpanKindServer {
+			t.Errorf("request span kind = %v, want server", req.SpanKind)
+		}
+		if req.SpanContext.TraceID().String() != remoteTrace || req.Parent.SpanID().String() != remoteSpan || !req.Parent.IsRemote() {
+			t.Errorf("chunk request span does not continue the incoming traceparent: parent %v", req.Parent)
+		}
+	}
+	seen := map[int64]bool{}
+	for _, wr := range writes {
+		req, ok := st.byID[wr.Parent.SpanID()]
+		if !ok || req.Name != chunkRoute {
+			t.Fatalf("chunk.write parent is %q, want %q", req.Name, chunkRoute)
+		}
+		idx := spanAttr(wr, "chunk.index").AsInt64()
+		seen[idx] = true
+		if got := spanAttr(wr, "upload.id").AsString(); got != created.UploadID {
+			t.Errorf("chunk.write upload.id = %q", got)
+		}
+		want := int64(chunkSize)
+		if idx == 1 {
+			want = int64(len(data) - chunkSize)
+		}
+		if got := spanAttr(wr, "chunk.bytes").AsInt64(); got != want {
+			t.Errorf("chunk %d bytes = %d, want %d", idx, got, want)
+		}
+	}
+	if !seen[0] || !seen[1] {
+		t.Fatalf("chunk.write indexes = %v, want 0 and 1", seen)
+	}
+
+	// 合并：请求span 下有 merge_chunks 与异步的 cleanup_chunks
+	complete := st.one(t, completeRoute)
+	merge := st.one(t, "merge_chunks")
+	assertChild(t, complete, merge)
+	if got := spanAttr(merge, "chunk.count").AsInt64(); got != 2 {
+		t.Errorf("merge chunk.count = %d, want 2", got)
+	}
+	if got := spanAttr(merge, "file.size").AsInt64(); got != int64(len(data)) {
+		t.Errorf("merge file.size = %d, want %d", got, len(data))
+	}
+	if merge.Status.Code != 0 {
+		t.Errorf("merge span status = %v", merge.Status)
+	}
+	assertChild(t, complete, st.one(t, "cleanup_chunks"))
+	if complete.SpanContext.TraceID() == requests[0].SpanContext.TraceID() {
+		t.Error("complete request without traceparent should start a new trace")
+	}
+
+	// 数据库语句挂在所属请求的 span 树中
+	var dbSpans int
+	for _, s := range st.spans {
+		if !strings.HasPrefix(s.Name, "db.") {
+			continue
+		}
+		dbSpans++
+		if s.SpanKind != trace.SpanKindClient || spanAttr(s, "db.system").AsString() != "sqlite" {
+			t.Errorf("db span %q: kind %v, db.system %q", s.Name, s.SpanKind, spanAttr(s, "db.system").AsString())
+		}
+		if root := st.root(s); !strings.HasPrefix(root.Name, "/api/v1/uploads") {
+			t.Errorf("db span %q is not under a request span (root %q)", s.Name, root.Name)
+		}
+	}
+	if dbSpans == 0 {
+		t.Error("no db spans recorded")
+	}
+	for _, wr := range writes {
+		var under bool
+		for _, s := range st.spans {
+			if strings.HasPrefix(s.Name, "db.") && s.Parent.SpanID() == wr.SpanContext.SpanID() {
+				under = true
+			}
+		}
+		if !under {
+			t.Errorf("chunk.write %d has no db child span", spanAttr(wr, "chunk.index").AsInt64())
+		}
+	}
+}
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Webhook 事件类型
+const (
+	EventUploadCreated   = "upload.created"   // 上传任务已创建
+	EventUploadCompleted = "upload.completed" // 上传已完成
+	EventUploadFailed    = "upload.failed"    // 上传失败
+	EventFileDeleted     = "file.deleted"     // 文件已删除
+	EventFileInfected    = "file.infected"    // 文件发现病毒并已隔离
+)
+
+// 投递状态与重试策略
+const (
+	DeliveryPending   = "pending"   // 待投递
+	DeliveryDelivered = "delivered" // 已送达
+	DeliveryFailed    = "failed"    // 重试耗尽
+
+	webhookMaxAttempts  = 8                // 最大投递次数
+	webhookBaseBackoff  = 10 * time.Second // 首次重试间隔，之后指数增长
+	webhookMaxBackoff   = time.Hour        // 最大重试间隔
+	webhookPollInterval = 5 * time.Second  // 发件箱轮询间隔
+	webhookLease        = time.Minute      // 领取投递任务后的租约时长
+	webhookTimeout      = 10 * time.Second // 单次投递超时
+	webhookBatchSize    = 20               // 每轮最多处理的投递数
+)
+
+// webhookEvents 支持订阅的事件集合
+var webhookEvents = map[string]bool{
+	EventUploadCreated:   true,
+	EventUploadCompleted: true,
+	EventUploadFailed:    true,
+	EventFileDeleted:     true,
+	EventFileInfected:    true,
+}
+
+var (
+	webhookWake         = make(chan struct{}, 1) // 唤醒投递协程
+	webhookClient       = newWebhookClient()     // 投递使用的HTTP客户端
+	webhookAllowPrivate = false                  // 是否允许投递到回环、内网与链路本地地址
+)
+
+var errWebhookTarget = errors.New("webhook target address is not allowed")
+
+// WebhookRequest 注册Webhook请求
+type WebhookRequest struct {
+	URL    string   `json:"url"`              // 接收地址
+	Events []string `json:"events"`           // 订阅的事件
+	Secret string   `json:"secret,omitempty"` // 签名密钥（为空时自动生成）
+	Global bool     `json:"global,omitempty"` // 是否接收所有用户的事件（仅管理员）
+}
+
+// Webhook Webhook订阅记录
+type Webhook struct {
+	WebhookID string    `json:"webhook_id"`       // 订阅ID
+	UserID    string    `json:"user_id"`          // 所属用户
+	URL       string    `json:"url"`              // 接收地址
+	Events    []string  `json:"events"`           // 订阅的事件
+	Global    bool      `json:"global"`           // 是否接收所有用户的事件
+	Active    bool      `json:"active"`           // 是否启用
+	Secret    string    `json:"secret,omitempty"` // 签名密钥（仅创建时返回）
+	CreatedAt time.Time `json:"created_at"`       // 创建时间
+}
+
+// WebhookDelivery 投递记录
+type WebhookDelivery struct {
+	DeliveryID     string     `json:"delivery_id"`                // 投递ID
+	WebhookID      string     `json:"webhook_id"`                 // 订阅ID
+	Event          string     `json:"event"`                      // 事件类型
+	Status         string     `json:"status"`                     // 投递状态
+	Attempts       int        `json:"attempts"`                   // 已尝试次数
+	LastStatusCode int        `json:"last_status_code,omitempty"` // 最近一次响应码
+	LastError      string     `json:"last_error,omitempty"`       // 最近一次错误
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`            // 下次尝试时间
+	CreatedAt      time.Time  `json:"created_at"`                 // 创建时间
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`     // 送达时间
+	Payload        string     `json:"payload,omitempty"`          // 投递内容
+}
+
+// WebhookPayload 投递的JSON内容
+type WebhookPayload struct {
+	ID        string      `json:"id"`         // 事件ID
+	Event     string      `json:"event"`      // 事件类型
+	CreatedAt time.Time   `json:"created_at"` // 事件时间
+	Data      interface{} `json:"data"`       // 事件数据
+}
+
+// UploadEventData 上传相关事件的数据
+// 完成事件内嵌 CompleteResponse 的字段
+type UploadEventData struct {
+	UploadID  string `json:"upload_id"`         // 上传任务ID
+	FileName  string `json:"file_name"`         // 文件名
+	UserID    string `json:"user_id,omitempty"` // 所属用户
+	Folder    string `json:"folder,omitempty"`  // 所属文件夹
+	TotalSize int64  `json:"total_size"`        // 文件总大小
+	Error     string `json:"error,omitempty"`   // 失败原因
+	*CompleteResponse
+}
+
+// CreateWebhook 注册Webhook
+// POST /api/v1/webhooks
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := reqLogger(r)
+	userID := getUserID(r)
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, "Login required")
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeError(w, http.StatusBadRequest, "Invalid webhook url")
+		return
+	}
+	// 域名在投递时解析后再校验，这里只提前拒绝字面IP
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !webhookTargetAllowed(ip) {
+		writeError(w, http.StatusBadRequest, "Webhook url must not point to a loopback, private or link-local address")
+		return
+	}
+	if len(req.Events) == 0 {
+		writeError(w, http.StatusBadRequest, "At least one event is required")
+		return
+	}
+	for _, e := range req.Events {
+		if !webhookEvents[e] {
+			writeError(w, http.StatusBadRequest, "Unknown event: "+e)
+			return
+		}
+	}
+	if req.Global && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "Only admins can register global webhooks")
+		return
+	}
+	if req.Secret == "" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			logger.Error("Generate webhook secret error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Server error")
+			return
+		}
+		req.Secret = hex.EncodeToString(buf)
+	}
+
+	hook := &Webhook{
+		WebhookID: uuid.New().String(),
+		UserID:    userID,
+		URL:       req.URL,
+		Events:    req.Events,
+		Global:    req.Global,
+		Active:    true,
+		Secret:    req.Secret,
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+	_, err = db.ExecContext(dbCtx(r),
+		"INSERT INTO webhooks (webhook_id, user_id, url, secret, events, is_global) VALUES (?, ?, ?, ?, ?, ?)",
+		hook.WebhookID, hook.UserID, hook.URL, hook.Secret, strings.Join(hook.Events, ","), hook.Global,
+	)
+	if err != nil {
+		logger.Error("Database insert webhook error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// ListWebhooks 列出当前用户的Webhook
+// GET /api/v1/webhooks
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	logger := reqLogger(r)
+	userID := getUserID(r)
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, "Login required")
+		return
+	}
+
+	rows, err := db.QueryContext(dbCtx(r), `
+		SELECT webhook_id, user_id, url, events, is_global, active, created_at
+		FROM webhooks
+		WHERE user_id = ? OR (? AND is_global = TRUE)
+		ORDER BY created_at DESC
+	`, userID, isAdmin(r))
+	if err != nil {
+		logger.Error("Database query webhooks error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	hooks := []*Webhook{}
+	for rows.Next() {
+		hook := &Webhook{}
+		var events string
+		if err := rows.Scan(&hook.WebhookID, &hook.UserID, &hook.URL, &events, &hook.Global, &hook.Active, &hook.CreatedAt); err != nil {
+			logger.Error("Scan webhook error", "err", err)
+			continue
+		}
+		hook.Events = strings.Split(events, ",")
+		hooks = append(hooks, hook)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("Rows error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": hooks,
+	})
+}
+
+// DeleteWebhook 删除Webhook
+// DELETE /api/v1/webhooks/{webhook_id}
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID := mux.Vars(r)["webhook_id"]
+	if !authorizeWebhook(w, r, webhookID) {
+		return
+	}
+
+	if _, err := db.ExecContext(dbCtx(r), "DELETE FROM webhooks WHERE webhook_id = ?", webhookID); err != nil {
+		reqLogger(r).Error("Database delete webhook error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries 查询Webhook投递日志
+// GET /api/v1/webhooks/{webhook_id}/deliveries
+func ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := dbCtx(r)
+	logger := reqLogger(r)
+	webhookID := mux.Vars(r)["webhook_id"]
+	if !authorizeWebhook(w, r, webhookID) {
+		return
+	}
+	query := parseQueryParams(r)
+
+	var total int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?", webhookID).Scan(&total)
+	if err != nil {
+		logger.Error("Database count deliveries error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT delivery_id, webhook_id, event, status, attempts, COALESCE(last_status_code, 0),
+			COALESCE(last_error, ''), next_attempt_at, created_at, delivered_at, payload
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`, webhookID, query.PerPage, (query.Page-1)*query.PerPage)
+	if err != nil {
+		logger.Error("Database query deliveries error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		var deliveredAt sql.NullTime
+		err := rows.Scan(&d.DeliveryID, &d.WebhookID, &d.Event, &d.Status, &d.Attempts, &d.LastStatusCode,
+			&d.LastError, &d.NextAttemptAt, &d.CreatedAt, &deliveredAt, &d.Payload)
+		if err != nil {
+			logger.Error("Scan delivery error", "err", err)
+			continue
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("Rows error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total":    total,
+		"page":     query.Page,
+		"per_page": query.PerPage,
+		"data":     deliveries,
+	})
+}
+
+// RedeliverWebhook 手动重新投递
+// POST /api/v1/webhooks/{webhook_id}/deliveries/{delivery_id}/redeliver
+func RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorizeWebhook(w, r, vars["webhook_id"]) {
+		return
+	}
+
+	res, err := db.ExecContext(dbCtx(r), `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = 0, next_attempt_at = ?, last_error = NULL
+		WHERE delivery_id = ? AND webhook_id = ?
+	`, DeliveryPending, time.Now().UTC(), vars["delivery_id"], vars["webhook_id"])
+	if err != nil {
+		reqLogger(r).Error("Database redeliver error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "Delivery not found")
+		return
+	}
+
+	wakeWebhookWorker()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"delivery_id": vars["delivery_id"],
+		"status":      DeliveryPending,
+	})
+}
+
+// 事件发布与投递
+
+// publishEvent 为订阅了该事件的Webhook写入发件箱
+// 用户自己的订阅与全局订阅都会收到事件
+func publishEvent(ctx context.Context, event, userID string, data interface{}) {
+	payload, err := json.Marshal(WebhookPayload{
+		ID:        uuid.New().String(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		slog.Error("Marshal webhook payload error", "event", event, "err", err)
+		return
+	}
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT webhook_id, events FROM webhooks WHERE active = TRUE AND (is_global = TRUE OR user_id = ?)",
+		userID,
+	)
+	if err != nil {
+		slog.Error("Query webhooks error", "event", event, "err", err)
+		return
+	}
+	var targets []string
+	for rows.Next() {
+		var id, events string
+		if err := rows.Scan(&id, &events); err != nil {
+			continue
+		}
+		for _, e := range strings.Split(events, ",") {
+			if e == event {
+				targets = append(targets, id)
+				break
+			}
+		}
+	}
+	rows.Close()
+
+	for _, id := range targets {
+		_, err := db.ExecContext(ctx,
+			"INSERT INTO webhook_deliveries (delivery_id, webhook_id, event, payload, status, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?)",
+			uuid.New().String(), id, event, string(payload), DeliveryPending, time.Now().UTC(),
+		)
+		if err != nil {
+			slog.Error("Insert webhook delivery error", "event", event, "webhook_id", id, "err", err)
+		}
+	}
+	if len(targets) > 0 {
+		wakeWebhookWorker()
+	}
+}
+
+// publishUploadEvent 发布上传相关事件
+func publishUploadEvent(ctx context.Context, event string, file *FileRecord, complete *CompleteResponse, cause error) {
+	data := &UploadEventData{
+		UploadID:         file.UploadID,
+		FileName:         file.FileName,
+		UserID:           file.UserID,
+		Folder:           file.Folder,
+		TotalSize:        file.FileSize,
+		CompleteResponse: complete,
+	}
+	if cause != nil {
+		data.Error = cause.Error()
+	}
+	publishEvent(ctx, event, file.UserID, data)
+}
+
+// wakeWebhookWorker 唤醒投递协程（非阻塞）
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhookWorker 后台轮询发件箱并投递
+func runWebhookWorker() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		webhookHeartbeat.Store(time.Now().UnixNano())
+		processWebhookOutbox()
+		select {
+		case <-ticker.C:
+		case <-webhookWake:
+		case <-backgroundCtx.Done():
+			return
+		}
+	}
+}
+
+// webhookJob 待投递的发件箱记录
+type webhookJob struct {
+	id, event, payload, url, secret string
+	attempts                        int
+}
+
+// processWebhookOutbox 处理一批到期的投递
+// next_attempt_at 的写入与比较统一使用本机 UTC 时间，不依赖数据库时钟与时区
+func processWebhookOutbox() {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.delivery_id, d.event, d.payload, d.attempts, h.url, h.secret
+		FROM webhook_deliveries d
+		JOIN webhooks h ON h.webhook_id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? AND h.active = TRUE
+		ORDER BY d.next_attempt_at
+		LIMIT ?
+	`, DeliveryPending, now, webhookBatchSize)
+	if err != nil {
+		slog.Error("Query webhook outbox error", "err", err)
+		return
+	}
+
+	var jobs []webhookJob
+	for rows.Next() {
+		var j webhookJob
+		if err := rows.Scan(&j.id, &j.event, &j.payload, &j.attempts, &j.url, &j.secret); err != nil {
+			slog.Error("Scan webhook outbox error", "err", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		// 停机时不再领取新任务，未领取的投递由其他实例或重启后继续
+		if backgroundCtx.Err() != nil {
+			return
+		}
+
+		// 领取任务：推迟下次尝试时间作为租约，防止多实例重复投递
+		now := time.Now().UTC()
+		res, err := db.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET next_attempt_at = ?
+			WHERE delivery_id = ? AND status = ? AND next_attempt_at <= ?
+		`, now.Add(webhookLease), j.id, DeliveryPending, now)
+		if err != nil {
+			slog.Error("Claim webhook delivery error", "delivery_id", j.id, "event", j.event, "err", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		deliverWebhook(j)
+	}
+}
+
+// deliverWebhook 投递一条已领取的记录并写回结果
+func deliverWebhook(j webhookJob) {
+	logger := slog.With("delivery_id", j.id, "event", j.event)
+	ctx, span := tracer.Start(context.Background(), "webhook.deliver", trace.WithAttributes(
+		attribute.String("webhook.delivery_id", j.id),
+		attribute.String("webhook.event", j.event),
+	))
+	defer span.End()
+
+	code, err := sendWebhook(ctx, j.url, j.secret, j.id, j.event, []byte(j.payload))
+	attempts := j.attempts + 1
+	span.SetAttributes(attribute.Int("webhook.attempt", attempts))
+	if err == nil {
+		_, err = db.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = ?, attempts = ?, last_status_code = ?, last_error = NULL, delivered_at = CURRENT_TIMESTAMP
+			WHERE delivery_id = ?
+		`, DeliveryDelivered, attempts, code, j.id)
+		if err != nil {
+			logger.Error("Update webhook delivery error", "err", err)
+		}
+		return
+	}
+	failSpan(span, err)
+
+	status := DeliveryPending
+	if attempts >= webhookMaxAttempts {
+		status = DeliveryFailed
+	}
+	logger.Warn("Webhook delivery failed", "attempt", attempts, "err", err)
+	_, err = db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, last_status_code = NULLIF(?, 0), last_error = ?, next_attempt_at = ?
+		WHERE delivery_id = ?
+	`, status, attempts, code, truncateString(err.Error(), 500), time.Now().UTC().Add(webhookBackoff(attempts)), j.id)
+	if err != nil {
+		logger.Error("Update webhook delivery error", "err", err)
+	}
+}
+
+// sendWebhook 发送一次投递，返回响应码
+// 签名头 X-Webhook-Signature = sha256=HMAC(secret, "<timestamp>.<body>")
+func sendWebhook(ctx context.Context, target, secret, deliveryID, event string, body []byte) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-upload-webhook/1.0")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", deliveryID)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", "sha256="+webhookSignature(secret, ts, body))
+	// 携带 traceparent，接收方可将处理过程接入同一链路
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// newWebhookClient 创建投递使用的HTTP客户端
+// 连接前校验解析后的地址，防止通过Webhook访问内网服务（SSRF）；
+// 不使用环境变量中的代理，确保校验的是接收方本身的地址
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: checkWebhookDial}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConns:        16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// checkWebhookDial 在建立连接前校验目标IP（DNS解析与每次重定向之后）
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !webhookTargetAllowed(ip) {
+		return fmt.Errorf("%w: %s", errWebhookTarget, host)
+	}
+	return nil
+}
+
+// webhookTargetAllowed 判断是否允许投递到该IP
+func webhookTargetAllowed(ip net.IP) bool {
+	if webhookAllowPrivate {
+		return true
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// webhookSignature 计算投递签名
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff 计算第n次失败后的重试间隔
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookBaseBackoff
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d
+}
+
+// authorizeWebhook 校验当前用户是否可管理该Webhook
+// 校验失败时已写入错误响应并返回false
+func authorizeWebhook(w http.ResponseWriter, r *http.Request, webhookID string) bool {
+	userID := getUserID(r)
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, "Login required")
+		return false
+	}
+
+	var owner string
+	err := db.QueryRowContext(dbCtx(r), "SELECT user_id FROM webhooks WHERE webhook_id = ?", webhookID).Scan(&owner)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Webhook not found")
+			return false
+		}
+		reqLogger(r).Error("Database query webhook error", "err", err)
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return false
+	}
+	if owner != userID && !isAdmin(r) {
+		writeError(w, http.StatusNotFound, "Webhook not found")
+		return false
+	}
+	return true
+}
+
+// truncateString 截断过长的字符串
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":"upload.created"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if got := webhookSignature("secret", "1700000000", body); got != want {
+		t.Fatalf("webhookSignature = %s, want %s", got, want)
+	}
+	if webhookSignature("other", "1700000000", body) == want {
+		t.Fatal("signature should depend on the secret")
+	}
+	if webhookSignature("secret", "1700000001", body) == want {
+		t.Fatal("signature should depend on the timestamp")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 80 * time.Second},
+		{8, 1280 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookTargetBlocked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := sendWebhook(context.Background(), srv.URL, "secret", "d1", EventUploadCreated, []byte("{}"))
+	if !errors.Is(err, errWebhookTarget) {
+		t.Fatalf("sendWebhook to loopback: err = %v, want %v", err, errWebhookTarget)
+	}
+}
+
+// webhookReceiver 记录收到的投递，前 fail 次返回 500
+type webhookReceiver struct {
+	mu       sync.Mutex
+	fail     int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	if len(rc.requests) <= rc.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rc *webhookReceiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+// loadDelivery 读取投递的状态、尝试次数与下次尝试时间
+func loadDelivery(t *testing.T, id string) (status string, attempts int, next time.Time) {
+	t.Helper()
+	err := db.QueryRow("SELECT status, attempts, next_attempt_at FROM webhook_deliveries WHERE delivery_id = ?", id).
+		Scan(&status, &attempts, &next)
+	if err != nil {
+		t.Fatalf("load delivery: %v", err)
+	}
+	return status, attempts, next
+}
+
+func TestWebhookRedelivery(t *testing.T) {
+	setupTestDB(t)
+	webhookAllowPrivate = true // httptest 监听在回环地址
+	t.Cleanup(func() { webhookAllowPrivate = false })
+
+	rc := &webhookReceiver{fail: 1}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	_, err := db.Exec("INSERT INTO webhooks (webhook_id, user_id, url, secret, events) VALUES (?, ?, ?, ?, ?)",
+		"wh1", "alice", srv.URL, "s3cret", EventUploadCreated+","+EventUploadCompleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	publishEvent(ctx, EventUploadCreated, "alice", map[string]string{"upload_id": "u1"})
+	publishEvent(ctx, EventFileDeleted, "alice", map[string]string{"file_id": "f1"})   // 未订阅
+	publishEvent(ctx, EventUploadCreated, "bob", map[string]string{"upload_id": "u2"}) // 其他用户
+
+	var deliveryID string
+	if err := db.QueryRow("SELECT delivery_id FROM webhook_deliveries").Scan(&deliveryID); err != nil {
+		t.Fatalf("expected exactly one delivery: %v", err)
+	}
+
+	// 第一次投递失败，按退避时间推迟
+	before := time.Now().UTC()
+	processWebhookOutbox()
+	if rc.count() != 1 {
+		t.Fatalf("receiver got %d requests, want 1", rc.count())
+	}
+	status, attempts, next := loadDelivery(t, deliveryID)
+	if status != DeliveryPending || attempts != 1 {
+		t.Fatalf("after failure: status=%s attempts=%d", status, attempts)
+	}
+	if next.Before(before.Add(webhookBackoff(1))) {
+		t.Fatalf("next_attempt_at %v is earlier than backoff", next)
+	}
+
+	// 未到期时不会重试
+	processWebhookOutbox()
+	if rc.count() != 1 {
+		t.Fatalf("delivery retried before next_attempt_at")
+	}
+
+	// 到期后重试成功
+	if _, err := db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE delivery_id = ?",
+		time.Now().UTC().Add(-time.Second), deliveryID); err != nil {
+		t.Fatal(err)
+	}
+	processWebhookOutbox()
+	if rc.count() != 2 {
+		t.Fatalf("receiver got %d requests, want 2", rc.count())
+	}
+	status, attempts, _ = loadDelivery(t, deliveryID)
+	if status != DeliveryDelivered || attempts != 2 {
+		t.Fatalf("after retry: status=%s attempts=%d", status, attempts)
+	}
+
+	// 校验投递头与签名
+	req, body := rc.requests[1], rc.bodies[1]
+	if req.Header.Get("X-Webhook-Event") != EventUploadCreated || req.Header.Get("X-Webhook-Delivery") != deliveryID {
+		t.Fatalf("unexpected headers: %v", req.Header)
+	}
+	wantSig := "sha256=" + webhookSignature("s3cret", req.Header.Get("X-Webhook-Timestamp"), body)
+	if got := req.Header.Get("X-Webhook-Signature"); got != wantSig {
+		t.Fatalf("signature = %s, want %s", got, wantSig)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Event != EventUploadCreated {
+		t.Fatalf("payload = %s (%v)", body, err)
+	}
+
+	// 手动重新投递：其他用户不可见，所有者可重置为待投递
+	redeliver := func(user string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks/wh1/deliveries/"+deliveryID+"/redeliver", nil)
+		r.Header.Set("X-User-ID", user)
+		r = mux.SetURLVars(r, map[string]string{"webhook_id": "wh1", "delivery_id": deliveryID})
+		w := httptest.NewRecorder()
+		RedeliverWebhook(w, r)
+		return w.Code
+	}
+	if code := redeliver("bob"); code != http.StatusNotFound {
+		t.Fatalf("redeliver by other user: status %d, want 404", code)
+	}
+	if code := redeliver("alice"); code != http.StatusAccepted {
+		t.Fatalf("redeliver: status %d, want 202", code)
+	}
+	status, attempts, _ = loadDelivery(t, deliveryID)
+	if status != DeliveryPending || attempts != 0 {
+		t.Fatalf("after redeliver: status=%s attempts=%d", status, attempts)
+	}
+	processWebhookOutbox()
+	if rc.count() != 3 {
+		t.Fatalf("receiver got %d requests, want 3", rc.count())
+	}
+	if status, _, _ = loadDelivery(t, deliveryID); status != DeliveryDelivered {
+		t.Fatalf("after redelivery: status=%s", status)
+	}
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	setupTestDB(t)
+	webhookAllowPrivate = true
+	t.Cleanup(func() { webhookAllowPrivate = false })
+
+	rc := &webhookReceiver{fail: webhookMaxAttempts}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	if _, err := db.Exec("INSERT INTO webhooks (webhook_id, user_id, url, secret, events) VALUES (?, ?, ?, ?, ?)",
+		"wh1", "alice", srv.URL, "s3cret", EventUploadFailed); err != nil {
+		t.Fatal(err)
+	}
+	publishEvent(context.Background(), EventUploadFailed, "alice", map[string]string{"upload_id": "u1"})
+
+	for i := 0; i < webhookMaxAttempts; i++ {
+		if _, err := db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		processWebhookOutbox()
+	}
+	if rc.count() != webhookMaxAttempts {
+		t.Fatalf("receiver got %d requests, want %d", rc.count(), webhookMaxAttempts)
+	}
+	var status string
+	var code int
+	if err := db.QueryRow("SELECT status, last_status_code FROM webhook_deliveries").Scan(&status, &code); err != nil {
+		t.Fatal(err)
+	}
+	if status != DeliveryFailed || code != http.StatusInternalServerError {
+		t.Fatalf("status=%s last_status_code=%d, want failed/500", status, code)
+	}
+
+	// 重试耗尽后不再投递
+	if _, err := db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	processWebhookOutbox()
+	if rc.count() != webhookMaxAttempts {
+		t.Fatal("failed delivery was retried")
+	}
+}
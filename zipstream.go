@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxZipDownloadFiles 单次打包下载的最大文件数
+var maxZipDownloadFiles = 1000
+
+// storedExtensions 已压缩格式的扩展名，打包时使用 Store 模式避免重复压缩
+var storedExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".aac": true, ".ogg": true, ".flac": true, ".m4a": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".webm": true, ".avi": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".jar": true, ".apk": true,
+}
+
+// ZipDownloadRequest 打包下载请求
+type ZipDownloadRequest struct {
+	UploadIDs []string `json:"upload_ids,omitempty"` // 文件ID列表（与folder二选一）
+	Folder    string   `json:"folder,omitempty"`     // 文件夹（含子文件夹）
+	Name      string   `json:"name,omitempty"`       // 下载文件名（可选）
+}
+
+// zipItem 待打包的文件
+type zipItem struct {
+	file *FileRecord
+	name string // 压缩包内的路径
+}
+
+// DownloadZip 将多个文件或文件夹实时打包为zip流式下载，不落地临时文件
+// 超过4GB的条目与压缩包自动使用ZIP64
+// POST /api/v1/files/archive
+func DownloadZip(w http.ResponseWriter, r *http.Request) {
+	logger := reqLogger(r)
+	ctx := dbCtx(r)
+	// 保留请求体，集群模式下可能需要原样转发给文件所属节点
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var req ZipDownloadRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	folder, err := normalizeFolder(req.Folder)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid folder")
+		return
+	}
+	if (len(req.UploadIDs) == 0) == (folder == "") {
+		writeError(w, http.StatusBadRequest, "Exactly one of upload_ids or folder is required")
+		return
+	}
+	if len(req.UploadIDs) > maxZipDownloadFiles {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Too many files, at most %d", maxZipDownloadFiles))
+		return
+	}
+
+	userID := getUserID(r)
+	var files []*FileRecord
+	if folder != "" {
+		if userID == "" {
+			writeError(w, http.StatusUnauthorized, "Login required")
+			return
+		}
+		if files, err = uploadStore.ListFolderFiles(ctx, userID, folder); err != nil {
+			logger.Error("Database query folder files error", "err", err)
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if len(files) > maxZipDownloadFiles {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Too many files, at most %d", maxZipDownloadFiles))
+			return
+		}
+	} else {
+		for _, id := range req.UploadIDs {
+			file, err := getFileByUploadID(ctx, id)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					writeError(w, http.StatusNotFound, "File not found: "+id)
+					return
+				}
+				logger.Error("Database query error", "err", err)
+				writeError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if file.UserID != "" && file.UserID != userID && !isAdmin(r) {
+				writeError(w, http.StatusForbidden, "Not the owner of file: "+id)
+				return
+			}
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		writeError(w, http.StatusNotFound, "No files to download")
+		return
+	}
+
+	// 开始输出前完成所有校验，输出开始后无法再返回错误响应
+	items := make([]zipItem, 0, len(files))
+	used := map[string]bool{}
+	for _, file := range files {
+		if file.Status != StatusCompleted || file.ScanStatus == ScanInfected || file.ScanStatus == ScanScanning || file.ScanStatus == ScanError {
+			writeError(w, http.StatusConflict, "File is not available for download: "+file.UploadID)
+			return
+		}
+		// 打包下载无法携带各文件的客户端加密信息
+		if file.ClientEncryption != nil {
+			writeError(w, http.StatusConflict, "Client-encrypted file must be downloaded individually: "+file.UploadID)
+			return
+		}
+		name := path.Base(file.FileName)
+		if folder != "" && file.Folder != folder {
+			name = path.Join(strings.TrimPrefix(file.Folder, folder+"/"), name)
+		}
+		items = append(items, zipItem{file: file, name: uniqueZipName(name, used)})
+	}
+
+	// 集群模式下文件存放在其他节点且本机无法读取（未使用共享存储）时，
+	// 全部文件属于同一节点则整体转发，否则无法在一个节点上打包
+	if remote := zipRemoteOwner(files); remote != nil {
+		if !zipSingleOwner(files, remote.OwnerNode) {
+			writeError(w, http.StatusConflict, "Files are stored on different cluster nodes and cannot be zipped together")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if forwardToOwner(w, r, remote) {
+			return
+		}
+	}
+
+	zipName := req.Name
+	if zipName == "" {
+		zipName = "files"
+		if folder != "" {
+			zipName = path.Base(folder)
+		}
+	}
+	if !strings.HasSuffix(strings.ToLower(zipName), ".zip") {
+		zipName += ".zip"
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": path.Base(zipName),
+	}))
+	w.WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(servedWriter{w})
+	for _, item := range items {
+		if err := writeZipEntry(zw, item); err != nil {
+			// 响应已开始，只能中断输出
+			logger.Error("Stream zip entry error", "entry_upload_id", item.file.UploadID, "err", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		logger.Error("Finish zip stream error", "err", err)
+	}
+}
+
+// writeZipEntry 写入单个条目
+func writeZipEntry(zw *zip.Writer, item zipItem) error {
+	f, err := openStoredFile(item.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	recordAccess(item.file)
+
+	method := zip.Deflate
+	if storedExtensions[strings.ToLower(path.Ext(item.name))] {
+		method = zip.Store
+	}
+	hdr := &zip.FileHeader{
+		Name:     item.name,
+		Method:   method,
+		Modified: item.file.UpdatedAt,
+	}
+	hdr.SetMode(0644)
+
+	dst, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+// zipRemoteOwner 返回第一个属于其他节点且本机无法读取的文件，没有时返回 nil
+func zipRemoteOwner(files []*FileRecord) *FileRecord {
+	if clusterNodeID == "" {
+		return nil
+	}
+	for _, file := range files {
+		if file.OwnerNode == "" || file.OwnerNode == clusterNodeID {
+			continue
+		}
+		if _, err := os.Stat(storedFilePath(file)); err != nil {
+			return file
+		}
+	}
+	return nil
+}
+
+// zipSingleOwner 判断文件是否全部属于指定节点
+func zipSingleOwner(files []*FileRecord, nodeID string) bool {
+	for _, file := range files {
+		if file.OwnerNode != nodeID {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueZipName 为重名条目追加序号，如 "a (1).txt"
+func uniqueZipName(name string, used map[string]bool) string {
+	candidate := name
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}